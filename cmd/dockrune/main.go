@@ -23,6 +23,10 @@ func main() {
 	rootCmd.AddCommand(cmd.InitCmd())
 	rootCmd.AddCommand(cmd.DeployCmd())
 	rootCmd.AddCommand(cmd.StatusCmd())
+	rootCmd.AddCommand(cmd.DetectCmd())
+	rootCmd.AddCommand(cmd.CancelCmd())
+	rootCmd.AddCommand(cmd.EnvCmd())
+	rootCmd.AddCommand(cmd.SecretCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
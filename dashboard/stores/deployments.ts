@@ -11,7 +11,7 @@ interface Deployment {
   Ref: string
   SHA: string
   Environment: string
-  Status: 'queued' | 'in_progress' | 'success' | 'failed'
+  Status: 'queued' | 'in_progress' | 'success' | 'failed' | 'cancelled' | 'superseded'
   StartedAt: string
   CompletedAt?: string
   URL?: string
@@ -20,6 +20,19 @@ interface Deployment {
   Error?: string
 }
 
+interface DeploymentStep {
+  ID: number
+  DeploymentID: string
+  Name: string
+  Status: 'in_progress' | 'success' | 'failed'
+  StartedAt: string
+  CompletedAt?: string
+  ExitCode: number | null
+  LogOffset: number
+  LogLength: number
+  Error?: string
+}
+
 interface DeploymentStats {
   total: number
   successful: number
@@ -160,7 +173,7 @@ export const useDeploymentsStore = defineStore('deployments', {
     async stopDeployment(id: string) {
       try {
         const config = useRuntimeConfig()
-        await $fetch(`${config.public.apiBase}/api/deployments/${id}/stop`, {
+        await $fetch(`${config.public.apiBase}/api/deployments/${id}/cancel`, {
           method: 'POST',
           headers: {
             'Authorization': `Bearer ${useAuthStore().token}`
@@ -191,6 +204,37 @@ export const useDeploymentsStore = defineStore('deployments', {
       }
     },
 
+    async fetchSteps(id: string): Promise<DeploymentStep[]> {
+      try {
+        const config = useRuntimeConfig()
+        return await $fetch<DeploymentStep[]>(`${config.public.apiBase}/api/deployments/${id}/steps`, {
+          headers: {
+            'Authorization': `Bearer ${useAuthStore().token}`
+          }
+        })
+      } catch (error) {
+        console.error('Fetch steps error:', error)
+        throw error
+      }
+    },
+
+    // Just the output of one step, e.g. the one that failed
+    async fetchStepLogs(step: DeploymentStep): Promise<string> {
+      try {
+        const config = useRuntimeConfig()
+        return await $fetch<string>(`${config.public.apiBase}/api/deployments/${step.DeploymentID}/logs`, {
+          headers: {
+            'Authorization': `Bearer ${useAuthStore().token}`
+          },
+          query: { offset: step.LogOffset, length: step.LogLength },
+          responseType: 'text'
+        })
+      } catch (error) {
+        console.error('Fetch step logs error:', error)
+        throw error
+      }
+    },
+
     updateDeploymentFromWS(deployment: Deployment) {
       const index = this.deployments.findIndex(d => d.ID === deployment.ID)
       if (index >= 0) {
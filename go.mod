@@ -13,6 +13,7 @@ require (
 	github.com/spf13/viper v1.18.2
 	golang.org/x/crypto v0.17.0
 	golang.org/x/oauth2 v0.15.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -61,5 +62,4 @@ require (
 	google.golang.org/appengine v1.6.8 // indirect
 	google.golang.org/protobuf v1.32.0 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
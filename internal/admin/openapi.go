@@ -116,7 +116,7 @@ func (s *Server) OpenAPISpec(c *gin.Context) {
 							"in": "query",
 							"schema": map[string]interface{}{
 								"type": "string",
-								"enum": []string{"queued", "in_progress", "success", "failed"},
+								"enum": []string{"queued", "in_progress", "success", "failed", "cancelled", "superseded"},
 							},
 						},
 					},
@@ -184,6 +184,22 @@ func (s *Server) OpenAPISpec(c *gin.Context) {
 								"type": "string",
 							},
 						},
+						{
+							"name":        "offset",
+							"in":          "query",
+							"description": "Byte offset to start at, e.g. a step's LogOffset",
+							"schema": map[string]string{
+								"type": "integer",
+							},
+						},
+						{
+							"name":        "length",
+							"in":          "query",
+							"description": "Number of bytes to return, e.g. a step's LogLength",
+							"schema": map[string]string{
+								"type": "integer",
+							},
+						},
 					},
 					"responses": map[string]interface{}{
 						"200": map[string]interface{}{
@@ -199,6 +215,43 @@ func (s *Server) OpenAPISpec(c *gin.Context) {
 					},
 				},
 			},
+			"/api/deployments/{id}/steps": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Get the steps of a deployment, in the order they ran",
+					"tags": []string{"deployments"},
+					"security": []map[string][]string{
+						{"bearerAuth": {}},
+					},
+					"parameters": []map[string]interface{}{
+						{
+							"name": "id",
+							"in": "path",
+							"required": true,
+							"schema": map[string]string{
+								"type": "string",
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Deployment steps",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"type": "array",
+										"items": map[string]interface{}{
+											"$ref": "#/components/schemas/DeploymentStep",
+										},
+									},
+								},
+							},
+						},
+						"404": map[string]interface{}{
+							"description": "Deployment not found",
+						},
+					},
+				},
+			},
 			"/api/deployments/{id}/redeploy": map[string]interface{}{
 				"post": map[string]interface{}{
 					"summary": "Trigger redeployment",
@@ -223,6 +276,86 @@ func (s *Server) OpenAPISpec(c *gin.Context) {
 					},
 				},
 			},
+			"/api/deployments/{id}/cancel": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary": "Cancel a queued or in-progress deployment",
+					"tags": []string{"deployments"},
+					"security": []map[string][]string{
+						{"bearerAuth": {}},
+					},
+					"parameters": []map[string]interface{}{
+						{
+							"name": "id",
+							"in": "path",
+							"required": true,
+							"schema": map[string]string{
+								"type": "string",
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"202": map[string]interface{}{
+							"description": "Deployment cancelled",
+						},
+						"404": map[string]interface{}{
+							"description": "Deployment not found",
+						},
+						"409": map[string]interface{}{
+							"description": "Deployment already finished",
+						},
+					},
+				},
+			},
+			"/api/repos/{owner}/{repo}/detect": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Run every detector against a commit of a repo and explain which one wins",
+					"tags": []string{"repos"},
+					"security": []map[string][]string{
+						{"bearerAuth": {}},
+					},
+					"parameters": []map[string]interface{}{
+						{
+							"name": "owner",
+							"in": "path",
+							"required": true,
+							"schema": map[string]string{
+								"type": "string",
+							},
+						},
+						{
+							"name": "repo",
+							"in": "path",
+							"required": true,
+							"schema": map[string]string{
+								"type": "string",
+							},
+						},
+						{
+							"name": "ref",
+							"in": "query",
+							"description": "Branch, tag or commit, HEAD by default",
+							"schema": map[string]string{
+								"type": "string",
+							},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "What every detector found",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": map[string]interface{}{
+										"$ref": "#/components/schemas/Explanation",
+									},
+								},
+							},
+						},
+						"404": map[string]interface{}{
+							"description": "Repo never deployed, or no such ref",
+						},
+					},
+				},
+			},
 		},
 		"components": map[string]interface{}{
 			"securitySchemes": map[string]interface{}{
@@ -247,7 +380,7 @@ func (s *Server) OpenAPISpec(c *gin.Context) {
 						"github_deployment_id":  map[string]string{"type": "integer"},
 						"status":                map[string]interface{}{
 							"type": "string",
-							"enum": []string{"queued", "in_progress", "success", "failed"},
+							"enum": []string{"queued", "in_progress", "success", "failed", "cancelled", "superseded"},
 						},
 						"started_at":            map[string]string{"type": "string", "format": "date-time"},
 						"completed_at":          map[string]string{"type": "string", "format": "date-time"},
@@ -258,6 +391,59 @@ func (s *Server) OpenAPISpec(c *gin.Context) {
 						"error":                 map[string]string{"type": "string"},
 					},
 				},
+				"DeploymentStep": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"ID":           map[string]string{"type": "integer"},
+						"DeploymentID": map[string]string{"type": "string"},
+						"Name": map[string]interface{}{
+							"type": "string",
+							"enum": []string{"clone", "checkout", "detect", "pre_deploy", "build", "stop_old", "start", "healthcheck", "swap", "post_deploy", "route"},
+						},
+						"Status": map[string]interface{}{
+							"type": "string",
+							"enum": []string{"in_progress", "success", "failed"},
+						},
+						"StartedAt":   map[string]string{"type": "string", "format": "date-time"},
+						"CompletedAt": map[string]string{"type": "string", "format": "date-time"},
+						"ExitCode":    map[string]interface{}{"type": "integer", "nullable": true},
+						"LogOffset":   map[string]string{"type": "integer"},
+						"LogLength":   map[string]string{"type": "integer"},
+						"Error":       map[string]string{"type": "string"},
+					},
+				},
+				"Explanation": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"Source": map[string]string{"type": "string"},
+						"Chosen": map[string]interface{}{"type": "integer", "description": "index into Results, -1 if nothing matched"},
+						"Results": map[string]interface{}{
+							"type": "array",
+							"items": map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"Detector": map[string]string{"type": "string"},
+									"Priority": map[string]string{"type": "integer"},
+									"Error":    map[string]string{"type": "string"},
+									"Detection": map[string]interface{}{
+										"type":     "object",
+										"nullable": true,
+										"properties": map[string]interface{}{
+											"Type":       map[string]string{"type": "string"},
+											"Confidence": map[string]string{"type": "number"},
+											"InstallCmd": map[string]string{"type": "string"},
+											"BuildCmd":   map[string]string{"type": "string"},
+											"StartCmd":   map[string]string{"type": "string"},
+											"Port":       map[string]string{"type": "integer"},
+											"Evidence":   map[string]interface{}{"type": "array", "items": map[string]string{"type": "string"}},
+											"Metadata":   map[string]string{"type": "object"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
 			},
 		},
 	}
@@ -1,10 +1,13 @@
 package admin
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/ejfox/dockrune/internal/config"
@@ -69,8 +72,17 @@ func (s *Server) Start() error {
 		api.GET("/deployments", s.getDeployments)
 		api.GET("/deployments/:id", s.getDeployment)
 		api.POST("/deployments/:id/redeploy", s.redeployDeployment)
-		api.POST("/deployments/:id/stop", s.stopDeployment)
+		api.POST("/deployments/:id/cancel", s.cancelDeployment)
+		api.POST("/deployments/:id/stop", s.cancelDeployment) // older dashboards
 		api.GET("/deployments/:id/logs", s.getDeploymentLogs)
+		api.GET("/deployments/:id/steps", s.getDeploymentSteps)
+		api.GET("/repos/:owner/:repo/detect", s.detectRepo)
+		api.GET("/repos/:owner/:repo/env", s.getEnvVars)
+		api.PUT("/repos/:owner/:repo/env/:key", s.setEnvVar)
+		api.DELETE("/repos/:owner/:repo/env/:key", s.deleteEnvVar)
+		api.GET("/repos/:owner/:repo/secrets", s.getSecrets)
+		api.PUT("/repos/:owner/:repo/secrets/:name", s.setSecret)
+		api.DELETE("/repos/:owner/:repo/secrets/:name", s.deleteSecret)
 		api.GET("/ws", s.handleWebSocket)
 	}
 
@@ -198,6 +210,7 @@ func (s *Server) redeployDeployment(c *gin.Context) {
 		SHA:         deployment.SHA,
 		CloneURL:    deployment.CloneURL,
 		Environment: deployment.Environment,
+		PRNumber:    deployment.PRNumber,
 	}
 
 	if err := s.deployer.QueueDeployment(newDeployment); err != nil {
@@ -208,7 +221,7 @@ func (s *Server) redeployDeployment(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Redeployment queued", "id": newDeployment.ID})
 }
 
-func (s *Server) stopDeployment(c *gin.Context) {
+func (s *Server) cancelDeployment(c *gin.Context) {
 	id := c.Param("id")
 	deployment, err := s.storage.GetDeployment(id)
 	if err != nil {
@@ -216,9 +229,16 @@ func (s *Server) stopDeployment(c *gin.Context) {
 		return
 	}
 
-	// Stop the deployment
-	// This would call the deployer's stop method
-	c.JSON(http.StatusOK, gin.H{"message": "Deployment stopped", "id": deployment.ID})
+	if err := s.deployer.Cancel(deployment.ID); err != nil {
+		if errors.Is(err, deployer.ErrNotCancellable) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": deployment.Status})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Deployment cancelled", "id": deployment.ID})
 }
 
 func (s *Server) getDeploymentLogs(c *gin.Context) {
@@ -237,9 +257,188 @@ func (s *Server) getDeploymentLogs(c *gin.Context) {
 	}
 	defer file.Close()
 
+	// offset and length select part of the log, e.g. one step's output
+	var logs io.Reader = file
+	if offset := c.Query("offset"); offset != "" {
+		n, err := strconv.ParseInt(offset, 10, 64)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+		file.Seek(n, io.SeekStart)
+	}
+	if length := c.Query("length"); length != "" {
+		n, err := strconv.ParseInt(length, 10, 64)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid length"})
+			return
+		}
+		logs = io.LimitReader(file, n)
+	}
+
 	// Stream logs
 	c.Header("Content-Type", "text/plain")
-	io.Copy(c.Writer, file)
+	io.Copy(c.Writer, logs)
+}
+
+func (s *Server) getDeploymentSteps(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := s.storage.GetDeployment(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+
+	steps, err := s.storage.ListDeploymentSteps(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get deployment steps"})
+		return
+	}
+	if steps == nil {
+		steps = []*models.DeploymentStep{}
+	}
+
+	c.JSON(http.StatusOK, steps)
+}
+
+// detectRepo runs every detector against ?ref= of a repo, HEAD by default,
+// and returns what each of them found.
+func (s *Server) detectRepo(c *gin.Context) {
+	ref := c.DefaultQuery("ref", "HEAD")
+	explanation, err := s.deployer.DetectRepo(c.Request.Context(), c.Param("owner"), c.Param("repo"), ref)
+	if err != nil {
+		if errors.Is(err, deployer.ErrUnknownRepo) || errors.Is(err, deployer.ErrUnknownRef) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, explanation)
+}
+
+// getEnvVars lists the variables of a repo. ?environment= selects an
+// environment's own variables instead of the repo-wide ones.
+func (s *Server) getEnvVars(c *gin.Context) {
+	vars, err := s.storage.ListEnvVars(c.Param("owner"), c.Param("repo"), c.Query("environment"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get environment variables"})
+		return
+	}
+	if vars == nil {
+		vars = []*models.EnvVar{}
+	}
+
+	c.JSON(http.StatusOK, vars)
+}
+
+func (s *Server) setEnvVar(c *gin.Context) {
+	var req struct {
+		Value string `json:"value"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	v := &models.EnvVar{
+		Owner:       c.Param("owner"),
+		Repo:        c.Param("repo"),
+		Environment: c.Query("environment"),
+		Key:         c.Param("key"),
+		Value:       req.Value,
+	}
+	if err := deployer.ValidateEnvKey(v.Key); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.storage.SetEnvVar(v); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set environment variable"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Environment variable set", "key": v.Key})
+}
+
+func (s *Server) deleteEnvVar(c *gin.Context) {
+	err := s.storage.DeleteEnvVar(c.Param("owner"), c.Param("repo"), c.Query("environment"), c.Param("key"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Environment variable not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete environment variable"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Environment variable deleted", "key": c.Param("key")})
+}
+
+// getSecrets lists the secrets of a repo. Values are never returned.
+func (s *Server) getSecrets(c *gin.Context) {
+	secrets, err := s.storage.ListSecrets(c.Param("owner"), c.Param("repo"), c.Query("environment"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get secrets"})
+		return
+	}
+	if secrets == nil {
+		secrets = []*models.Secret{}
+	}
+
+	c.JSON(http.StatusOK, secrets)
+}
+
+// setSecret stores a secret. With a path it is written to that file in the
+// working tree instead of being set as an environment variable.
+func (s *Server) setSecret(c *gin.Context) {
+	var req struct {
+		Value string `json:"value"`
+		Path  string `json:"path"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	secret := &models.Secret{
+		Owner:       c.Param("owner"),
+		Repo:        c.Param("repo"),
+		Environment: c.Query("environment"),
+		Name:        c.Param("name"),
+		Path:        req.Path,
+		Value:       req.Value,
+	}
+	if err := deployer.ValidateSecret(secret.Name, secret.Path); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := s.storage.SetSecret(secret)
+	if errors.Is(err, storage.ErrSecretsDisabled) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Secrets are disabled, set SECRETS_KEY"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Secret set", "name": secret.Name})
+}
+
+func (s *Server) deleteSecret(c *gin.Context) {
+	err := s.storage.DeleteSecret(c.Param("owner"), c.Param("repo"), c.Query("environment"), c.Param("name"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Secret not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Secret deleted", "name": c.Param("name")})
 }
 
 func (s *Server) handleWebSocket(c *gin.Context) {
@@ -127,6 +127,101 @@ func (m *Manager) SendDeploymentFailure(project, environment, ref, sha, error, l
 	return nil
 }
 
+func (m *Manager) SendDeploymentRollback(project, environment, failedSHA, rollbackSHA, reason, url string) error {
+	if m.discordURL != "" {
+		fields := []DiscordField{
+			{Name: "Project", Value: project, Inline: true},
+			{Name: "Environment", Value: environment, Inline: true},
+			{Name: "Failed Commit", Value: fmt.Sprintf("`%s`", failedSHA[:7]), Inline: true},
+			{Name: "Rolled Back To", Value: fmt.Sprintf("`%s`", rollbackSHA[:7]), Inline: true},
+			{Name: "Reason", Value: reason, Inline: false},
+		}
+
+		if url != "" {
+			fields = append(fields, DiscordField{
+				Name: "URL", Value: fmt.Sprintf("[View Deployment](%s)", url), Inline: false,
+			})
+		}
+
+		embed := DiscordEmbed{
+			Title:       "Deployment Rolled Back ↩️",
+			Description: fmt.Sprintf("**%s** on **%s** was rolled back to `%s`", project, environment, rollbackSHA[:7]),
+			Color:       0xfd7e14, // Orange
+			Fields:      fields,
+			Timestamp:   time.Now().Format(time.RFC3339),
+			Footer: DiscordFooter{
+				Text: "dockrune deployment system",
+			},
+		}
+
+		if err := m.sendDiscordWebhook(embed); err != nil {
+			return fmt.Errorf("failed to send Discord alert: %w", err)
+		}
+	}
+
+	if m.n8nURL != "" {
+		payload := map[string]interface{}{
+			"event":        "deployment_rollback",
+			"project":      project,
+			"environment":  environment,
+			"failed_sha":   failedSHA,
+			"rollback_sha": rollbackSHA,
+			"reason":       reason,
+			"url":          url,
+			"timestamp":    time.Now().Unix(),
+		}
+
+		if err := m.sendN8NWebhook(payload); err != nil {
+			return fmt.Errorf("failed to send n8n alert: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) SendDeploymentCancelled(project, environment, ref, sha, reason string) error {
+	if m.discordURL != "" {
+		embed := DiscordEmbed{
+			Title:       "Deployment Cancelled ⏹️",
+			Description: fmt.Sprintf("**%s** deployment to **%s** was cancelled", project, environment),
+			Color:       0x6c757d, // Grey
+			Fields: []DiscordField{
+				{Name: "Project", Value: project, Inline: true},
+				{Name: "Environment", Value: environment, Inline: true},
+				{Name: "Ref", Value: ref, Inline: true},
+				{Name: "Commit", Value: fmt.Sprintf("`%s`", sha[:7]), Inline: true},
+				{Name: "Reason", Value: reason, Inline: false},
+			},
+			Timestamp: time.Now().Format(time.RFC3339),
+			Footer: DiscordFooter{
+				Text: "dockrune deployment system",
+			},
+		}
+
+		if err := m.sendDiscordWebhook(embed); err != nil {
+			return fmt.Errorf("failed to send Discord alert: %w", err)
+		}
+	}
+
+	if m.n8nURL != "" {
+		payload := map[string]interface{}{
+			"event":       "deployment_cancelled",
+			"project":     project,
+			"environment": environment,
+			"ref":         ref,
+			"sha":         sha,
+			"reason":      reason,
+			"timestamp":   time.Now().Unix(),
+		}
+
+		if err := m.sendN8NWebhook(payload); err != nil {
+			return fmt.Errorf("failed to send n8n alert: %w", err)
+		}
+	}
+
+	return nil
+}
+
 func (m *Manager) sendDiscordWebhook(embed DiscordEmbed) error {
 	webhook := DiscordWebhook{
 		Username: "dockrune",
@@ -0,0 +1,249 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+)
+
+// LetsEncryptURL is the default ACME directory.
+const LetsEncryptURL = acme.LetsEncryptURL
+
+// challengePath is where http-01 challenges are fetched from.
+const challengePath = "/.well-known/acme-challenge/"
+
+type acmeClient struct {
+	*acme.Client
+	directory string // the DirectoryURL the account was registered with
+}
+
+// TrustingClient returns an HTTP client that also trusts the CA
+// certificates in the PEM file caFile, for ACME servers such as Pebble
+// that use a private CA.
+func TrustingClient(caFile string) (*http.Client, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
+}
+
+// HTTPHandler answers the CA's http-01 challenges and passes everything
+// else to fallback, or answers it with 404 if fallback is nil.
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+	if fallback == nil {
+		fallback = http.NotFoundHandler()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.URL.Path, challengePath)
+		if !ok {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		m.mu.Lock()
+		response, ok := m.tokens[token]
+		m.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(response))
+	})
+}
+
+// client returns the ACME client, registering the account on first use.
+// The account key is kept with the certificates. m.issuing must be held.
+func (m *Manager) client(ctx context.Context) (*acme.Client, error) {
+	if m.acme != nil && m.acme.directory == m.DirectoryURL {
+		return m.acme.Client, nil
+	}
+
+	key, err := m.accountKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ACME account key: %w", err)
+	}
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: m.DirectoryURL,
+		HTTPClient:   m.HTTPClient,
+		UserAgent:    "dockrune",
+	}
+
+	account := &acme.Account{}
+	if m.Email != "" {
+		account.Contact = []string{"mailto:" + m.Email}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("failed to register ACME account with %s: %w", m.DirectoryURL, err)
+	}
+
+	m.acme = &acmeClient{Client: client, directory: m.DirectoryURL}
+	return client, nil
+}
+
+// accountKey loads the ACME account key, creating it the first time.
+func (m *Manager) accountKey() (crypto.Signer, error) {
+	path := filepath.Join(m.dir, "acme", "account.key")
+	if data, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no key in %s", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return key, writeFile(path, encodePEM("EC PRIVATE KEY", der), 0600)
+}
+
+// obtain gets a certificate for name from the ACME CA and stores it.
+// m.issuing must be held.
+func (m *Manager) obtain(ctx context.Context, name string) error {
+	client, err := m.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(name))
+	if err != nil {
+		return fmt.Errorf("failed to order certificate: %w", err)
+	}
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return err
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+		if err := m.solve(ctx, client, authz); err != nil {
+			return fmt.Errorf("failed to validate %s: %w", authz.Identifier.Value, err)
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{name}}, key)
+	if err != nil {
+		return err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("failed to finalize order: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return m.store(name, encodePEM("CERTIFICATE", chain...), encodePEM("EC PRIVATE KEY", keyDER))
+}
+
+// solve completes one authorization: over dns-01 for wildcards or when
+// there is a DNS hook, over http-01 otherwise.
+func (m *Manager) solve(ctx context.Context, client *acme.Client, authz *acme.Authorization) error {
+	kind := "http-01"
+	if authz.Wildcard || m.DNSHook != "" {
+		kind = "dns-01"
+	}
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == kind {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("CA offered no %s challenge", kind)
+	}
+
+	switch kind {
+	case "http-01":
+		response, err := client.HTTP01ChallengeResponse(challenge.Token)
+		if err != nil {
+			return err
+		}
+		m.mu.Lock()
+		m.tokens[challenge.Token] = response
+		m.mu.Unlock()
+		defer func() {
+			m.mu.Lock()
+			delete(m.tokens, challenge.Token)
+			m.mu.Unlock()
+		}()
+
+	case "dns-01":
+		if m.DNSHook == "" {
+			return errors.New("wildcard certificates need a DNS hook")
+		}
+		value, err := client.DNS01ChallengeRecord(challenge.Token)
+		if err != nil {
+			return err
+		}
+		record := "_acme-challenge." + authz.Identifier.Value
+		if err := m.runDNSHook(ctx, "present", record, value); err != nil {
+			return err
+		}
+		defer m.runDNSHook(context.Background(), "cleanup", record, value)
+	}
+
+	if _, err := client.Accept(ctx, challenge); err != nil {
+		return err
+	}
+	_, err := client.WaitAuthorization(ctx, authz.URI)
+	return err
+}
+
+// runDNSHook runs the DNS hook to add or remove a challenge record.
+func (m *Manager) runDNSHook(ctx context.Context, action, record, value string) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", m.DNSHook+` "$@"`, "sh", action, record, value)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("DNS hook %s failed: %w: %s", action, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
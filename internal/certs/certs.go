@@ -0,0 +1,287 @@
+// Package certs provides TLS certificates for deployed hostnames, from an
+// ACME CA or from a local CA for internal domains. Certificates are kept on
+// disk, one directory per name, so that external proxies can load them
+// too.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Modes certificates can be provided in.
+const (
+	ModeACME       = "acme"        // from an ACME CA, self-signed for internal domains
+	ModeSelfSigned = "self-signed" // from dockrune's local CA only
+)
+
+// DefaultRenewBefore is how long before it expires a certificate is renewed.
+const DefaultRenewBefore = 30 * 24 * time.Hour
+
+// obtainTimeout bounds getting a certificate from an ACME CA. The ACME
+// client retries failed requests until its context is done.
+const obtainTimeout = 5 * time.Minute
+
+// internalSuffixes are domains no public CA will issue for.
+var internalSuffixes = []string{".localhost", ".local", ".internal", ".lan", ".home.arpa"}
+
+// Manager issues, stores and renews certificates.
+type Manager struct {
+	// DirectoryURL is the ACME directory, e.g. Let's Encrypt's or a local
+	// Pebble's.
+	DirectoryURL string
+	// Email is the ACME account's contact, optional.
+	Email string
+	// HTTPClient talks to the ACME CA; nil for http.DefaultClient.
+	HTTPClient *http.Client
+	// DNSHook solves dns-01 challenges instead of http-01: a shell command
+	// run with "present" or "cleanup", the record name and its value. It
+	// returns once the record is visible. Wildcard certificates need it.
+	DNSHook string
+	// Wildcard is a domain whose direct subdomains share one wildcard
+	// certificate; empty for a certificate per host.
+	Wildcard string
+	// RenewBefore is how long before expiry a certificate is renewed.
+	RenewBefore time.Duration
+
+	mode string
+	dir  string
+
+	issuing sync.Mutex // serializes issuance and ACME account setup
+	acme    *acmeClient
+
+	mu     sync.Mutex
+	certs  map[string]*tls.Certificate // loaded, by name
+	tokens map[string]string           // http-01 key authorizations, by token
+}
+
+// New returns a Manager in mode keeping its certificates under dir.
+func New(mode, dir string) (*Manager, error) {
+	if mode != ModeACME && mode != ModeSelfSigned {
+		return nil, fmt.Errorf("unknown TLS mode %q (want %s or %s)", mode, ModeACME, ModeSelfSigned)
+	}
+	return &Manager{
+		DirectoryURL: LetsEncryptURL,
+		RenewBefore:  DefaultRenewBefore,
+		mode:         mode,
+		dir:          dir,
+		certs:        make(map[string]*tls.Certificate),
+		tokens:       make(map[string]string),
+	}, nil
+}
+
+// Mode is the mode the manager was created with.
+func (m *Manager) Mode() string {
+	return m.mode
+}
+
+// Name is the name of the certificate that covers host: a wildcard for a
+// direct subdomain of Wildcard, host itself otherwise.
+func (m *Manager) Name(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if m.Wildcard == "" {
+		return host
+	}
+	parent := strings.ToLower(m.Wildcard)
+	if sub, ok := strings.CutSuffix(host, "."+parent); ok && sub != "" && !strings.Contains(sub, ".") {
+		return "*." + parent
+	}
+	return host
+}
+
+// Internal reports whether name is in a domain no public CA issues for.
+func Internal(name string) bool {
+	name = strings.TrimPrefix(name, "*.")
+	if net.ParseIP(name) != nil || !strings.Contains(name, ".") {
+		return true
+	}
+	for _, suffix := range internalSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// paths returns where the certificate chain and key of name are stored.
+func (m *Manager) paths(name string) (certFile, keyFile string) {
+	dir := filepath.Join(m.dir, strings.Replace(name, "*", "_wildcard", 1))
+	return filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+}
+
+// Files returns the certificate and key files covering host, if there are
+// any.
+func (m *Manager) Files(host string) (certFile, keyFile string, ok bool) {
+	name := m.Name(host)
+	if m.cached(name) == nil {
+		return "", "", false
+	}
+	certFile, keyFile = m.paths(name)
+	return certFile, keyFile, true
+}
+
+// Valid reports whether host has a certificate that isn't due for renewal.
+func (m *Manager) Valid(host string) bool {
+	name := m.Name(host)
+	cert := m.cached(name)
+	return cert != nil && !m.dueForRenewal(name, cert.Leaf, time.Now())
+}
+
+// cached returns the certificate of name, loading it from disk the first
+// time. It returns nil if there is none.
+func (m *Manager) cached(name string) *tls.Certificate {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cert, ok := m.certs[name]; ok {
+		return cert
+	}
+	cert, err := loadPair(m.paths(name))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Ignoring stored certificate for %s: %v", name, err)
+		}
+		return nil
+	}
+	m.certs[name] = cert
+	return cert
+}
+
+// dueForRenewal reports whether the certificate of name should be replaced:
+// it's close to expiry, or it's a self-signed stand-in for one an ACME CA
+// failed to issue.
+func (m *Manager) dueForRenewal(name string, leaf *x509.Certificate, now time.Time) bool {
+	if now.Add(m.RenewBefore).After(leaf.NotAfter) {
+		return true
+	}
+	return m.mode == ModeACME && !Internal(name) && isLocal(leaf)
+}
+
+// Ensure makes sure host has a certificate that isn't due for renewal,
+// issuing one if needed, and reports whether it issued one. If the ACME CA
+// fails to issue a first certificate, host gets a self-signed one until
+// the next attempt, and the CA's error is returned along with issued.
+func (m *Manager) Ensure(ctx context.Context, host string) (issued bool, err error) {
+	name := m.Name(host)
+
+	m.issuing.Lock()
+	defer m.issuing.Unlock()
+
+	current := m.cached(name)
+	if current != nil && !m.dueForRenewal(name, current.Leaf, time.Now()) {
+		return false, nil
+	}
+
+	if m.mode == ModeSelfSigned || Internal(name) {
+		if err := m.selfSign(name); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	obtainCtx, cancel := context.WithTimeout(ctx, obtainTimeout)
+	acmeErr := m.obtain(obtainCtx, name)
+	cancel()
+	if acmeErr == nil {
+		return true, nil
+	}
+	if current != nil && time.Now().Before(current.Leaf.NotAfter) {
+		return false, fmt.Errorf("failed to renew certificate for %s: %w", name, acmeErr)
+	}
+	if err := m.selfSign(name); err != nil {
+		return false, errors.Join(acmeErr, err)
+	}
+	return true, fmt.Errorf("failed to get certificate for %s, serving a self-signed one until the next attempt: %w", name, acmeErr)
+}
+
+// GetCertificate returns the stored certificate for the server name of a
+// TLS handshake, for tls.Config.GetCertificate.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hello.ServerName == "" {
+		return nil, errors.New("no server name")
+	}
+	if cert := m.cached(m.Name(hello.ServerName)); cert != nil {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("no certificate for %s", hello.ServerName)
+}
+
+// store writes the PEM-encoded chain and key of name and starts serving
+// them.
+func (m *Manager) store(name string, certPEM, keyPEM []byte) error {
+	cert, err := parsePair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	certFile, keyFile := m.paths(name)
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return err
+	}
+	if err := writeFile(keyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+	if err := writeFile(certFile, certPEM, 0644); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.certs[name] = cert
+	m.mu.Unlock()
+	return nil
+}
+
+func loadPair(certFile, keyFile string) (*tls.Certificate, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return parsePair(certPEM, keyPEM)
+}
+
+// parsePair parses a certificate chain and key, with the leaf filled in.
+func parsePair(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// writeFile replaces path with data, so that a proxy reading it never sees
+// a partial write.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func encodePEM(blockType string, ders ...[]byte) []byte {
+	var out []byte
+	for _, der := range ders {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})...)
+	}
+	return out
+}
@@ -0,0 +1,198 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	m, err := New(ModeSelfSigned, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issued, err := m.Ensure(context.Background(), "site.example.com")
+	if err != nil || !issued {
+		t.Fatalf("Ensure() = %v, %v; want a new certificate", issued, err)
+	}
+	if issued, _ := m.Ensure(context.Background(), "site.example.com"); issued {
+		t.Error("Ensure() issued again while the certificate is valid")
+	}
+
+	// A new manager finds the certificate on disk, and it chains up to the
+	// local CA
+	m, _ = New(ModeSelfSigned, dir)
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "SITE.example.com"})
+	if err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+	caPEM, err := os.ReadFile(dir + "/ca/cert.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: "site.example.com", Roots: roots}); err != nil {
+		t.Errorf("certificate doesn't verify against the local CA: %v", err)
+	}
+
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"}); err == nil {
+		t.Error("GetCertificate() for a host without a certificate should fail")
+	}
+	if _, _, ok := m.Files("site.example.com"); !ok {
+		t.Error("Files() found no certificate")
+	}
+}
+
+func TestWildcardName(t *testing.T) {
+	m, _ := New(ModeSelfSigned, t.TempDir())
+	m.Wildcard = "example.com"
+
+	for host, want := range map[string]string{
+		"pr-7.example.com":  "*.example.com",
+		"Site.Example.com.": "*.example.com",
+		"example.com":       "example.com",
+		"a.b.example.com":   "a.b.example.com",
+		"app.ejfox.com":     "app.ejfox.com",
+		"notexample.com":    "notexample.com",
+	} {
+		if got := m.Name(host); got != want {
+			t.Errorf("Name(%q) = %q, want %q", host, got, want)
+		}
+	}
+
+	// Every subdomain is served by the one certificate
+	if _, err := m.Ensure(context.Background(), "pr-7.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Valid("pr-8.example.com") {
+		t.Error("wildcard certificate doesn't cover another subdomain")
+	}
+}
+
+func TestInternal(t *testing.T) {
+	for name, want := range map[string]bool{
+		"localhost":           true,
+		"site.localhost":      true,
+		"printer.lan":         true,
+		"*.dev.internal":      true,
+		"10.0.0.7":            true,
+		"site.example.com":    false,
+		"*.example.com":       false,
+		"pebble.example.test": false,
+	} {
+		if got := Internal(name); got != want {
+			t.Errorf("Internal(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestACMEFallback(t *testing.T) {
+	ca := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer ca.Close()
+
+	m, _ := New(ModeACME, t.TempDir())
+	m.DirectoryURL = ca.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	issued, err := m.Ensure(ctx, "site.example.com")
+	if err == nil || !issued {
+		t.Fatalf("Ensure() = %v, %v; want a stand-in certificate and the CA's error", issued, err)
+	}
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "site.example.com"})
+	if err != nil || !isLocal(cert.Leaf) {
+		t.Fatalf("GetCertificate() = %v, want the self-signed stand-in", err)
+	}
+	if m.Valid("site.example.com") {
+		t.Error("a stand-in certificate should be due for renewal right away")
+	}
+
+	// Internal names never go to the CA
+	if _, err := m.Ensure(context.Background(), "site.internal"); err != nil {
+		t.Errorf("Ensure() for an internal name error = %v", err)
+	}
+	if !m.Valid("site.internal") {
+		t.Error("internal name's self-signed certificate should count as valid")
+	}
+}
+
+func TestRenewal(t *testing.T) {
+	m, _ := New(ModeSelfSigned, t.TempDir())
+	if _, err := m.Ensure(context.Background(), "site.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	leaf := m.cached("site.example.com").Leaf
+
+	now := time.Now()
+	if m.dueForRenewal("site.example.com", leaf, now) {
+		t.Error("fresh certificate is due for renewal")
+	}
+	if !m.dueForRenewal("site.example.com", leaf, leaf.NotAfter.Add(-m.RenewBefore+time.Hour)) {
+		t.Error("certificate inside the renewal window isn't due")
+	}
+}
+
+func TestHTTPChallenge(t *testing.T) {
+	m, _ := New(ModeACME, t.TempDir())
+	m.tokens["abc"] = "abc.thumbprint"
+	handler := m.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("app"))
+	}))
+
+	for path, want := range map[string]string{
+		"/.well-known/acme-challenge/abc": "abc.thumbprint",
+		"/":                               "app",
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://site.example.com"+path, nil))
+		if rec.Body.String() != want {
+			t.Errorf("GET %s = %q, want %q", path, rec.Body.String(), want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://site.example.com/.well-known/acme-challenge/nope", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown token = %d, want 404", rec.Code)
+	}
+}
+
+// TestPebble gets a real certificate from the ACME server at
+// DOCKRUNE_TEST_ACME_DIRECTORY, e.g. a local Pebble started with
+// PEBBLE_VA_ALWAYS_VALID=1. DOCKRUNE_TEST_ACME_CA is its CA certificate.
+func TestPebble(t *testing.T) {
+	directory := os.Getenv("DOCKRUNE_TEST_ACME_DIRECTORY")
+	if directory == "" {
+		t.Skip("DOCKRUNE_TEST_ACME_DIRECTORY not set")
+	}
+
+	m, _ := New(ModeACME, t.TempDir())
+	m.DirectoryURL = directory
+	if caFile := os.Getenv("DOCKRUNE_TEST_ACME_CA"); caFile != "" {
+		client, err := TrustingClient(caFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.HTTPClient = client
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := m.Ensure(ctx, "site.example.com"); err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "site.example.com"})
+	if err != nil || isLocal(cert.Leaf) {
+		t.Errorf("GetCertificate() = %v, want a certificate from the CA", err)
+	}
+}
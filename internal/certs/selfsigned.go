@@ -0,0 +1,116 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// localCAName is the common name of dockrune's local CA. Trusting its
+// certificate, ca/cert.pem under the certificate store, makes browsers
+// accept every self-signed certificate dockrune hands out.
+const localCAName = "dockrune local CA"
+
+// Lifetimes of local certificates. Leaves are renewed like ACME ones.
+const (
+	localCALifetime   = 10 * 365 * 24 * time.Hour
+	localLeafLifetime = 90 * 24 * time.Hour
+)
+
+// isLocal reports whether leaf was signed by a dockrune local CA.
+func isLocal(leaf *x509.Certificate) bool {
+	return leaf.Issuer.CommonName == localCAName
+}
+
+// selfSign issues a certificate for name from the local CA and stores it.
+// m.issuing must be held.
+func (m *Manager) selfSign(name string) error {
+	ca, err := m.localCA()
+	if err != nil {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(localLeafLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Leaf, &key.PublicKey, ca.PrivateKey)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return m.store(name, encodePEM("CERTIFICATE", der, ca.Certificate[0]), encodePEM("EC PRIVATE KEY", keyDER))
+}
+
+// localCA loads the local CA, creating it the first time.
+func (m *Manager) localCA() (*tls.Certificate, error) {
+	certFile := filepath.Join(m.dir, "ca", "cert.pem")
+	keyFile := filepath.Join(m.dir, "ca", "key.pem")
+	if ca, err := loadPair(certFile, keyFile); err == nil {
+		return ca, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: localCAName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(localCALifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM, keyPEM := encodePEM("CERTIFICATE", der), encodePEM("EC PRIVATE KEY", keyDER)
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return nil, err
+	}
+	if err := writeFile(keyFile, keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err := writeFile(certFile, certPEM, 0644); err != nil {
+		return nil, err
+	}
+	return parsePair(certPEM, keyPEM)
+}
+
+func serialNumber() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
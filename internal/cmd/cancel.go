@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ejfox/dockrune/internal/config"
+	"github.com/ejfox/dockrune/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+func CancelCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "cancel <deployment-id>",
+		Short: "Cancel a queued or running deployment",
+		Long: `Cancel a deployment on the running dockrune daemon. Queued deployments are
+dropped; in-progress ones are killed along with every process they started.
+The ID may be shortened to any unique prefix, as shown by "dockrune status".`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCancel(args[0])
+		},
+	}
+}
+
+func runCancel(id string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	id, err = resolveDeploymentID(cfg, id)
+	if err != nil {
+		return err
+	}
+
+	client, err := newAdminClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := client.do("POST", "/api/deployments/"+id+"/cancel", nil, nil); err != nil {
+		return fmt.Errorf("failed to cancel %s: %w", id, err)
+	}
+
+	fmt.Printf("Cancelled deployment %s\n", id)
+	return nil
+}
+
+// resolveDeploymentID expands a deployment ID prefix to the full ID of a
+// recent deployment.
+func resolveDeploymentID(cfg *config.Config, prefix string) (string, error) {
+	store, err := storage.NewSQLiteStorage(cfg.DatabasePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer store.Close()
+
+	deployments, err := store.ListDeployments(100)
+	if err != nil {
+		return "", fmt.Errorf("failed to list deployments: %w", err)
+	}
+
+	var matches []string
+	for _, d := range deployments {
+		if d.ID == prefix {
+			return d.ID, nil
+		}
+		if strings.HasPrefix(d.ID, prefix) {
+			matches = append(matches, d.ID)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no recent deployment matches %q", prefix)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("%q is ambiguous: matches %s", prefix, strings.Join(matches, ", "))
+	}
+}
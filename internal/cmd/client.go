@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ejfox/dockrune/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// adminClient talks to the admin API of the dockrune daemon running on this
+// host, signing its own short-lived token with the configured JWT secret.
+type adminClient struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func newAdminClient(cfg *config.Config) (*adminClient, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"username": cfg.AdminUsername,
+		"exp":      time.Now().Add(time.Minute).Unix(),
+	})
+	tokenString, err := token.SignedString([]byte(cfg.JWTSecret))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return &adminClient{
+		baseURL: fmt.Sprintf("http://localhost:%d", cfg.AdminPort),
+		token:   tokenString,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// do sends a request to path and decodes the JSON response into out. Non-2xx
+// responses are returned as errors carrying the API's error message.
+func (c *adminClient) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach dockrune at %s (is it running?): %w", c.baseURL, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s", apiErr.Error)
+		}
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ejfox/dockrune/internal/config"
+	"github.com/ejfox/dockrune/internal/detector"
+	"github.com/spf13/cobra"
+)
+
+func DetectCmd() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "detect [path | owner/repo[@ref]]",
+		Short: "Show how a project is detected and why",
+		Long: `Run every detector against a directory, the current one by default, and show
+what each of them found: its confidence, the evidence it matched and the
+build command, start command and port it would use. The detection marked *
+is the one a deployment would use. Detectors declared in the config file
+are included.
+
+Given owner/repo@ref instead, the running dockrune daemon checks that ref
+(HEAD by default) out of its mirror of the repo and explains it.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			target := "."
+			if len(args) > 0 {
+				target = args[0]
+			}
+			return runDetect(target, asJSON)
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the explanation as JSON")
+
+	return cmd
+}
+
+func runDetect(target string, asJSON bool) error {
+	var explanation *detector.Explanation
+	var err error
+	if info, statErr := os.Stat(target); statErr == nil && info.IsDir() {
+		explanation, err = detectDir(target)
+	} else if repo, ref, ok := strings.Cut(target, "@"); ok || strings.Count(target, "/") == 1 {
+		explanation, err = detectRepo(repo, ref)
+	} else {
+		return fmt.Errorf("%s is neither a directory nor owner/repo@ref", target)
+	}
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(explanation)
+	}
+	explanation.Write(os.Stdout)
+	return nil
+}
+
+// detectDir explains a local directory. The config is only needed for its
+// detectors, so without one the built-in detectors still run.
+func detectDir(dir string) (*detector.Explanation, error) {
+	var custom []detector.Detector
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to load config, using the built-in detectors only: %v\n", err)
+	} else if custom, err = detector.NewCustomDetectors(cfg.Detectors); err != nil {
+		return nil, fmt.Errorf("invalid detectors in config: %w", err)
+	}
+
+	explanation := detector.NewManager(custom...).Explain(dir)
+	if abs, err := filepath.Abs(dir); err == nil {
+		explanation.Source = abs
+	}
+	return explanation, nil
+}
+
+// detectRepo asks the daemon to explain ref of repo.
+func detectRepo(repo, ref string) (*detector.Explanation, error) {
+	path, err := repoAPIPath(repo, "detect", "", "")
+	if err != nil {
+		return nil, err
+	}
+	if ref != "" {
+		path += "?ref=" + url.QueryEscape(ref)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	client, err := newAdminClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var explanation detector.Explanation
+	if err := client.do("GET", path, nil, &explanation); err != nil {
+		return nil, fmt.Errorf("failed to detect %s: %w", repo, err)
+	}
+	return &explanation, nil
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ejfox/dockrune/internal/config"
+	"github.com/ejfox/dockrune/internal/models"
+	"github.com/spf13/cobra"
+)
+
+func EnvCmd() *cobra.Command {
+	var environment string
+
+	cmd := &cobra.Command{
+		Use:   "env",
+		Short: "Manage environment variables of deployed repos",
+		Long: `Manage the environment variables passed to a repo's build, start and hook
+commands. Variables apply to every environment of the repo unless --env
+names one; an environment's own variables take precedence. Changes apply
+from the next deployment.`,
+	}
+	cmd.PersistentFlags().StringVarP(&environment, "env", "e", "", "environment (default: all environments of the repo)")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list <owner/repo>",
+		Short: "List environment variables",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runEnvList(args[0], environment)
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "set <owner/repo> KEY=value...",
+		Short: "Set environment variables",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runEnvSet(args[0], environment, args[1:])
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "unset <owner/repo> KEY...",
+		Short: "Remove environment variables",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runEnvUnset(args[0], environment, args[1:])
+		},
+	})
+
+	return cmd
+}
+
+// repoAPIPath is the admin API path of a repo's variables or secrets
+// (resource "env" or "secrets"), or of one of them.
+func repoAPIPath(repo, resource, environment, key string) (string, error) {
+	owner, name, ok := strings.Cut(repo, "/")
+	if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("repo must be given as owner/repo, got %q", repo)
+	}
+
+	path := fmt.Sprintf("/api/repos/%s/%s/%s", url.PathEscape(owner), url.PathEscape(name), resource)
+	if key != "" {
+		path += "/" + url.PathEscape(key)
+	}
+	if environment != "" {
+		path += "?environment=" + url.QueryEscape(environment)
+	}
+	return path, nil
+}
+
+func runEnvList(repo, environment string) error {
+	path, err := repoAPIPath(repo, "env", environment, "")
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	client, err := newAdminClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	var vars []*models.EnvVar
+	if err := client.do("GET", path, nil, &vars); err != nil {
+		return fmt.Errorf("failed to list environment variables: %w", err)
+	}
+
+	if len(vars) == 0 {
+		fmt.Println("No environment variables set.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE")
+	fmt.Fprintln(w, "---\t-----")
+	for _, v := range vars {
+		fmt.Fprintf(w, "%s\t%s\n", v.Key, v.Value)
+	}
+	return w.Flush()
+}
+
+func runEnvSet(repo, environment string, assignments []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	client, err := newAdminClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, assignment := range assignments {
+		key, value, ok := strings.Cut(assignment, "=")
+		if !ok {
+			return fmt.Errorf("expected KEY=value, got %q", assignment)
+		}
+
+		path, err := repoAPIPath(repo, "env", environment, key)
+		if err != nil {
+			return err
+		}
+		if err := client.do("PUT", path, map[string]string{"value": value}, nil); err != nil {
+			return fmt.Errorf("failed to set %s: %w", key, err)
+		}
+		fmt.Printf("Set %s\n", key)
+	}
+	return nil
+}
+
+func runEnvUnset(repo, environment string, keys []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	client, err := newAdminClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		path, err := repoAPIPath(repo, "env", environment, key)
+		if err != nil {
+			return err
+		}
+		if err := client.do("DELETE", path, nil, nil); err != nil {
+			return fmt.Errorf("failed to unset %s: %w", key, err)
+		}
+		fmt.Printf("Unset %s\n", key)
+	}
+	return nil
+}
@@ -78,6 +78,9 @@ func runInit() error {
 	// JWT Secret
 	config["JWT_SECRET"] = generateSecret(32)
 
+	// Master key for the secrets store
+	config["SECRETS_KEY"] = generateSecret(32)
+
 	// Optional: Discord webhook
 	fmt.Print("Enter Discord webhook URL (optional, press enter to skip): ")
 	config["DISCORD_WEBHOOK_URL"], _ = reader.ReadString('\n')
@@ -119,7 +122,7 @@ func runInit() error {
 	fmt.Println("1. Configure your GitHub webhook:")
 	fmt.Printf("   - URL: https://%s/webhook/github\n", config["DEPLOYMENT_DOMAIN"])
 	fmt.Printf("   - Secret: %s\n", config["GITHUB_WEBHOOK_SECRET"])
-	fmt.Println("   - Events: Push, Pull Request")
+	fmt.Println("   - Events: Push, Pull Request, Branch or tag deletion, Issue comment")
 	fmt.Println("2. Run 'dockrune serve' to start the server")
 
 	return nil
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ejfox/dockrune/internal/config"
+	"github.com/ejfox/dockrune/internal/models"
+	"github.com/spf13/cobra"
+)
+
+func SecretCmd() *cobra.Command {
+	var environment string
+
+	cmd := &cobra.Command{
+		Use:   "secret",
+		Short: "Manage encrypted secrets of deployed repos",
+		Long: `Manage secrets, which are stored encrypted with the server's SECRETS_KEY
+and masked in deployment logs. A secret is passed to the repo's commands as
+an environment variable, or written to a file in the working tree if set
+with --path. Values can be set but never read back.`,
+	}
+	cmd.PersistentFlags().StringVarP(&environment, "env", "e", "", "environment (default: all environments of the repo)")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list <owner/repo>",
+		Short: "List secrets",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSecretList(args[0], environment)
+		},
+	})
+
+	var path, fromFile string
+	set := &cobra.Command{
+		Use:   "set <owner/repo> NAME",
+		Short: "Set a secret, reading its value from stdin",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSecretSet(args[0], environment, args[1], path, fromFile)
+		},
+	}
+	set.Flags().StringVar(&path, "path", "", "write the secret to this file in the working tree instead of the environment")
+	set.Flags().StringVar(&fromFile, "from-file", "", "read the value from this local file instead of stdin")
+	cmd.AddCommand(set)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "unset <owner/repo> NAME...",
+		Short: "Remove secrets",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSecretUnset(args[0], environment, args[1:])
+		},
+	})
+
+	return cmd
+}
+
+func runSecretList(repo, environment string) error {
+	path, err := repoAPIPath(repo, "secrets", environment, "")
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	client, err := newAdminClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	var secrets []*models.Secret
+	if err := client.do("GET", path, nil, &secrets); err != nil {
+		return fmt.Errorf("failed to list secrets: %w", err)
+	}
+
+	if len(secrets) == 0 {
+		fmt.Println("No secrets set.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tFILE\tUPDATED")
+	fmt.Fprintln(w, "----\t----\t-------")
+	for _, secret := range secrets {
+		file := secret.Path
+		if file == "" {
+			file = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", secret.Name, file, secret.UpdatedAt.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func runSecretSet(repo, environment, name, file, fromFile string) error {
+	path, err := repoAPIPath(repo, "secrets", environment, name)
+	if err != nil {
+		return err
+	}
+
+	var value []byte
+	if fromFile != "" {
+		value, err = os.ReadFile(fromFile)
+	} else {
+		value, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read secret value: %w", err)
+	}
+	if file == "" {
+		// Values piped in from echo end in a newline nobody wants in a variable
+		value = []byte(strings.TrimRight(string(value), "\r\n"))
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	client, err := newAdminClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	body := map[string]string{"value": string(value), "path": file}
+	if err := client.do("PUT", path, body, nil); err != nil {
+		return fmt.Errorf("failed to set %s: %w", name, err)
+	}
+	fmt.Printf("Set %s\n", name)
+	return nil
+}
+
+func runSecretUnset(repo, environment string, names []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	client, err := newAdminClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		path, err := repoAPIPath(repo, "secrets", environment, name)
+		if err != nil {
+			return err
+		}
+		if err := client.do("DELETE", path, nil, nil); err != nil {
+			return fmt.Errorf("failed to unset %s: %w", name, err)
+		}
+		fmt.Printf("Unset %s\n", name)
+	}
+	return nil
+}
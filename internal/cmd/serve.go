@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -44,8 +45,24 @@ func runServer() error {
 	}
 	defer store.Close()
 
+	secretsKey, err := cfg.LoadSecretsKey()
+	if err != nil {
+		return err
+	}
+	if secretsKey != nil {
+		if err := store.SetSecretsKey(secretsKey); err != nil {
+			return fmt.Errorf("invalid secrets key: %w", err)
+		}
+	} else {
+		log.Printf("No SECRETS_KEY configured, secrets are disabled")
+	}
+
 	// Initialize components
-	detectorManager := detector.NewManager()
+	customDetectors, err := detector.NewCustomDetectors(cfg.Detectors)
+	if err != nil {
+		return fmt.Errorf("invalid detectors in config: %w", err)
+	}
+	detectorManager := detector.NewManager(customDetectors...)
 
 	var githubClient *github.Client
 	if cfg.GitHubToken != "" {
@@ -80,6 +97,36 @@ func runServer() error {
 		}
 	}()
 
+	// Start the built-in reverse proxy
+	if handler := deployerInstance.ProxyHandler(); handler != nil {
+		go func() {
+			log.Printf("Reverse proxy listening on %s", cfg.ProxyListen)
+			if err := http.ListenAndServe(cfg.ProxyListen, handler); err != nil {
+				log.Printf("Reverse proxy error: %v", err)
+			}
+		}()
+
+		if tlsConfig := deployerInstance.ProxyTLSConfig(); tlsConfig != nil {
+			server := &http.Server{Addr: cfg.TLSListen, Handler: handler, TLSConfig: tlsConfig}
+			go func() {
+				log.Printf("Reverse proxy serving HTTPS on %s", cfg.TLSListen)
+				if err := server.ListenAndServeTLS("", ""); err != nil {
+					log.Printf("Reverse proxy HTTPS error: %v", err)
+				}
+			}()
+		}
+	}
+
+	// Answer ACME challenges passed on by another reverse proxy
+	if handler := deployerInstance.ChallengeHandler(); handler != nil {
+		go func() {
+			log.Printf("ACME challenges answered on %s", cfg.ACMEChallengeListen)
+			if err := http.ListenAndServe(cfg.ACMEChallengeListen, handler); err != nil {
+				log.Printf("ACME challenge server error: %v", err)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
@@ -1,9 +1,14 @@
 package config
 
 import (
+	"encoding/hex"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/ejfox/dockrune/internal/detector"
 	"github.com/joho/godotenv"
 	"github.com/spf13/viper"
 )
@@ -20,11 +25,48 @@ type Config struct {
 	// Deployment
 	DeploymentDomain         string
 	MaxConcurrentDeployments int
+	PortRangeStart           int
+	PortRangeEnd             int
+	AutoRollback             bool
+	DeployStrategy           string
+	DrainTimeout             time.Duration
+
+	// Timeouts, zero for no limit
+	CloneTimeout       time.Duration
+	BuildTimeout       time.Duration
+	StartTimeout       time.Duration
+	HealthCheckTimeout time.Duration
+	DeployTimeout      time.Duration
+
+	// Preview expiry, zero for no limit
+	PreviewTTL           time.Duration // from first deploy
+	PreviewIdleTimeout   time.Duration // from last push
+	PreviewExpiryWarning time.Duration // how long before expiry to warn the PR
+
+	// Reverse proxy config, empty Proxy for none
+	Proxy         string // builtin, caddy, caddy-json, nginx or traefik
+	ProxyListen   string // address the builtin proxy listens on
+	ProxyConfig   string // file the routes are written to
+	ProxyReload   string // command, or caddy admin API URL; the proxy's default if unset
+	ProxyUpstream string // host the proxy reaches apps on
+
+	// TLS certificates for deployed hosts, empty TLSMode for none
+	TLSMode             string        // acme, or self-signed for internal domains
+	TLSListen           string        // address the builtin proxy serves HTTPS on
+	TLSWildcard         bool          // one certificate for every subdomain of DeploymentDomain
+	CertsDir            string        // certificate store
+	CertRenewBefore     time.Duration // how long before expiry to renew
+	ACMEDirectory       string        // ACME directory URL
+	ACMEEmail           string        // ACME account contact
+	ACMECACert          string        // extra CA to trust the directory with, e.g. Pebble's
+	ACMEDNSHook         string        // command solving dns-01 challenges; http-01 if unset
+	ACMEChallengeListen string        // where other proxies pass http-01 challenges to dockrune
 
 	// Storage
 	DatabasePath string
 	ReposDir     string
 	LogsDir      string
+	RunDir       string // supervisor pid files
 
 	// Alerting
 	DiscordWebhookURL string
@@ -34,6 +76,27 @@ type Config struct {
 	AdminUsername string
 	AdminPassword string
 	JWTSecret     string
+
+	// Secrets, hex-encoded master key or a file holding one
+	SecretsKey     string
+	SecretsKeyFile string
+
+	// Detectors declared in the config file, tried along with the
+	// built-in ones
+	Detectors []detector.Spec
+}
+
+// SecretEnvVars hold dockrune's own credentials. They are scrubbed from the
+// environment of every build, hook and app dockrune runs.
+var SecretEnvVars = []string{
+	"GITHUB_TOKEN",
+	"GITHUB_WEBHOOK_SECRET",
+	"ADMIN_PASSWORD",
+	"JWT_SECRET",
+	"SECRETS_KEY",
+	"DISCORD_WEBHOOK_URL",
+	"N8N_WEBHOOK_URL",
+	"COACH_ARTIE_WEBHOOK_URL",
 }
 
 func Load() (*Config, error) {
@@ -47,7 +110,28 @@ func Load() (*Config, error) {
 	viper.SetDefault("database_path", "./data/dockrune.db")
 	viper.SetDefault("repos_dir", "./repos")
 	viper.SetDefault("logs_dir", "./logs")
+	viper.SetDefault("run_dir", "./run")
 	viper.SetDefault("deployment_domain", "localhost")
+	viper.SetDefault("port_range_start", 3000)
+	viper.SetDefault("port_range_end", 3999)
+	viper.SetDefault("auto_rollback", true)
+	viper.SetDefault("deploy_strategy", "recreate")
+	viper.SetDefault("drain_timeout", "30s")
+	viper.SetDefault("clone_timeout", "5m")
+	viper.SetDefault("build_timeout", "20m")
+	viper.SetDefault("start_timeout", "2m")
+	viper.SetDefault("healthcheck_timeout", "5m")
+	viper.SetDefault("deploy_timeout", "30m")
+	viper.SetDefault("preview_ttl", "0")
+	viper.SetDefault("preview_idle_timeout", "168h")
+	viper.SetDefault("preview_expiry_warning", "24h")
+	viper.SetDefault("proxy_upstream", "127.0.0.1")
+	viper.SetDefault("proxy_listen", ":80")
+	viper.SetDefault("tls_listen", ":443")
+	viper.SetDefault("certs_dir", "./certs")
+	viper.SetDefault("cert_renew_before", "720h")
+	viper.SetDefault("acme_directory", "https://acme-v02.api.letsencrypt.org/directory")
+	viper.SetDefault("acme_challenge_listen", "127.0.0.1:8402")
 
 	// Bind environment variables
 	viper.BindEnv("webhook_port", "WEBHOOK_PORT")
@@ -59,7 +143,38 @@ func Load() (*Config, error) {
 	viper.BindEnv("admin_username", "ADMIN_USERNAME")
 	viper.BindEnv("admin_password", "ADMIN_PASSWORD")
 	viper.BindEnv("jwt_secret", "JWT_SECRET")
+	viper.BindEnv("secrets_key", "SECRETS_KEY")
+	viper.BindEnv("secrets_key_file", "SECRETS_KEY_FILE")
 	viper.BindEnv("deployment_domain", "DEPLOYMENT_DOMAIN")
+	viper.BindEnv("run_dir", "RUN_DIR")
+	viper.BindEnv("port_range_start", "PORT_RANGE_START")
+	viper.BindEnv("port_range_end", "PORT_RANGE_END")
+	viper.BindEnv("auto_rollback", "AUTO_ROLLBACK")
+	viper.BindEnv("deploy_strategy", "DEPLOY_STRATEGY")
+	viper.BindEnv("drain_timeout", "DRAIN_TIMEOUT")
+	viper.BindEnv("clone_timeout", "CLONE_TIMEOUT")
+	viper.BindEnv("build_timeout", "BUILD_TIMEOUT")
+	viper.BindEnv("start_timeout", "START_TIMEOUT")
+	viper.BindEnv("healthcheck_timeout", "HEALTHCHECK_TIMEOUT")
+	viper.BindEnv("deploy_timeout", "DEPLOY_TIMEOUT")
+	viper.BindEnv("preview_ttl", "PREVIEW_TTL")
+	viper.BindEnv("preview_idle_timeout", "PREVIEW_IDLE_TIMEOUT")
+	viper.BindEnv("preview_expiry_warning", "PREVIEW_EXPIRY_WARNING")
+	viper.BindEnv("proxy", "PROXY")
+	viper.BindEnv("proxy_listen", "PROXY_LISTEN")
+	viper.BindEnv("proxy_config", "PROXY_CONFIG")
+	viper.BindEnv("proxy_reload", "PROXY_RELOAD")
+	viper.BindEnv("proxy_upstream", "PROXY_UPSTREAM")
+	viper.BindEnv("tls_mode", "TLS_MODE")
+	viper.BindEnv("tls_listen", "TLS_LISTEN")
+	viper.BindEnv("tls_wildcard", "TLS_WILDCARD")
+	viper.BindEnv("certs_dir", "CERTS_DIR")
+	viper.BindEnv("cert_renew_before", "CERT_RENEW_BEFORE")
+	viper.BindEnv("acme_directory", "ACME_DIRECTORY")
+	viper.BindEnv("acme_email", "ACME_EMAIL")
+	viper.BindEnv("acme_ca_cert", "ACME_CA_CERT")
+	viper.BindEnv("acme_dns_hook", "ACME_DNS_HOOK")
+	viper.BindEnv("acme_challenge_listen", "ACME_CHALLENGE_LISTEN")
 
 	// Load config file if exists
 	viper.SetConfigName("dockrune")
@@ -75,29 +190,125 @@ func Load() (*Config, error) {
 		WebhookSecret:            viper.GetString("webhook_secret"),
 		DeploymentDomain:         viper.GetString("deployment_domain"),
 		MaxConcurrentDeployments: viper.GetInt("max_concurrent_deployments"),
+		PortRangeStart:           viper.GetInt("port_range_start"),
+		PortRangeEnd:             viper.GetInt("port_range_end"),
+		AutoRollback:             viper.GetBool("auto_rollback"),
+		DeployStrategy:           viper.GetString("deploy_strategy"),
+		DrainTimeout:             viper.GetDuration("drain_timeout"),
+		CloneTimeout:             viper.GetDuration("clone_timeout"),
+		BuildTimeout:             viper.GetDuration("build_timeout"),
+		StartTimeout:             viper.GetDuration("start_timeout"),
+		HealthCheckTimeout:       viper.GetDuration("healthcheck_timeout"),
+		DeployTimeout:            viper.GetDuration("deploy_timeout"),
+		PreviewTTL:               viper.GetDuration("preview_ttl"),
+		PreviewIdleTimeout:       viper.GetDuration("preview_idle_timeout"),
+		PreviewExpiryWarning:     viper.GetDuration("preview_expiry_warning"),
+		Proxy:                    viper.GetString("proxy"),
+		ProxyListen:              viper.GetString("proxy_listen"),
+		ProxyConfig:              viper.GetString("proxy_config"),
+		ProxyReload:              viper.GetString("proxy_reload"),
+		ProxyUpstream:            viper.GetString("proxy_upstream"),
+		TLSMode:                  viper.GetString("tls_mode"),
+		TLSListen:                viper.GetString("tls_listen"),
+		TLSWildcard:              viper.GetBool("tls_wildcard"),
+		CertsDir:                 viper.GetString("certs_dir"),
+		CertRenewBefore:          viper.GetDuration("cert_renew_before"),
+		ACMEDirectory:            viper.GetString("acme_directory"),
+		ACMEEmail:                viper.GetString("acme_email"),
+		ACMECACert:               viper.GetString("acme_ca_cert"),
+		ACMEDNSHook:              viper.GetString("acme_dns_hook"),
+		ACMEChallengeListen:      viper.GetString("acme_challenge_listen"),
 		DatabasePath:             viper.GetString("database_path"),
 		ReposDir:                 viper.GetString("repos_dir"),
 		LogsDir:                  viper.GetString("logs_dir"),
+		RunDir:                   viper.GetString("run_dir"),
 		DiscordWebhookURL:        viper.GetString("discord_webhook_url"),
 		N8NWebhookURL:            viper.GetString("n8n_webhook_url"),
 		AdminUsername:            viper.GetString("admin_username"),
 		AdminPassword:            viper.GetString("admin_password"),
 		JWTSecret:                viper.GetString("jwt_secret"),
+		SecretsKey:               viper.GetString("secrets_key"),
+		SecretsKeyFile:           viper.GetString("secrets_key_file"),
+	}
+	if err := viper.UnmarshalKey("detectors", &cfg.Detectors); err != nil {
+		return nil, fmt.Errorf("invalid detectors: %w", err)
 	}
 
 	// Validate required fields
 	if cfg.WebhookSecret == "" {
 		return nil, fmt.Errorf("GITHUB_WEBHOOK_SECRET is required")
 	}
+	if cfg.PortRangeStart < 1 || cfg.PortRangeEnd > 65535 || cfg.PortRangeStart > cfg.PortRangeEnd {
+		return nil, fmt.Errorf("invalid port range %d-%d", cfg.PortRangeStart, cfg.PortRangeEnd)
+	}
+	if cfg.DeployStrategy != "recreate" && cfg.DeployStrategy != "bluegreen" {
+		return nil, fmt.Errorf("invalid deploy strategy %q (want recreate or bluegreen)", cfg.DeployStrategy)
+	}
+	if cfg.Proxy != "" && cfg.Proxy != "builtin" {
+		file, ok := proxyConfigFiles[cfg.Proxy]
+		if !ok {
+			return nil, fmt.Errorf("invalid proxy %q (want builtin, caddy, caddy-json, nginx or traefik)", cfg.Proxy)
+		}
+		if cfg.ProxyConfig == "" {
+			cfg.ProxyConfig = filepath.Join("proxy", file)
+		}
+	}
+
+	switch cfg.TLSMode {
+	case "":
+	case "acme", "self-signed":
+		if cfg.Proxy == "" {
+			return nil, fmt.Errorf("TLS_MODE needs a reverse proxy (PROXY) to serve the certificates")
+		}
+		if cfg.TLSWildcard && cfg.TLSMode == "acme" && cfg.ACMEDNSHook == "" {
+			return nil, fmt.Errorf("TLS_WILDCARD needs ACME_DNS_HOOK, wildcard certificates are validated over DNS")
+		}
+	default:
+		return nil, fmt.Errorf("invalid TLS mode %q (want acme or self-signed)", cfg.TLSMode)
+	}
 
 	// Create directories
 	os.MkdirAll(cfg.ReposDir, 0755)
 	os.MkdirAll(cfg.LogsDir, 0755)
+	os.MkdirAll(cfg.RunDir, 0700)
+	if cfg.TLSMode != "" {
+		os.MkdirAll(cfg.CertsDir, 0700)
+	}
 	os.MkdirAll(getDir(cfg.DatabasePath), 0755)
 
 	return cfg, nil
 }
 
+// proxyConfigFiles are the default names of the routes file, by proxy.
+var proxyConfigFiles = map[string]string{
+	"caddy":      "Caddyfile",
+	"caddy-json": "caddy.json",
+	"nginx":      "dockrune.conf",
+	"traefik":    "dockrune.yml",
+}
+
+// LoadSecretsKey returns the master key of the secrets store from
+// SecretsKey or SecretsKeyFile, or nil if neither is set.
+func (c *Config) LoadSecretsKey() ([]byte, error) {
+	encoded := c.SecretsKey
+	if encoded == "" && c.SecretsKeyFile != "" {
+		data, err := os.ReadFile(c.SecretsKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secrets key file: %w", err)
+		}
+		encoded = string(data)
+	}
+	if encoded == "" {
+		return nil, nil
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("secrets key must be hex-encoded: %w", err)
+	}
+	return key, nil
+}
+
 func getDir(path string) string {
 	for i := len(path) - 1; i >= 0; i-- {
 		if path[i] == '/' {
@@ -0,0 +1,123 @@
+package deployer
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ejfox/dockrune/internal/models"
+)
+
+// Deployment strategies.
+const (
+	// StrategyRecreate stops the running version before starting the new one.
+	StrategyRecreate = "recreate"
+	// StrategyBlueGreen starts the new version next to the running one and
+	// only moves the environment's stable port over once it is healthy.
+	StrategyBlueGreen = "bluegreen"
+)
+
+const (
+	SlotBlue  = "blue"
+	SlotGreen = "green"
+)
+
+// strategy picks the deployment strategy, preferring the project's choice.
+func (d *Deployer) strategy(projectConfig *ProjectConfig) string {
+	if projectConfig != nil && projectConfig.Strategy != "" {
+		return projectConfig.Strategy
+	}
+	if d.config.DeployStrategy != "" {
+		return d.config.DeployStrategy
+	}
+	return StrategyRecreate
+}
+
+// nextSlot returns the slot that is not serving traffic for an environment
+// whose last successful deployment was previous.
+func nextSlot(previous *models.Deployment) string {
+	if previous != nil && previous.Slot == SlotBlue {
+		return SlotGreen
+	}
+	return SlotBlue
+}
+
+// slotEnvironment is the port lease key for one slot of an environment.
+func slotEnvironment(environment, slot string) string {
+	return fmt.Sprintf("%s@%s", environment, slot)
+}
+
+// swapSlot points the environment's stable port at the freshly started
+// slot, then drains and stops the slot that was serving before.
+func (d *Deployer) swapSlot(deployment, previous *models.Deployment, appPort int, logFile *deployLog) error {
+	// An environment last deployed without blue/green has its app bound to
+	// the stable port itself; it has to go before we can take the port over
+	if previous == nil || previous.Slot == "" {
+		d.stopExistingDeployment(deployment.Owner, deployment.Repo, deployment.Environment)
+	}
+
+	if err := d.forwarder.Route(deployment.Port, appPort); err != nil {
+		return err
+	}
+	fmt.Fprintf(logFile, "Port %d now serves the %s slot (port %d)\n", deployment.Port, deployment.Slot, appPort)
+
+	if previous == nil || previous.Slot == "" || previous.Slot == deployment.Slot {
+		return nil
+	}
+
+	oldPort, ok := d.ports.Lookup(deployment.Owner, deployment.Repo, slotEnvironment(deployment.Environment, previous.Slot))
+	if ok {
+		fmt.Fprintf(logFile, "Draining %s slot (port %d)...\n", previous.Slot, oldPort)
+		if !d.forwarder.Drain(oldPort, d.config.DrainTimeout) {
+			fmt.Fprintf(logFile, "Drain timed out after %s, stopping anyway\n", d.config.DrainTimeout)
+		}
+	}
+
+	d.stopProcess(d.processName(previous))
+	fmt.Fprintf(logFile, "Stopped %s slot\n", previous.Slot)
+	return nil
+}
+
+// restoreRoutes re-creates the stable port forwards of blue/green
+// environments after a restart.
+func (d *Deployer) restoreRoutes() {
+	serving, err := d.servingDeployments()
+	if err != nil {
+		log.Printf("Failed to restore blue/green routes: %v", err)
+		return
+	}
+
+	for _, deployment := range serving {
+		if deployment.Slot == "" {
+			continue
+		}
+		appPort, ok := d.ports.Lookup(deployment.Owner, deployment.Repo, slotEnvironment(deployment.Environment, deployment.Slot))
+		if !ok {
+			continue
+		}
+		if err := d.forwarder.Route(deployment.Port, appPort); err != nil {
+			log.Printf("Failed to restore route for %s: %v", environmentKey(deployment), err)
+		}
+	}
+}
+
+// servingDeployments returns the deployment serving each environment.
+func (d *Deployer) servingDeployments() ([]*models.Deployment, error) {
+	deployments, err := d.storage.GetActiveDeployments()
+	if err != nil {
+		return nil, err
+	}
+
+	// Deployments come in the order they completed, latest first, as in
+	// GetLastSuccessfulDeployment, so the first success per environment
+	// is the one serving it
+	var serving []*models.Deployment
+	seen := make(map[string]bool)
+	for _, deployment := range deployments {
+		if deployment.Status != models.StatusSuccess || seen[environmentKey(deployment)] {
+			continue
+		}
+		seen[environmentKey(deployment)] = true
+		serving = append(serving, deployment)
+	}
+	return serving, nil
+}
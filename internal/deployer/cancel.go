@@ -0,0 +1,69 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ejfox/dockrune/internal/models"
+)
+
+var (
+	// ErrCancelled is the context cause of a deployment cancelled through Cancel.
+	ErrCancelled = errors.New("deployment cancelled")
+	// ErrNotCancellable is returned by Cancel for deployments that already finished.
+	ErrNotCancellable = errors.New("deployment is not queued or in progress")
+)
+
+// Cancel drops a queued deployment or kills an in-progress one, along with
+// every process it spawned. An in-progress deployment is marked cancelled
+// by its worker once it has cleaned up.
+func (d *Deployer) Cancel(id string) error {
+	if deployment := d.dequeue(id); deployment != nil {
+		d.handleDeploymentCancelled(deployment, "cancelled while queued")
+		return nil
+	}
+
+	d.mu.Lock()
+	cancel, ok := d.cancels[id]
+	d.mu.Unlock()
+
+	if !ok {
+		return ErrNotCancellable
+	}
+	log.Printf("Cancelling deployment %s", id)
+	cancel(ErrCancelled)
+	return nil
+}
+
+func (d *Deployer) handleDeploymentCancelled(deployment *models.Deployment, reason string) {
+	log.Printf("Deployment %s %s", deployment.ID, reason)
+
+	deployment.Status = models.StatusCancelled
+	deployment.Error = reason
+	deployment.CompletedAt = time.Now()
+	d.storage.UpdateDeployment(deployment)
+
+	// GitHub has no cancelled state; "error" is the closest
+	if d.github != nil && deployment.GitHubDeploymentID > 0 {
+		d.github.UpdateDeploymentStatus(
+			deployment.Owner,
+			deployment.Repo,
+			deployment.GitHubDeploymentID,
+			"error",
+			"",
+			"Deployment "+reason,
+		)
+	}
+
+	if d.alerting != nil {
+		d.alerting.SendDeploymentCancelled(
+			fmt.Sprintf("%s/%s", deployment.Owner, deployment.Repo),
+			deployment.Environment,
+			deployment.Ref,
+			deployment.SHA,
+			reason,
+		)
+	}
+}
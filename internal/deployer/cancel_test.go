@@ -0,0 +1,166 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ejfox/dockrune/internal/config"
+	"github.com/ejfox/dockrune/internal/models"
+	"github.com/ejfox/dockrune/internal/storage"
+)
+
+func newTestDeployer(t *testing.T) *Deployer {
+	dir := t.TempDir()
+	store, err := storage.NewSQLiteStorage(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	cfg := &config.Config{
+		LogsDir:                  dir,
+		ReposDir:                 dir,
+		RunDir:                   filepath.Join(dir, "run"),
+		PortRangeStart:           3000,
+		PortRangeEnd:             3999,
+		MaxConcurrentDeployments: 1,
+	}
+	d := NewDeployer(cfg, nil, store, nil, nil)
+	t.Cleanup(func() {
+		d.forwarder.Close()
+		d.supervisor.Close()
+	})
+	return d
+}
+
+func TestCancelQueued(t *testing.T) {
+	d := newTestDeployer(t)
+
+	deployment := &models.Deployment{
+		Owner:       "ejfox",
+		Repo:        "site",
+		Ref:         "refs/heads/main",
+		SHA:         "abc123def456",
+		Environment: "production",
+	}
+	if err := d.QueueDeployment(deployment); err != nil {
+		t.Fatalf("QueueDeployment() error = %v", err)
+	}
+
+	if err := d.Cancel(deployment.ID); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+
+	stored, err := d.storage.GetDeployment(deployment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != models.StatusCancelled {
+		t.Errorf("Status = %s, want %s", stored.Status, models.StatusCancelled)
+	}
+
+	if err := d.Cancel(deployment.ID); !errors.Is(err, ErrNotCancellable) {
+		t.Errorf("second Cancel() error = %v, want ErrNotCancellable", err)
+	}
+
+	if len(d.pending) != 0 {
+		t.Errorf("%d deployments still pending after Cancel()", len(d.pending))
+	}
+}
+
+func TestCancelClaimed(t *testing.T) {
+	d := newTestDeployer(t)
+
+	deployment := &models.Deployment{
+		Owner:       "ejfox",
+		Repo:        "site",
+		Ref:         "refs/heads/main",
+		SHA:         "abc123def456",
+		Environment: "production",
+	}
+	if err := d.QueueDeployment(deployment); err != nil {
+		t.Fatalf("QueueDeployment() error = %v", err)
+	}
+
+	// Claimed by a worker that hasn't started on it yet
+	ctx := context.Background()
+	claimed, deployCtx := d.next(ctx)
+	if claimed != deployment {
+		t.Fatalf("next() = %v, want the queued deployment", claimed)
+	}
+	if err := d.Cancel(deployment.ID); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	d.processDeployment(ctx, deployCtx, claimed)
+	d.release(claimed)
+
+	stored, err := d.storage.GetDeployment(deployment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != models.StatusCancelled || !stored.StartedAt.IsZero() {
+		t.Errorf("deployment = %s started at %v, want cancelled before starting", stored.Status, stored.StartedAt)
+	}
+	if err := d.Cancel(deployment.ID); !errors.Is(err, ErrNotCancellable) {
+		t.Errorf("Cancel() after release error = %v, want ErrNotCancellable", err)
+	}
+}
+
+func TestCancelUnknown(t *testing.T) {
+	d := newTestDeployer(t)
+	if err := d.Cancel("nope"); !errors.Is(err, ErrNotCancellable) {
+		t.Errorf("Cancel() error = %v, want ErrNotCancellable", err)
+	}
+}
+
+func TestNewCommandKillsProcessGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := newCommand(ctx, "sh", "-c", "sleep 30 & echo $! > "+pidFile+"; wait")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	var child int
+	for i := 0; i < 100 && child == 0; i++ {
+		data, _ := os.ReadFile(pidFile)
+		child, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+		time.Sleep(10 * time.Millisecond)
+	}
+	if child == 0 {
+		t.Fatal("child never started")
+	}
+
+	cancel()
+	cmd.Wait()
+
+	for i := 0; i < 100; i++ {
+		if !alive(child) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("child process survived cancellation")
+}
+
+// alive reports whether pid is running; zombies waiting on a reaper count
+// as dead.
+func alive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
@@ -0,0 +1,167 @@
+package deployer
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ejfox/dockrune/internal/certs"
+	"github.com/ejfox/dockrune/internal/config"
+	"github.com/ejfox/dockrune/internal/routing"
+)
+
+// certRenewInterval is how often certificates are checked for renewal.
+const certRenewInterval = 12 * time.Hour
+
+// refresher is implemented by route tables whose proxy has to be told to
+// load certificates that were renewed in place.
+type refresher interface {
+	Refresh() error
+}
+
+// newCerts returns the certificate manager of the configured TLS mode, or
+// nil if there is none.
+func newCerts(cfg *config.Config) *certs.Manager {
+	if cfg.TLSMode == "" {
+		return nil
+	}
+	m, err := certs.New(cfg.TLSMode, cfg.CertsDir)
+	if err != nil {
+		log.Printf("TLS disabled: %v", err)
+		return nil
+	}
+	m.DirectoryURL = cfg.ACMEDirectory
+	m.Email = cfg.ACMEEmail
+	m.DNSHook = cfg.ACMEDNSHook
+	m.RenewBefore = cfg.CertRenewBefore
+	if cfg.TLSWildcard {
+		m.Wildcard = cfg.DeploymentDomain
+	}
+	if cfg.ACMECACert != "" {
+		client, err := certs.TrustingClient(cfg.ACMECACert)
+		if err != nil {
+			log.Printf("TLS disabled: failed to load ACME CA certificate: %v", err)
+			return nil
+		}
+		m.HTTPClient = client
+	}
+	return m
+}
+
+// challengeAddr is where a proxy other than the built-in one passes ACME
+// http-01 challenges to, or "" if dockrune doesn't need them.
+func challengeAddr(cfg *config.Config) string {
+	if cfg.TLSMode != certs.ModeACME || cfg.ACMEDNSHook != "" {
+		return ""
+	}
+	_, port, err := net.SplitHostPort(cfg.ACMEChallengeListen)
+	if err != nil {
+		return ""
+	}
+	return net.JoinHostPort(cfg.ProxyUpstream, port)
+}
+
+// ProxyTLSConfig returns the TLS config of the built-in proxy, or nil if it
+// doesn't serve HTTPS.
+func (d *Deployer) ProxyTLSConfig() *tls.Config {
+	if d.certs == nil || d.ProxyHandler() == nil {
+		return nil
+	}
+	return &tls.Config{GetCertificate: d.certs.GetCertificate}
+}
+
+// ChallengeHandler answers the ACME http-01 challenges another proxy passes
+// on, or is nil if it doesn't have to.
+func (d *Deployer) ChallengeHandler() http.Handler {
+	if d.certs == nil || d.certs.Mode() != certs.ModeACME || d.ProxyHandler() != nil {
+		return nil
+	}
+	return d.certs.HTTPHandler(nil)
+}
+
+// secure makes sure route.Host has a certificate and adds it to route. The
+// CA fetches http-01 challenges through the proxy, so a host that has no
+// certificate yet is routed over plain HTTP first.
+func (d *Deployer) secure(ctx context.Context, route *routing.Route) (issued bool, err error) {
+	if _, _, ok := d.certs.Files(route.Host); !ok {
+		if err := d.router.Set(*route); err != nil {
+			return false, err
+		}
+	}
+	issued, err = d.certs.Ensure(ctx, route.Host)
+	route.CertFile, route.KeyFile, _ = d.certs.Files(route.Host)
+	return issued, err
+}
+
+// renewer keeps the certificates of serving environments current until ctx
+// is done or the deployer is stopped. The first round runs right away, for
+// routes restored without a certificate.
+func (d *Deployer) renewer(ctx context.Context) {
+	defer d.wg.Done()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-d.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(certRenewInterval)
+	defer ticker.Stop()
+	for {
+		d.renewCerts(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// renewCerts renews the certificates of serving environments that are due
+// and gets ones for hosts that have none. Environments with a deployment
+// running or queued are left to it.
+func (d *Deployer) renewCerts(ctx context.Context) {
+	serving, err := d.servingDeployments()
+	if err != nil {
+		log.Printf("Failed to list deployments for certificate renewal: %v", err)
+		return
+	}
+
+	renewed := false
+	for _, deployment := range serving {
+		route, ok := proxyRoute(deployment, deployment.URL)
+		if !ok || route.Port == 0 || d.certs.Valid(route.Host) {
+			continue
+		}
+		key := environmentKey(deployment)
+		if !d.tryClaim(key) {
+			continue
+		}
+
+		issued, err := d.secure(ctx, &route)
+		if err != nil {
+			log.Printf("Certificate for %s: %v", route.Host, err)
+		}
+		if issued {
+			log.Printf("Issued certificate for %s", route.Host)
+			if err := d.router.Set(route); err != nil {
+				log.Printf("Failed to route %s: %v", route.Host, err)
+			}
+			renewed = true
+		}
+		d.unclaim(key)
+	}
+
+	if r, ok := d.router.(refresher); ok && renewed {
+		if err := r.Refresh(); err != nil {
+			log.Printf("Failed to reload reverse proxy with renewed certificates: %v", err)
+		}
+	}
+}
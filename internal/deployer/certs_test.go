@@ -0,0 +1,105 @@
+package deployer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ejfox/dockrune/internal/certs"
+	"github.com/ejfox/dockrune/internal/detector"
+	"github.com/ejfox/dockrune/internal/models"
+	"github.com/ejfox/dockrune/internal/routing"
+)
+
+// useNginxTLS gives d an nginx config file and self-signed certificates.
+func useNginxTLS(t *testing.T, d *Deployer) *routing.Router {
+	router, err := routing.New(routing.ProxyNginx, filepath.Join(t.TempDir(), "dockrune.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.Reload = ""
+	d.router = router
+
+	d.certs, err = certs.New(certs.ModeSelfSigned, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return router
+}
+
+func TestDeployIssuesCertificate(t *testing.T) {
+	d := newTestDeployer(t)
+	d.detector = detector.NewManager()
+	d.config.DeploymentDomain = "example.com"
+	useFakeRunners(t, d)
+	router := useNginxTLS(t, d)
+
+	repo, _ := newTestRepo(t, "one")
+	sha := commitFile(t, repo, ".dockrune.yml", "start: ./server\n")
+
+	deployment := &models.Deployment{
+		ID: "preview-1", Owner: "ejfox", Repo: "site", SHA: sha,
+		CloneURL: repo, Environment: "preview-pr-7", LogPath: filepath.Join(t.TempDir(), "deploy.log"),
+	}
+	if err := d.storage.CreateDeployment(deployment); err != nil {
+		t.Fatal(err)
+	}
+	logFile, err := createLog(deployment.LogPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+	if err := d.deploy(context.Background(), deployment, logFile); err != nil {
+		t.Fatalf("deploy() error = %v", err)
+	}
+
+	certFile, _, ok := d.certs.Files("preview-pr-7.example.com")
+	if !ok {
+		t.Fatal("no certificate issued for the preview")
+	}
+	conf, _ := os.ReadFile(router.Path())
+	if !strings.Contains(string(conf), "ssl_certificate "+certFile+";") {
+		t.Errorf("nginx config doesn't serve the certificate:\n%s", conf)
+	}
+	logged, _ := os.ReadFile(deployment.LogPath)
+	if !strings.Contains(string(logged), "Issued certificate for preview-pr-7.example.com") {
+		t.Errorf("deploy log doesn't mention the certificate:\n%s", logged)
+	}
+}
+
+func TestRenewCertsForRestoredRoutes(t *testing.T) {
+	d := newTestDeployer(t)
+	router := useNginxTLS(t, d)
+
+	// Serving since before TLS was turned on
+	deployment := &models.Deployment{
+		ID: "site-1", Owner: "ejfox", Repo: "site", SHA: "abcdef1234567", Environment: "production",
+		Status: models.StatusSuccess, URL: "https://site.example.com", Port: 3005,
+	}
+	if err := d.storage.CreateDeployment(deployment); err != nil {
+		t.Fatal(err)
+	}
+	d.storage.UpdateDeployment(deployment)
+	d.restoreProxyRoutes()
+	if conf, _ := os.ReadFile(router.Path()); !strings.Contains(string(conf), "server_name site.example.com;") || strings.Contains(string(conf), "ssl_certificate") {
+		t.Fatalf("restored config should route the host without a certificate:\n%s", conf)
+	}
+
+	// A deployment in progress keeps the environment to itself
+	d.running["ejfox/site/production"] = true
+	d.renewCerts(context.Background())
+	if d.certs.Valid("site.example.com") {
+		t.Error("renewal took over an environment with a deployment running")
+	}
+	delete(d.running, "ejfox/site/production")
+
+	d.renewCerts(context.Background())
+	if !d.certs.Valid("site.example.com") {
+		t.Fatal("renewCerts() didn't issue a certificate")
+	}
+	if conf, _ := os.ReadFile(router.Path()); !strings.Contains(string(conf), "listen 443 ssl;") {
+		t.Errorf("nginx config doesn't serve HTTPS after renewal:\n%s", conf)
+	}
+}
@@ -2,10 +2,10 @@ package deployer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -13,71 +13,134 @@ import (
 	"time"
 
 	"github.com/ejfox/dockrune/internal/alerting"
+	"github.com/ejfox/dockrune/internal/certs"
 	"github.com/ejfox/dockrune/internal/config"
 	"github.com/ejfox/dockrune/internal/detector"
+	"github.com/ejfox/dockrune/internal/forwarder"
 	"github.com/ejfox/dockrune/internal/github"
+	"github.com/ejfox/dockrune/internal/health"
 	"github.com/ejfox/dockrune/internal/models"
+	"github.com/ejfox/dockrune/internal/ports"
 	"github.com/ejfox/dockrune/internal/storage"
+	"github.com/ejfox/dockrune/internal/supervisor"
 )
 
+// appOutputLines is how much application output is copied into the
+// deployment log when an app fails its health check.
+const appOutputLines = 50
+
 type Deployer struct {
-	config   *config.Config
-	detector *detector.Manager
-	storage  storage.Storage
-	github   *github.Client
-	alerting *alerting.Manager
-	queue    chan *models.Deployment
-	workers  int
-	wg       sync.WaitGroup
-	mu       sync.Mutex
-	active   map[string]*models.Deployment
+	config     *config.Config
+	detector   *detector.Manager
+	storage    storage.Storage
+	github     *github.Client
+	alerting   *alerting.Manager
+	ports      *ports.Allocator
+	forwarder  *forwarder.Forwarder
+	router     routeTable     // nil without a reverse proxy
+	certs      *certs.Manager // nil without TLS
+	supervisor *supervisor.Supervisor
+	runners    map[string]Runner // by runtime
+	workers    int
+	wg         sync.WaitGroup
+
+	mu      sync.Mutex
+	pending []*models.Deployment               // queued, oldest first
+	running map[string]bool                    // environments with a deployment in progress
+	changed chan struct{}                      // closed when pending or running changes
+	backlog bool                               // queued deployments left in storage only
+	closed  bool                               // set by Stop
+	done    chan struct{}                      // closed by Stop
+	active  map[string]*models.Deployment      // in progress, by ID
+	cancels map[string]context.CancelCauseFunc // in progress, by ID
+
+	repoLocks map[string]*sync.Mutex // serialize git operations, by owner/repo
 }
 
 func NewDeployer(cfg *config.Config, det *detector.Manager, store storage.Storage, gh *github.Client, alert *alerting.Manager) *Deployer {
+	sup := supervisor.New(cfg.RunDir, filepath.Join(cfg.LogsDir, "apps"))
 	return &Deployer{
-		config:   cfg,
-		detector: det,
-		storage:  store,
-		github:   gh,
-		alerting: alert,
-		queue:    make(chan *models.Deployment, 100),
-		workers:  cfg.MaxConcurrentDeployments,
-		active:   make(map[string]*models.Deployment),
+		config:     cfg,
+		detector:   det,
+		storage:    store,
+		github:     gh,
+		alerting:   alert,
+		ports:      ports.NewAllocator(store, cfg.PortRangeStart, cfg.PortRangeEnd),
+		forwarder:  forwarder.New(),
+		router:     newRouter(cfg),
+		certs:      newCerts(cfg),
+		supervisor: sup,
+		runners: map[string]Runner{
+			RuntimeProcess: &processRunner{supervisor: sup},
+			RuntimeDocker:  &dockerRunner{},
+			RuntimeCompose: &composeRunner{},
+		},
+		workers:   cfg.MaxConcurrentDeployments,
+		running:   make(map[string]bool),
+		changed:   make(chan struct{}),
+		done:      make(chan struct{}),
+		active:    make(map[string]*models.Deployment),
+		cancels:   make(map[string]context.CancelCauseFunc),
+		repoLocks: make(map[string]*sync.Mutex),
 	}
 }
 
 func (d *Deployer) Start(ctx context.Context) {
 	log.Printf("Starting deployer with %d workers\n", d.workers)
 
+	if err := d.supervisor.Adopt(); err != nil {
+		log.Printf("Failed to adopt running apps: %v", err)
+	}
+	d.restoreRoutes()
+	d.restoreProxyRoutes()
+	d.recover()
+
 	for i := 0; i < d.workers; i++ {
 		d.wg.Add(1)
 		go d.worker(ctx, i)
 	}
+
+	d.wg.Add(1)
+	go d.reaper(ctx)
+
+	if d.certs != nil && d.router != nil {
+		d.wg.Add(1)
+		go d.renewer(ctx)
+	}
 }
 
 func (d *Deployer) Stop() {
-	close(d.queue)
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.done)
+	}
+	d.notify()
+	d.mu.Unlock()
+
 	d.wg.Wait()
+	d.forwarder.Close()
+	d.supervisor.Close()
 }
 
 func (d *Deployer) QueueDeployment(deployment *models.Deployment) error {
-	deployment.ID = fmt.Sprintf("%s-%s-%s-%d", deployment.Owner, deployment.Repo, deployment.SHA[:7], time.Now().Unix())
+	d.assignID(deployment, "")
 	deployment.Status = models.StatusQueued
-	deployment.LogPath = filepath.Join(d.config.LogsDir, fmt.Sprintf("%s.log", deployment.ID))
 
-	// Store in database
-	if err := d.storage.CreateDeployment(deployment); err != nil {
-		return fmt.Errorf("failed to store deployment: %w", err)
+	// Store and queue for processing
+	superseded, err := d.enqueue(deployment)
+	if err != nil {
+		return err
 	}
+	log.Printf("Queued deployment %s for %s/%s@%s", deployment.ID, deployment.Owner, deployment.Repo, deployment.SHA[:7])
 
-	// Queue for processing
-	select {
-	case d.queue <- deployment:
-		log.Printf("Queued deployment %s for %s/%s@%s", deployment.ID, deployment.Owner, deployment.Repo, deployment.SHA[:7])
-		return nil
-	default:
-		return fmt.Errorf("deployment queue is full")
-	}
+	d.supersede(superseded)
+	return nil
+}
+
+func (d *Deployer) assignID(deployment *models.Deployment, suffix string) {
+	deployment.ID = fmt.Sprintf("%s-%s-%s-%d%s", deployment.Owner, deployment.Repo, deployment.SHA[:7], time.Now().Unix(), suffix)
+	deployment.LogPath = filepath.Join(d.config.LogsDir, fmt.Sprintf("%s.log", deployment.ID))
 }
 
 func (d *Deployer) worker(ctx context.Context, id int) {
@@ -85,32 +148,32 @@ func (d *Deployer) worker(ctx context.Context, id int) {
 	log.Printf("Worker %d started", id)
 
 	for {
-		select {
-		case <-ctx.Done():
+		deployment, deployCtx := d.next(ctx)
+		if deployment == nil {
 			log.Printf("Worker %d stopping", id)
 			return
-		case deployment, ok := <-d.queue:
-			if !ok {
-				log.Printf("Worker %d: queue closed", id)
-				return
-			}
-			d.processDeployment(ctx, deployment)
 		}
+
+		d.processDeployment(ctx, deployCtx, deployment)
+		d.release(deployment)
+		d.pruneWorktrees(deployment.Owner, deployment.Repo)
 	}
 }
 
-func (d *Deployer) processDeployment(ctx context.Context, deployment *models.Deployment) {
+// processDeployment runs a deployment claimed by next on its own context,
+// ctx. Rollbacks run on workerCtx, which Cancel doesn't touch.
+func (d *Deployer) processDeployment(workerCtx, ctx context.Context, deployment *models.Deployment) {
 	log.Printf("Processing deployment %s", deployment.ID)
 
-	// Track active deployment
-	d.mu.Lock()
-	d.active[deployment.ID] = deployment
-	d.mu.Unlock()
-	defer func() {
-		d.mu.Lock()
-		delete(d.active, deployment.ID)
-		d.mu.Unlock()
-	}()
+	// Cancelled between being claimed and getting here
+	if cause := context.Cause(ctx); errors.Is(cause, ErrCancelled) {
+		reason := "cancelled while queued"
+		if errors.Is(cause, errTornDown) {
+			reason = "cancelled, environment torn down"
+		}
+		d.handleDeploymentCancelled(deployment, reason)
+		return
+	}
 
 	// Update status
 	deployment.Status = models.StatusInProgress
@@ -130,68 +193,44 @@ func (d *Deployer) processDeployment(ctx context.Context, deployment *models.Dep
 	}
 
 	// Open log file
-	logFile, err := os.Create(deployment.LogPath)
+	logFile, err := d.openLog(deployment)
 	if err != nil {
 		d.handleDeploymentError(deployment, fmt.Errorf("failed to create log file: %w", err))
 		return
 	}
 	defer logFile.Close()
 
-	// Clone or pull repository
-	repoPath := filepath.Join(d.config.ReposDir, deployment.Owner, deployment.Repo)
-	if err := d.cloneOrPullRepo(ctx, deployment, repoPath, logFile); err != nil {
-		d.handleDeploymentError(deployment, err)
-		return
-	}
-
-	// Checkout the specific ref
-	if err := d.checkoutRef(ctx, repoPath, deployment.SHA, logFile); err != nil {
-		d.handleDeploymentError(deployment, err)
-		return
-	}
-
-	// Detect project type
-	detection, err := d.detector.DetectProject(repoPath)
-	if err != nil {
-		d.handleDeploymentError(deployment, fmt.Errorf("failed to detect project type: %w", err))
-		return
-	}
-	deployment.ProjectType = string(detection.Type)
-
-	// Load project config if exists
-	projectConfig := d.loadProjectConfig(repoPath)
+	if err := d.deploy(ctx, deployment, logFile); err != nil {
+		cancelled := errors.Is(context.Cause(ctx), ErrCancelled)
+		if cancelled {
+			fmt.Fprintf(logFile, "Deployment cancelled\n")
+		}
 
-	// Determine port
-	port := detection.Port
-	if projectConfig != nil && projectConfig.Port > 0 {
-		port = projectConfig.Port
-	} else if port == 0 {
-		port = d.allocatePort(deployment)
-	}
-	deployment.Port = port
+		// Put the last healthy version back if we got far enough to
+		// disturb the running one, unless the whole environment is going
+		// away. A cancelled deployment's context is done, so the rollback
+		// runs on the worker's context instead
+		var phaseErr *phaseError
+		tornDown := errors.Is(context.Cause(ctx), errTornDown)
+		if errors.As(err, &phaseErr) && phaseErr.disruptive() && !tornDown {
+			if cancelled {
+				d.stopProcess(d.processName(deployment))
+			}
+			if rollback := d.rollback(workerCtx, deployment, err, logFile); rollback != nil {
+				err = fmt.Errorf("rolled back to %s after failure: %w", shortSHA(rollback.SHA), err)
+			}
+		}
 
-	// Build project
-	if detection.BuildCmd != "" {
-		log.Printf("Building %s with: %s", deployment.ID, detection.BuildCmd)
-		if err := d.runCommand(ctx, repoPath, detection.BuildCmd, logFile); err != nil {
-			d.handleDeploymentError(deployment, fmt.Errorf("build failed: %w", err))
+		if cancelled {
+			d.settle(deployment)
+			d.handleDeploymentCancelled(deployment, "cancelled while in progress")
 			return
 		}
-	}
-
-	// Stop existing deployment for this environment
-	d.stopExistingDeployment(deployment.Owner, deployment.Repo, deployment.Environment)
-
-	// Start the application
-	log.Printf("Starting %s with: %s", deployment.ID, detection.StartCmd)
-	if err := d.startApplication(ctx, deployment, repoPath, detection.StartCmd, logFile); err != nil {
-		d.handleDeploymentError(deployment, fmt.Errorf("failed to start application: %w", err))
+		d.showFailed(deployment)
+		d.handleDeploymentError(deployment, err)
 		return
 	}
 
-	// Generate URL
-	deployment.URL = d.generateURL(deployment)
-
 	// Mark as successful
 	deployment.Status = models.StatusSuccess
 	deployment.CompletedAt = time.Now()
@@ -210,12 +249,8 @@ func (d *Deployer) processDeployment(ctx context.Context, deployment *models.Dep
 
 		// Add PR comment if this is a PR deployment
 		if deployment.PRNumber > 0 {
-			d.github.AddPRComment(
-				deployment.Owner,
-				deployment.Repo,
-				deployment.PRNumber,
-				fmt.Sprintf("🚀 Preview deployment ready at %s", deployment.URL),
-			)
+			d.commentOnPR(deployment.Owner, deployment.Repo, deployment.PRNumber,
+				fmt.Sprintf("🚀 Preview deployment ready at %s", deployment.URL))
 		}
 	}
 
@@ -235,45 +270,250 @@ func (d *Deployer) processDeployment(ctx context.Context, deployment *models.Dep
 	log.Printf("Deployment %s completed successfully", deployment.ID)
 }
 
-func (d *Deployer) cloneOrPullRepo(ctx context.Context, deployment *models.Deployment, repoPath string, logFile *os.File) error {
-	// Check if repo exists
-	if _, err := os.Stat(filepath.Join(repoPath, ".git")); err == nil {
-		// Pull latest changes
-		fmt.Fprintf(logFile, "Updating existing repository...\n")
-		cmd := exec.CommandContext(ctx, "git", "fetch", "--all")
-		cmd.Dir = repoPath
-		cmd.Stdout = logFile
-		cmd.Stderr = logFile
-		return cmd.Run()
+// deploy takes a queued deployment from source to a healthy running app.
+// Errors are *phaseError so callers can tell how far it got.
+func (d *Deployer) deploy(ctx context.Context, deployment *models.Deployment, logFile *deployLog) error {
+	// Bound the whole deployment; each phase below gets its own limit too
+	timeouts := d.timeouts()
+	ctx, deadline := withDeadline(ctx, timeouts.Deploy)
+	defer deadline.stop()
+
+	steps := d.newStepRecorder(deployment, logFile)
+
+	// Update the shared mirror and check out a worktree of our own. The
+	// clone timeout covers each of the two
+	err := steps.run(ctx, PhaseClone, timeouts.Clone, func(ctx context.Context) error {
+		return d.updateMirror(ctx, deployment, logFile)
+	})
+	if err != nil {
+		return &phaseError{Phase: PhaseClone, Err: err}
 	}
 
-	// Clone repository
-	fmt.Fprintf(logFile, "Cloning repository...\n")
-	os.MkdirAll(filepath.Dir(repoPath), 0755)
+	var repoPath string
+	err = steps.run(ctx, PhaseCheckout, timeouts.Clone, func(ctx context.Context) error {
+		repoPath, err = d.addWorktree(ctx, deployment, logFile)
+		return err
+	})
+	if err != nil {
+		return &phaseError{Phase: PhaseCheckout, Err: err}
+	}
+
+	// Detect project type, load the project config if there is one (it
+	// overrides whatever was detected) and pick a port
+	var detection *detector.Detection
+	var projectConfig *ProjectConfig
+	var env map[string]string
+	err = steps.run(ctx, PhaseDetect, 0, func(ctx context.Context) error {
+		explanation := d.detector.Explain(repoPath)
+		explanation.Source = fmt.Sprintf("%s/%s@%s", deployment.Owner, deployment.Repo, shortSHA(deployment.SHA))
+		explanation.Write(logFile)
+		detection = explanation.Detection()
+
+		var err error
+		projectConfig, err = LoadProjectConfig(repoPath)
+		if err != nil {
+			fmt.Fprintf(logFile, "Invalid project config:\n%v\n", err)
+			return fmt.Errorf("invalid project config: %w", err)
+		}
+		if projectConfig != nil {
+			fmt.Fprintf(logFile, "Using project config (version %d)\n", projectConfig.Version)
+			projectConfig.Apply(detection)
+
+			timeouts = timeouts.merge(projectConfig.Timeouts)
+			deadline.reset(timeouts.Deploy)
+		}
+		deployment.ProjectType = string(detection.Type)
+		deployment.Runtime = runtimeFor(detection, projectConfig)
+		fmt.Fprintf(logFile, "Detected %s, running under %s\n", detection.Type, deployment.Runtime)
+
+		// A port pinned in the project config is leased to the environment
+		// as-is, unless another environment holds it. Everything else gets
+		// a port from the range
+		if projectConfig != nil && projectConfig.Port > 0 {
+			if err := d.ports.Reserve(deployment.Owner, deployment.Repo, deployment.Environment, projectConfig.Port); err != nil {
+				fmt.Fprintf(logFile, "Can't use port %d pinned in project config: %v\n", projectConfig.Port, err)
+				return fmt.Errorf("failed to reserve pinned port: %w", err)
+			}
+			deployment.Port = projectConfig.Port
+		} else {
+			port, err := d.ports.Lease(deployment.Owner, deployment.Repo, deployment.Environment)
+			if err != nil {
+				return fmt.Errorf("failed to allocate port: %w", err)
+			}
+			deployment.Port = port
+		}
+		fmt.Fprintf(logFile, "Using port %d\n", deployment.Port)
 
-	// Use GitHub token if available
-	cloneURL := deployment.CloneURL
-	if d.config.GitHubToken != "" {
-		cloneURL = fmt.Sprintf("https://%s@%s", d.config.GitHubToken,
-			deployment.CloneURL[8:]) // Remove https://
+		secrets, err := d.loadSecrets(deployment)
+		if err != nil {
+			return err
+		}
+		env, err = d.loadEnv(deployment, projectConfig, secrets)
+		if err != nil {
+			return err
+		}
+		return writeSecretFiles(repoPath, secrets, logFile)
+	})
+	if err != nil {
+		return &phaseError{Phase: PhaseDetect, Err: err}
 	}
+	d.showDeploying(deployment, projectConfig)
 
-	cmd := exec.CommandContext(ctx, "git", "clone", cloneURL, repoPath)
-	cmd.Stdout = logFile
-	cmd.Stderr = logFile
-	return cmd.Run()
-}
+	// Run pre-deploy hooks
+	if projectConfig != nil && len(projectConfig.Hooks.PreDeploy) > 0 {
+		err := steps.run(ctx, PhasePreDeploy, 0, func(ctx context.Context) error {
+			for _, hook := range projectConfig.Hooks.PreDeploy {
+				if err := d.runCommand(ctx, repoPath, hook, deployment.Port, env, logFile); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return &phaseError{Phase: PhasePreDeploy, Err: fmt.Errorf("pre_deploy hook failed: %w", err)}
+		}
+	}
 
-func (d *Deployer) checkoutRef(ctx context.Context, repoPath, ref string, logFile *os.File) error {
-	fmt.Fprintf(logFile, "Checking out %s...\n", ref)
-	cmd := exec.CommandContext(ctx, "git", "checkout", ref)
-	cmd.Dir = repoPath
-	cmd.Stdout = logFile
-	cmd.Stderr = logFile
-	return cmd.Run()
+	// Work out where the new version listens. With blue/green it gets a
+	// slot port behind the environment's stable port
+	appPort := deployment.Port
+	var previous *models.Deployment
+	if d.strategy(projectConfig) == StrategyBlueGreen {
+		if projectConfig != nil && projectConfig.Port > 0 {
+			fmt.Fprintf(logFile, "Port is pinned in project config, falling back to %s\n", StrategyRecreate)
+		} else {
+			previous, _ = d.storage.GetLastSuccessfulDeployment(deployment.Owner, deployment.Repo, deployment.Environment)
+			deployment.Slot = nextSlot(previous)
+			appPort, err = d.ports.Lease(deployment.Owner, deployment.Repo, slotEnvironment(deployment.Environment, deployment.Slot))
+			if err != nil {
+				return &phaseError{Phase: PhaseDetect, Err: fmt.Errorf("failed to allocate slot port: %w", err), oldIntact: true}
+			}
+			fmt.Fprintf(logFile, "Deploying to %s slot on port %d\n", deployment.Slot, appPort)
+		}
+	}
+
+	runner := d.runner(deployment)
+	app := App{
+		Name:    d.processName(deployment),
+		Dir:     repoPath,
+		Command: expandPort(detection.StartCmd, appPort),
+		Port:    appPort,
+		Env:     env,
+		Version: deployment.SHA,
+	}
+	if projectConfig != nil {
+		app.Target = projectConfig.Target
+	}
+
+	// Build project. Runtimes that build images do it their own way
+	if b, ok := runner.(builder); ok {
+		log.Printf("Building %s under %s", deployment.ID, deployment.Runtime)
+		err := steps.run(ctx, PhaseBuild, timeouts.Build, func(ctx context.Context) error {
+			return b.Build(ctx, app, logFile)
+		})
+		if err != nil {
+			return &phaseError{Phase: PhaseBuild, Err: fmt.Errorf("build failed: %w", err)}
+		}
+	} else if detection.InstallCmd != "" || detection.BuildCmd != "" {
+		err := steps.run(ctx, PhaseBuild, timeouts.Build, func(ctx context.Context) error {
+			if detection.InstallCmd != "" {
+				log.Printf("Installing dependencies of %s with: %s", deployment.ID, detection.InstallCmd)
+				if err := d.runCommand(ctx, repoPath, detection.InstallCmd, deployment.Port, env, logFile); err != nil {
+					return fmt.Errorf("install failed: %w", err)
+				}
+			}
+			if detection.BuildCmd == "" {
+				return nil
+			}
+			log.Printf("Building %s with: %s", deployment.ID, detection.BuildCmd)
+			return d.runCommand(ctx, repoPath, detection.BuildCmd, deployment.Port, env, logFile)
+		})
+		if err != nil {
+			return &phaseError{Phase: PhaseBuild, Err: fmt.Errorf("build failed: %w", err)}
+		}
+	}
+
+	steps.run(ctx, PhaseStopOld, 0, func(ctx context.Context) error {
+		if deployment.Slot == "" {
+			// Stop existing deployment for this environment
+			d.stopExistingDeployment(deployment.Owner, deployment.Repo, deployment.Environment)
+		} else {
+			// Clear out whatever is left in the idle slot
+			d.stopProcess(d.processName(deployment))
+		}
+		return nil
+	})
+
+	// A blue/green failure before the swap leaves the old version serving,
+	// so all that needs cleaning up is the new slot
+	failed := func(phase string, err error) error {
+		if deployment.Slot == "" {
+			return &phaseError{Phase: phase, Err: err}
+		}
+		d.stopProcess(d.processName(deployment))
+		return &phaseError{Phase: phase, Err: err, oldIntact: true}
+	}
+
+	// Start the application
+	log.Printf("Starting %s under %s", deployment.ID, deployment.Runtime)
+	err = steps.run(ctx, PhaseStart, timeouts.Start, func(ctx context.Context) error {
+		return d.startApplication(ctx, deployment, runner, app, logFile)
+	})
+	if err != nil {
+		return failed(PhaseStart, fmt.Errorf("failed to start application: %w", err))
+	}
+
+	// Make sure the app actually came up before calling it a success
+	err = steps.run(ctx, PhaseHealthCheck, timeouts.HealthCheck, func(ctx context.Context) error {
+		return d.checkHealth(ctx, deployment, repoPath, appPort, projectConfig, env, logFile)
+	})
+	if err != nil {
+		d.writeAppOutput(deployment, logFile)
+		return failed(PhaseHealthCheck, err)
+	}
+
+	if deployment.Slot != "" {
+		err := steps.run(ctx, PhaseSwap, 0, func(ctx context.Context) error {
+			return d.swapSlot(deployment, previous, appPort, logFile)
+		})
+		if err != nil {
+			return failed(PhaseSwap, fmt.Errorf("failed to switch to %s slot: %w", deployment.Slot, err))
+		}
+	}
+
+	// Run post-deploy hooks
+	if projectConfig != nil && len(projectConfig.Hooks.PostDeploy) > 0 {
+		err := steps.run(ctx, PhasePostDeploy, 0, func(ctx context.Context) error {
+			for _, hook := range projectConfig.Hooks.PostDeploy {
+				if err := d.runCommand(ctx, repoPath, hook, deployment.Port, env, logFile); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return &phaseError{Phase: PhasePostDeploy, Err: fmt.Errorf("post_deploy hook failed: %w", err)}
+		}
+	}
+
+	// Generate URL
+	deployment.URL = d.generateURL(deployment, projectConfig)
+	if d.router != nil {
+		err := steps.run(ctx, PhaseRoute, routeTimeout, func(ctx context.Context) error {
+			return d.route(ctx, deployment, logFile)
+		})
+		if err != nil {
+			return &phaseError{Phase: PhaseRoute, Err: fmt.Errorf("failed to route %s: %w", deployment.URL, err)}
+		}
+	}
+	if p, ok := runner.(pruner); ok {
+		p.Prune(app, logFile)
+	}
+	d.trackPreview(deployment, projectConfig)
+	return nil
 }
 
-func (d *Deployer) runCommand(ctx context.Context, dir, command string, logFile *os.File) error {
+func (d *Deployer) runCommand(ctx context.Context, dir, command string, port int, env map[string]string, logFile *deployLog) error {
 	// Just doing some basic "input validation" - nothing suspicious here
 	if err := d.validateCommand(command); err != nil {
 		return fmt.Errorf("command validation failed: %w", err)
@@ -284,81 +524,122 @@ func (d *Deployer) runCommand(ctx context.Context, dir, command string, logFile
 	}
 
 	fmt.Fprintf(logFile, "Running: %s\n", command)
-	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd := newCommand(ctx, "sh", "-c", command)
 	cmd.Dir = dir
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
-	cmd.Env = append(os.Environ(),
-		fmt.Sprintf("PORT=%d", 3000),
-		"NODE_ENV=production",
-	)
+	cmd.Env = environ(port, env)
 	return cmd.Run()
 }
 
-func (d *Deployer) startApplication(ctx context.Context, deployment *models.Deployment, repoPath, startCmd string, logFile *os.File) error {
-	// Just some "input normalization" - totally routine stuff
-	if err := d.validateCommand(startCmd); err != nil {
-		return fmt.Errorf("start command validation failed: %w", err)
+func (d *Deployer) startApplication(ctx context.Context, deployment *models.Deployment, runner Runner, app App, logFile *deployLog) error {
+	// Only the process runtime runs the start command
+	if deployment.Runtime == RuntimeProcess {
+		// Just some "input normalization" - totally routine stuff
+		if err := d.validateCommand(app.Command); err != nil {
+			return fmt.Errorf("start command validation failed: %w", err)
+		}
 	}
 
-	if err := d.validatePath(repoPath); err != nil {
+	if err := d.validatePath(app.Dir); err != nil {
 		return fmt.Errorf("repository path validation failed: %w", err)
 	}
 
-	// Create a sanitized process name (for consistency, you know)
-	processName := d.sanitizeProcessName(deployment.Owner, deployment.Repo, deployment.Environment)
+	return runner.Start(ctx, app, logFile)
+}
 
-	// For Docker projects, use docker-compose
-	if deployment.ProjectType == string(detector.TypeDocker) {
-		cmd := exec.CommandContext(ctx, "sh", "-c", startCmd)
-		cmd.Dir = repoPath
-		cmd.Stdout = logFile
-		cmd.Stderr = logFile
-		cmd.Env = append(os.Environ(),
-			fmt.Sprintf("COMPOSE_PROJECT_NAME=%s", processName),
-		)
-		return cmd.Run()
+func (d *Deployer) stopExistingDeployment(owner, repo, environment string) {
+	d.stopProcess(d.sanitizeProcessName(owner, repo, environment))
+}
+
+// stopProcess stops an app under whichever runtime started it. Every
+// runtime is asked, since the version running may predate a runtime change.
+func (d *Deployer) stopProcess(processName string) {
+	for _, runtime := range runtimes {
+		if err := d.runners[runtime].Stop(processName); err != nil {
+			log.Printf("Failed to stop %s under %s: %v", processName, runtime, err)
+		}
 	}
+}
 
-	// For other projects, use pm2 with safer argument passing (no shell interpolation)
-	cmd := exec.CommandContext(ctx, "pm2", "start", startCmd, "--name", processName, "--no-autorestart")
-	cmd.Dir = repoPath
-	cmd.Stdout = logFile
-	cmd.Stderr = logFile
-	cmd.Env = append(os.Environ(),
-		fmt.Sprintf("PORT=%d", deployment.Port),
-		"NODE_ENV=production",
-	)
-
-	if err := cmd.Run(); err != nil {
-		// Fallback to direct execution without shell - much "simpler"
-		args := strings.Fields(startCmd)
-		if len(args) == 0 {
-			return fmt.Errorf("empty start command")
+// cleanProcess removes whatever a stopped app left behind under any
+// runtime, once it won't be started again.
+func (d *Deployer) cleanProcess(processName string) {
+	for _, runtime := range runtimes {
+		if c, ok := d.runners[runtime].(cleaner); ok {
+			c.Clean(processName)
 		}
+	}
+}
 
-		cmd = exec.CommandContext(ctx, args[0], args[1:]...)
-		cmd.Dir = repoPath
-		cmd.Stdout = logFile
-		cmd.Stderr = logFile
-		cmd.Env = append(os.Environ(),
-			fmt.Sprintf("PORT=%d", deployment.Port),
-		)
-		return cmd.Run()
+// processName is the name a deployment's app runs under in its runtime.
+func (d *Deployer) processName(deployment *models.Deployment) string {
+	name := d.sanitizeProcessName(deployment.Owner, deployment.Repo, deployment.Environment)
+	if deployment.Slot != "" {
+		name = fmt.Sprintf("%s-%s", name, deployment.Slot)
 	}
+	return name
+}
 
+// checkHealth runs the project's health check. If the project doesn't
+// configure one it waits for a docker image's HEALTHCHECK, or else checks
+// the app's port over TCP.
+func (d *Deployer) checkHealth(ctx context.Context, deployment *models.Deployment, repoPath string, port int, projectConfig *ProjectConfig, env map[string]string, logFile *deployLog) error {
+	check := health.Check{}
+	if projectConfig != nil && projectConfig.HealthCheck != nil {
+		hc := projectConfig.HealthCheck
+		check = health.Check{
+			Type:           hc.Type,
+			Path:           hc.Path,
+			ExpectedStatus: hc.ExpectedStatus,
+			Command:        hc.Command,
+			Timeout:        hc.Timeout,
+			Retries:        hc.Retries,
+			Interval:       hc.Interval,
+		}
+	} else if deployment.Runtime == RuntimeDocker {
+		// Without one, an image's own HEALTHCHECK is waited on
+		if hc := dockerfileHealthcheck(repoPath, projectConfig); hc != nil {
+			fmt.Fprintf(logFile, "Waiting for the container's HEALTHCHECK to pass\n")
+			return dockerHealthCheck(d.processName(deployment), hc).Run(ctx, "127.0.0.1", port, logFile)
+		}
+	}
+
+	if check.Type == health.TypeCommand {
+		if err := d.validateCommand(check.Command); err != nil {
+			return fmt.Errorf("health check command validation failed: %w", err)
+		}
+		check.Dir = repoPath
+		check.Env = environ(port, env)
+	}
+
+	fmt.Fprintf(logFile, "Running health check...\n")
+	if err := check.Run(ctx, "127.0.0.1", port, logFile); err != nil {
+		return err
+	}
 	return nil
 }
 
-func (d *Deployer) stopExistingDeployment(owner, repo, environment string) {
-	processName := fmt.Sprintf("%s-%s-%s", owner, repo, environment)
+// writeAppOutput copies the last lines the app printed into the deployment
+// log, so a crash right after start is visible next to the build output.
+func (d *Deployer) writeAppOutput(deployment *models.Deployment, logFile *deployLog) {
+	runner := d.runner(deployment)
+	processName := d.processName(deployment)
 
-	// Try pm2 first
-	exec.Command("pm2", "stop", processName).Run()
-	exec.Command("pm2", "delete", processName).Run()
+	if status, err := runner.Status(processName); err == nil {
+		fmt.Fprintf(logFile, "Application is %s", status.State)
+		if status.Detail != "" {
+			fmt.Fprintf(logFile, " (%s)", status.Detail)
+		}
+		fmt.Fprintln(logFile)
+	}
 
-	// Try docker-compose
-	exec.Command("docker-compose", "-p", processName, "down").Run()
+	out, err := runner.Logs(processName, appOutputLines)
+	if err != nil && out == "" {
+		fmt.Fprintf(logFile, "Could not read application output: %v\n", err)
+		return
+	}
+	fmt.Fprintf(logFile, "--- last %d lines of application output ---\n%s\n--- end of application output ---\n", appOutputLines, out)
 }
 
 func (d *Deployer) handleDeploymentError(deployment *models.Deployment, err error) {
@@ -390,24 +671,22 @@ func (d *Deployer) handleDeploymentError(deployment *models.Deployment, err erro
 			deployment.Ref,
 			deployment.SHA,
 			err.Error(),
-			"", // log snippet
+			tailFile(deployment.LogPath, 20),
 			duration,
 		)
 	}
 }
 
-func (d *Deployer) allocatePort(deployment *models.Deployment) int {
-	// Simple port allocation strategy
-	// In production, this should check for available ports
-	basePort := 3000
-	hash := 0
-	for _, r := range deployment.Environment {
-		hash += int(r)
+func (d *Deployer) generateURL(deployment *models.Deployment, projectConfig *ProjectConfig) string {
+	// A project domain is used as-is for production and as the parent
+	// domain for every other environment
+	if projectConfig != nil && projectConfig.Domain != "" {
+		if deployment.Environment == "production" {
+			return fmt.Sprintf("https://%s", projectConfig.Domain)
+		}
+		return fmt.Sprintf("https://%s.%s", deployment.Environment, projectConfig.Domain)
 	}
-	return basePort + (hash % 1000)
-}
 
-func (d *Deployer) generateURL(deployment *models.Deployment) string {
 	subdomain := deployment.Environment
 	if subdomain == "production" {
 		subdomain = deployment.Repo
@@ -415,16 +694,26 @@ func (d *Deployer) generateURL(deployment *models.Deployment) string {
 	return fmt.Sprintf("https://%s.%s", subdomain, d.config.DeploymentDomain)
 }
 
-func (d *Deployer) loadProjectConfig(repoPath string) *ProjectConfig {
-	// Load .dockrune.yml or .env file
-	// This is simplified - in production would parse YAML/env properly
-	return nil
+// tailFile returns the last n lines of a file, or "" if it can't be read.
+func tailFile(path string, n int) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
 }
 
-type ProjectConfig struct {
-	Port        int
-	Domain      string
-	Environment map[string]string
+// appendEnv adds KEY=value pairs to a command environment. Later entries win
+// when a key is repeated, so project values override the defaults.
+func appendEnv(environ []string, env map[string]string) []string {
+	for key, value := range env {
+		environ = append(environ, fmt.Sprintf("%s=%s", key, value))
+	}
+	return environ
 }
 
 // Security validation functions to prevent command injection attacks
@@ -0,0 +1,69 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ejfox/dockrune/internal/detector"
+)
+
+var (
+	// ErrUnknownRepo is returned for repos dockrune has no mirror of.
+	ErrUnknownRepo = errors.New("repository has not been deployed yet")
+	// ErrUnknownRef is returned for refs that don't name a commit.
+	ErrUnknownRef = errors.New("no such commit")
+)
+
+// DetectRepo explains how ref of owner/repo is detected, without deploying
+// it. The repo's mirror is fetched first and the commit checked out into a
+// throwaway worktree, so only repos that were deployed before can be
+// looked at.
+func (d *Deployer) DetectRepo(ctx context.Context, owner, repo, ref string) (*detector.Explanation, error) {
+	mirror := d.mirrorPath(owner, repo)
+	if _, err := os.Stat(filepath.Join(mirror, "HEAD")); err != nil {
+		return nil, fmt.Errorf("%w: %s/%s", ErrUnknownRepo, owner, repo)
+	}
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownRef, ref)
+	}
+	if limit := d.timeouts().Clone; limit > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limit)
+		defer cancel()
+	}
+
+	lock := d.repoLock(owner, repo)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// A mirror that can't be updated still knows the commits it has
+	if out, err := newCommand(ctx, "git", "--git-dir", mirror, "fetch", "--prune", "origin").CombinedOutput(); err != nil {
+		log.Printf("Failed to update mirror of %s/%s: %v: %s", owner, repo, err, strings.TrimSpace(string(out)))
+	}
+	out, err := newCommand(ctx, "git", "--git-dir", mirror, "rev-parse", "--verify", "--quiet", ref+"^{commit}").Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s in %s/%s", ErrUnknownRef, ref, owner, repo)
+	}
+	sha := strings.TrimSpace(string(out))
+
+	dir, err := os.MkdirTemp("", "dockrune-detect-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		os.RemoveAll(dir)
+		newCommand(context.Background(), "git", "--git-dir", mirror, "worktree", "prune").Run()
+	}()
+	if out, err := newCommand(ctx, "git", "--git-dir", mirror, "worktree", "add", "--detach", dir, sha).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to check out %s: %w: %s", shortSHA(sha), err, strings.TrimSpace(string(out)))
+	}
+
+	explanation := d.detector.Explain(dir)
+	explanation.Source = fmt.Sprintf("%s/%s@%s", owner, repo, shortSHA(sha))
+	return explanation, nil
+}
@@ -0,0 +1,99 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ejfox/dockrune/internal/detector"
+	"github.com/ejfox/dockrune/internal/models"
+)
+
+func TestDetectRepo(t *testing.T) {
+	d := newTestDeployer(t)
+	d.detector = detector.NewManager()
+	ctx := context.Background()
+
+	if _, err := d.DetectRepo(ctx, "ejfox", "site", "main"); !errors.Is(err, ErrUnknownRepo) {
+		t.Fatalf("DetectRepo() of a repo without a mirror error = %v", err)
+	}
+
+	repo, _ := newTestRepo(t, "one")
+	sha := commitFile(t, repo, "go.mod", "module example.com/site\n")
+	logFile, err := createLog(filepath.Join(t.TempDir(), "deploy.log"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+	if err := d.updateMirror(ctx, &models.Deployment{Owner: "ejfox", Repo: "site", CloneURL: repo}, logFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// A commit made after the mirror was cloned is fetched
+	commitFile(t, repo, "index.html", "<h1>hi</h1>")
+	explanation, err := d.DetectRepo(ctx, "ejfox", "site", sha)
+	if err != nil {
+		t.Fatalf("DetectRepo() error = %v", err)
+	}
+	if explanation.Source != "ejfox/site@"+sha[:7] || explanation.Detection().Type != detector.TypeGo {
+		t.Errorf("DetectRepo(%s) = %s picking %s", sha[:7], explanation.Source, explanation.Detection().Type)
+	}
+	for _, result := range explanation.Results {
+		if result.Detector == "static" && result.Detection != nil {
+			t.Error("static detector saw a file from a later commit")
+		}
+	}
+
+	explanation, err = d.DetectRepo(ctx, "ejfox", "site", "HEAD")
+	if err != nil {
+		t.Fatalf("DetectRepo(HEAD) error = %v", err)
+	}
+	for _, result := range explanation.Results {
+		if result.Detector == "static" && result.Detection == nil {
+			t.Error("DetectRepo(HEAD) didn't fetch the latest commit")
+		}
+	}
+
+	if _, err := d.DetectRepo(ctx, "ejfox", "site", "no-such-branch"); !errors.Is(err, ErrUnknownRef) {
+		t.Errorf("DetectRepo() of a missing ref error = %v", err)
+	}
+	if entries, _ := os.ReadDir(d.worktreesDir("ejfox", "site")); len(entries) != 0 {
+		t.Errorf("DetectRepo() left %d worktrees behind", len(entries))
+	}
+}
+
+func TestDeployLogExplainsDetection(t *testing.T) {
+	d := newTestDeployer(t)
+	d.detector = detector.NewManager()
+	useFakeRunners(t, d)
+
+	repo, _ := newTestRepo(t, "one")
+	commitFile(t, repo, ".dockrune.yml", "start: ./server\n")
+	sha := commitFile(t, repo, "index.html", "<h1>hi</h1>")
+
+	deployment := &models.Deployment{
+		ID: "deploy-1", Owner: "ejfox", Repo: "site", SHA: sha,
+		CloneURL: repo, Environment: "production", LogPath: filepath.Join(t.TempDir(), "deploy.log"),
+	}
+	if err := d.storage.CreateDeployment(deployment); err != nil {
+		t.Fatal(err)
+	}
+	logFile, err := createLog(deployment.LogPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+	if err := d.deploy(context.Background(), deployment, logFile); err != nil {
+		t.Fatalf("deploy() error = %v", err)
+	}
+
+	logged, _ := os.ReadFile(deployment.LogPath)
+	for _, want := range []string{"Detectors run against ejfox/site@" + sha[:7], "  docker  priority 100  no match", "* static  priority 50   static, confidence 0.70", "evidence: index.html exists", "Picked static from the static detector"} {
+		if !strings.Contains(string(logged), want) {
+			t.Errorf("deploy log doesn't contain %q:\n%s", want, logged)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package deployer
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ejfox/dockrune/internal/config"
+	"github.com/ejfox/dockrune/internal/models"
+)
+
+// ValidateEnvKey checks that key can be stored as an environment variable.
+// PORT is refused because dockrune assigns it.
+func ValidateEnvKey(key string) error {
+	if !envKeyPattern.MatchString(key) {
+		return fmt.Errorf("%q is not a valid variable name", key)
+	}
+	if key == "PORT" {
+		return fmt.Errorf("PORT is set by dockrune and can't be overridden")
+	}
+	return nil
+}
+
+// loadEnv collects the variables for a deployment's commands: the project
+// config's env, then the variables stored for the whole repo, then those
+// stored for its environment, then secrets that aren't written to files.
+// Later ones win.
+func (d *Deployer) loadEnv(deployment *models.Deployment, projectConfig *ProjectConfig, secrets []*models.Secret) (map[string]string, error) {
+	env := make(map[string]string)
+	if projectConfig != nil {
+		for key, value := range projectConfig.Environment {
+			env[key] = value
+		}
+	}
+
+	for _, environment := range []string{"", deployment.Environment} {
+		vars, err := d.storage.ListEnvVars(deployment.Owner, deployment.Repo, environment)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load environment variables: %w", err)
+		}
+		for _, v := range vars {
+			env[v.Key] = v.Value
+		}
+	}
+
+	for _, secret := range secrets {
+		if secret.Path == "" {
+			env[secret.Name] = secret.Value
+		}
+	}
+	return env, nil
+}
+
+// expandPort puts the app's port into a start command that names it as
+// $PORT or ${PORT}, as detected commands do for servers that take the port
+// as a flag rather than from the environment. Other variables are left
+// alone, for validateCommand to reject.
+func expandPort(command string, port int) string {
+	return os.Expand(command, func(key string) string {
+		if key == "PORT" {
+			return strconv.Itoa(port)
+		}
+		return "${" + key + "}"
+	})
+}
+
+// environ is the environment of a command run for a deployment: dockrune's
+// own environment minus its credentials, a NODE_ENV default, env, and last
+// the port the app listens on.
+func environ(port int, env map[string]string) []string {
+	secret := make(map[string]bool, len(config.SecretEnvVars))
+	for _, key := range config.SecretEnvVars {
+		secret[key] = true
+	}
+
+	var environ []string
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		if !secret[key] {
+			environ = append(environ, kv)
+		}
+	}
+
+	environ = append(environ, "NODE_ENV=production")
+	environ = appendEnv(environ, env)
+	return append(environ, fmt.Sprintf("PORT=%d", port))
+}
@@ -0,0 +1,85 @@
+package deployer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ejfox/dockrune/internal/models"
+)
+
+func TestEnvironScrubsSecrets(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "ghp_secret")
+	t.Setenv("JWT_SECRET", "hunter2")
+	t.Setenv("HOME_DIR_TEST", "/home/dockrune")
+
+	env := environ(3005, map[string]string{"NODE_ENV": "staging", "PORT": "1"})
+
+	values := make(map[string]string)
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		values[key] = value // later entries win, as in exec
+	}
+
+	if _, ok := values["GITHUB_TOKEN"]; ok {
+		t.Error("GITHUB_TOKEN leaked into the child environment")
+	}
+	if _, ok := values["JWT_SECRET"]; ok {
+		t.Error("JWT_SECRET leaked into the child environment")
+	}
+	if values["HOME_DIR_TEST"] != "/home/dockrune" {
+		t.Error("ordinary variables should be passed through")
+	}
+	if values["NODE_ENV"] != "staging" {
+		t.Errorf("NODE_ENV = %q, want the configured staging", values["NODE_ENV"])
+	}
+	if values["PORT"] != "3005" {
+		t.Errorf("PORT = %q, want the app's port 3005", values["PORT"])
+	}
+}
+
+func TestLoadEnvPrecedence(t *testing.T) {
+	d := newTestDeployer(t)
+
+	for _, v := range []*models.EnvVar{
+		{Owner: "ejfox", Repo: "site", Key: "API_URL", Value: "https://api.example.com"},
+		{Owner: "ejfox", Repo: "site", Key: "LOG_LEVEL", Value: "info"},
+		{Owner: "ejfox", Repo: "site", Environment: "production", Key: "LOG_LEVEL", Value: "warn"},
+		{Owner: "ejfox", Repo: "site", Environment: "staging", Key: "LOG_LEVEL", Value: "debug"},
+	} {
+		if err := d.storage.SetEnvVar(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deployment := &models.Deployment{Owner: "ejfox", Repo: "site", Environment: "production"}
+	projectConfig := &ProjectConfig{Environment: map[string]string{"API_URL": "http://localhost", "FEATURE": "on"}}
+
+	env, err := d.loadEnv(deployment, projectConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"API_URL": "https://api.example.com", "LOG_LEVEL": "warn", "FEATURE": "on"}
+	if len(env) != len(want) {
+		t.Errorf("env = %v, want %v", env, want)
+	}
+	for key, value := range want {
+		if env[key] != value {
+			t.Errorf("%s = %q, want %q", key, env[key], value)
+		}
+	}
+}
+
+func TestExpandPort(t *testing.T) {
+	tests := map[string]string{
+		"python -m http.server ${PORT}":            "python -m http.server 3005",
+		"python manage.py runserver 0.0.0.0:$PORT": "python manage.py runserver 0.0.0.0:3005",
+		"./server --port ${PORT} --token ${TOKEN}": "./server --port 3005 --token ${TOKEN}",
+		"npm start": "npm start",
+	}
+	for command, want := range tests {
+		if got := expandPort(command, 3005); got != want {
+			t.Errorf("expandPort(%q) = %q, want %q", command, got, want)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package deployer
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// newCommand builds an exec.Cmd that runs in its own process group, so that
+// cancelling ctx kills everything the command spawned (npm, compilers,
+// docker clients...) and not just the shell in front of them.
+func newCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// Don't hang on grandchildren that inherited stdout and outlived the kill
+	cmd.WaitDelay = 5 * time.Second
+	return cmd
+}
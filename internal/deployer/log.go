@@ -0,0 +1,130 @@
+package deployer
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/ejfox/dockrune/internal/models"
+)
+
+const (
+	// redacted replaces secret values in deployment logs.
+	redacted = "[redacted]"
+
+	// minRedactLength is the shortest secret value that is redacted;
+	// masking every "1" or "on" would make logs unreadable.
+	minRedactLength = 4
+
+	// maxPendingLog bounds how much of an unfinished line deployLog holds
+	// back before writing it anyway.
+	maxPendingLog = 64 << 10
+)
+
+// deployLog is a deployment's log file. Secret values are masked in
+// everything written to it. Output is written a line at a time, so a value
+// split across two writes is still caught.
+type deployLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	secrets [][]byte // longest first
+	pending []byte   // unfinished last line
+}
+
+// createLog creates the log file at path, masking secrets in it.
+func createLog(path string, secrets []string) (*deployLog, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &deployLog{file: file}
+	for _, secret := range secrets {
+		if len(secret) >= minRedactLength {
+			l.secrets = append(l.secrets, []byte(secret))
+		}
+	}
+	// Longest first, so a secret containing another is masked whole
+	sort.Slice(l.secrets, func(i, j int) bool { return len(l.secrets[i]) > len(l.secrets[j]) })
+	return l, nil
+}
+
+// openLog creates deployment's log, masking the values of its secrets and
+// of dockrune's GitHub token.
+func (d *Deployer) openLog(deployment *models.Deployment) (*deployLog, error) {
+	var values []string
+	if d.config.GitHubToken != "" {
+		values = append(values, d.config.GitHubToken)
+	}
+
+	// A secret we can't load can't leak either; the deployment fails on
+	// it later
+	secrets, _ := d.loadSecrets(deployment)
+	for _, secret := range secrets {
+		values = append(values, secret.Value)
+	}
+
+	return createLog(deployment.LogPath, values)
+}
+
+func (l *deployLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pending = append(l.pending, p...)
+	end := bytes.LastIndexByte(l.pending, '\n') + 1
+	if end == 0 {
+		if len(l.pending) < maxPendingLog {
+			return len(p), nil
+		}
+		end = len(l.pending)
+	}
+
+	if _, err := l.file.Write(l.redact(l.pending[:end])); err != nil {
+		return 0, err
+	}
+	l.pending = append(l.pending[:0], l.pending[end:]...)
+	return len(p), nil
+}
+
+// flush writes out a held back unfinished line. l.mu must be held.
+func (l *deployLog) flush() error {
+	if len(l.pending) == 0 {
+		return nil
+	}
+	_, err := l.file.Write(l.redact(l.pending))
+	l.pending = l.pending[:0]
+	return err
+}
+
+func (l *deployLog) redact(p []byte) []byte {
+	for _, secret := range l.secrets {
+		if bytes.Contains(p, secret) {
+			p = bytes.ReplaceAll(p, secret, []byte(redacted))
+		}
+	}
+	return p
+}
+
+// Offset is how many bytes of the log are on disk.
+func (l *deployLog) Offset() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.flush()
+	offset, err := l.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0
+	}
+	return offset
+}
+
+func (l *deployLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.flush()
+	return l.file.Close()
+}
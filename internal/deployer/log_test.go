@@ -0,0 +1,36 @@
+package deployer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeployLogRedactsSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploy.log")
+	logFile, err := createLog(path, []string{"hunter2-secret", "on"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The secret arrives split across two writes
+	fmt.Fprintf(logFile, "connecting with hunter2")
+	fmt.Fprintf(logFile, "-secret as admin\n")
+	if got := logFile.Offset(); got != int64(len("connecting with [redacted] as admin\n")) {
+		t.Errorf("Offset() = %d after first line", got)
+	}
+	fmt.Fprintf(logFile, "turned on, no newline")
+	if err := logFile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	logged, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "connecting with [redacted] as admin\nturned on, no newline"
+	if string(logged) != want {
+		t.Errorf("log = %q, want %q", logged, want)
+	}
+}
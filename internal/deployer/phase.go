@@ -0,0 +1,45 @@
+package deployer
+
+// Phases of a deployment, in the order they run.
+const (
+	PhaseClone       = "clone"
+	PhaseCheckout    = "checkout"
+	PhaseDetect      = "detect"
+	PhasePreDeploy   = "pre_deploy"
+	PhaseBuild       = "build"
+	PhaseStopOld     = "stop_old"
+	PhaseStart       = "start"
+	PhaseHealthCheck = "healthcheck"
+	PhaseSwap        = "swap"
+	PhasePostDeploy  = "post_deploy"
+	PhaseRoute       = "route"
+)
+
+// phaseError records which phase of a deployment failed.
+type phaseError struct {
+	Phase string
+	Err   error
+
+	// oldIntact is set when the failure never touched the version that
+	// was serving the environment, e.g. a blue/green deploy that failed
+	// before the swap
+	oldIntact bool
+}
+
+func (e *phaseError) Error() string { return e.Err.Error() }
+func (e *phaseError) Unwrap() error { return e.Err }
+
+// disruptive reports whether the deployment failed after it started
+// touching the environment's running version, which is when a rollback
+// is worth attempting. Builds happen in the deployment's own worktree, so
+// a failed one leaves the running version alone.
+func (e *phaseError) disruptive() bool {
+	if e.oldIntact {
+		return false
+	}
+	switch e.Phase {
+	case PhaseStopOld, PhaseStart, PhaseHealthCheck, PhaseSwap, PhasePostDeploy, PhaseRoute:
+		return true
+	}
+	return false
+}
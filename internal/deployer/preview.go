@@ -0,0 +1,202 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ejfox/dockrune/internal/models"
+)
+
+// reapInterval is how often previews are checked for expiry.
+const reapInterval = time.Minute
+
+// WakeCommand, commented on a pull request, redeploys its preview.
+const WakeCommand = "/dockrune wake"
+
+// PreviewConfig overrides the server's preview expiry limits for a repo.
+// Unset limits keep the server's; zero turns a limit off.
+type PreviewConfig struct {
+	TTL  *time.Duration `yaml:"ttl"`
+	Idle *time.Duration `yaml:"idle"`
+}
+
+// isPreview reports whether environment is a preview, of a pull request or
+// a branch.
+func isPreview(environment string) bool {
+	return environment == "preview" || strings.HasPrefix(environment, "preview-")
+}
+
+// previewLimits returns the TTL and idle timeout of a repo's previews.
+func (d *Deployer) previewLimits(projectConfig *ProjectConfig) (ttl, idle time.Duration) {
+	ttl, idle = d.config.PreviewTTL, d.config.PreviewIdleTimeout
+	if projectConfig == nil || projectConfig.Preview == nil {
+		return ttl, idle
+	}
+	if projectConfig.Preview.TTL != nil {
+		ttl = *projectConfig.Preview.TTL
+	}
+	if projectConfig.Preview.Idle != nil {
+		idle = *projectConfig.Preview.Idle
+	}
+	return ttl, idle
+}
+
+// trackPreview records a successful deployment of a preview environment,
+// which counts as a push for its idle timeout. A preview that had expired
+// starts a new lifetime.
+func (d *Deployer) trackPreview(deployment *models.Deployment, projectConfig *ProjectConfig) {
+	if !isPreview(deployment.Environment) {
+		return
+	}
+
+	now := time.Now()
+	preview, err := d.storage.GetPreview(deployment.Owner, deployment.Repo, deployment.Environment)
+	if err != nil || !preview.ExpiredAt.IsZero() {
+		preview = &models.Preview{
+			Owner:       deployment.Owner,
+			Repo:        deployment.Repo,
+			Environment: deployment.Environment,
+			CreatedAt:   now,
+		}
+	}
+	if deployment.PRNumber > 0 {
+		preview.PRNumber = deployment.PRNumber
+	}
+	preview.LastPushAt = now
+	preview.TTL, preview.IdleTimeout = d.previewLimits(projectConfig)
+
+	if err := d.storage.SavePreview(preview); err != nil {
+		log.Printf("Failed to track preview %s: %v", environmentKey(deployment), err)
+	}
+}
+
+// reaper stops expired previews every reapInterval until ctx is done or
+// the deployer is stopped.
+func (d *Deployer) reaper(ctx context.Context) {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.done:
+			return
+		case now := <-ticker.C:
+			d.reapPreviews(now)
+		}
+	}
+}
+
+// reapPreviews warns about previews close to expiry and stops the ones
+// past it. Previews with a deployment running or queued are left for the
+// next round; that deployment may well extend them.
+func (d *Deployer) reapPreviews(now time.Time) {
+	previews, err := d.storage.ListPreviews()
+	if err != nil {
+		log.Printf("Failed to list previews: %v", err)
+		return
+	}
+
+	for _, preview := range previews {
+		if !preview.ExpiredAt.IsZero() || preview.ExpiresAt().IsZero() {
+			continue
+		}
+		key := fmt.Sprintf("%s/%s/%s", preview.Owner, preview.Repo, preview.Environment)
+		if !d.tryClaim(key) {
+			continue
+		}
+		if err := d.reapPreview(preview.Owner, preview.Repo, preview.Environment, now); err != nil {
+			log.Printf("Failed to expire preview %s: %v", key, err)
+		}
+		d.unclaim(key)
+	}
+}
+
+// reapPreview checks one preview against its limits. The caller must have
+// claimed its environment.
+func (d *Deployer) reapPreview(owner, repo, environment string, now time.Time) error {
+	// Read it again now that no deployment can change it under us
+	preview, err := d.storage.GetPreview(owner, repo, environment)
+	if err != nil || !preview.ExpiredAt.IsZero() {
+		return nil
+	}
+	expires := preview.ExpiresAt()
+	if expires.IsZero() {
+		return nil
+	}
+
+	if !now.Before(expires) {
+		return d.expirePreview(preview, now)
+	}
+
+	// Warn once per expiry: a push that moves the expiry back arms the
+	// warning again
+	warning := d.config.PreviewExpiryWarning
+	warnAt := expires.Add(-warning)
+	if warning <= 0 || now.Before(warnAt) || !preview.WarnedAt.Before(warnAt) {
+		return nil
+	}
+	if preview.PRNumber > 0 && d.github != nil {
+		body := fmt.Sprintf("⏳ This preview will be stopped in %s, %s. Comment `%s` to bring it back afterwards.",
+			formatDuration(expires.Sub(now)), expiryReason(preview), WakeCommand)
+		if _, err := d.github.AddPRComment(owner, repo, preview.PRNumber, body); err != nil {
+			log.Printf("Failed to comment on %s/%s#%d: %v", owner, repo, preview.PRNumber, err)
+		}
+	}
+	preview.WarnedAt = now
+	return d.storage.SavePreview(preview)
+}
+
+// expirePreview stops a preview that has outlived its limits. Unlike a
+// teardown it remembers the preview, so a pull request can bring it back.
+func (d *Deployer) expirePreview(preview *models.Preview, now time.Time) error {
+	if _, err := d.stopEnvironment(preview.Owner, preview.Repo, preview.Environment, "Preview expired"); err != nil {
+		return err
+	}
+	preview.ExpiredAt = now
+	if err := d.storage.SavePreview(preview); err != nil {
+		return err
+	}
+
+	if preview.PRNumber > 0 && d.github != nil {
+		d.removePRComment(preview.Owner, preview.Repo, preview.PRNumber, "💤 Preview stopped")
+		body := fmt.Sprintf("💤 This preview was stopped %s. Comment `%s` or redeploy it from the dashboard to bring it back.",
+			expiryReason(preview), WakeCommand)
+		if _, err := d.github.AddPRComment(preview.Owner, preview.Repo, preview.PRNumber, body); err != nil {
+			log.Printf("Failed to comment on %s/%s#%d: %v", preview.Owner, preview.Repo, preview.PRNumber, err)
+		}
+	}
+
+	log.Printf("Preview %s/%s %s expired", preview.Owner, preview.Repo, preview.Environment)
+	return nil
+}
+
+// expiryReason says which limit a preview runs into first.
+func expiryReason(preview *models.Preview) string {
+	if preview.IdleTimeout > 0 && preview.ExpiresAt().Equal(preview.LastPushAt.Add(preview.IdleTimeout)) {
+		return fmt.Sprintf("after %s without a push", formatDuration(preview.IdleTimeout))
+	}
+	return fmt.Sprintf("%s after it was first deployed", formatDuration(preview.TTL))
+}
+
+// formatDuration renders d for people: whole days as days, anything else
+// to the minute.
+func formatDuration(d time.Duration) string {
+	if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		days := int(d / (24 * time.Hour))
+		if days == 1 {
+			return "1 day"
+		}
+		return fmt.Sprintf("%d days", days)
+	}
+	d = d.Round(time.Minute)
+	if d < time.Minute {
+		return "less than a minute"
+	}
+	return strings.TrimSuffix(d.String(), "0s")
+}
@@ -0,0 +1,133 @@
+package deployer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ejfox/dockrune/internal/detector"
+	"github.com/ejfox/dockrune/internal/models"
+)
+
+func TestPreviewExpiry(t *testing.T) {
+	d := newTestDeployer(t)
+	d.detector = detector.NewManager()
+	d.config.PreviewTTL = 72 * time.Hour
+	d.config.PreviewIdleTimeout = 24 * time.Hour
+	d.config.PreviewExpiryWarning = time.Hour
+	fake := useFakeRunners(t, d)
+
+	repo, _ := newTestRepo(t, "one")
+	sha := commitFile(t, repo, ".dockrune.yml", "start: ./server\npreview:\n  idle: 48h\n")
+
+	deploy := func(id string) *models.Deployment {
+		deployment := &models.Deployment{
+			ID: id, Owner: "ejfox", Repo: "site", SHA: sha, PRNumber: 7,
+			CloneURL: repo, Environment: "preview-pr-7", LogPath: filepath.Join(t.TempDir(), "deploy.log"),
+		}
+		if err := d.storage.CreateDeployment(deployment); err != nil {
+			t.Fatal(err)
+		}
+		logFile, err := createLog(deployment.LogPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer logFile.Close()
+		if err := d.deploy(context.Background(), deployment, logFile); err != nil {
+			t.Fatalf("deploy() error = %v", err)
+		}
+		deployment.Status = models.StatusSuccess
+		d.storage.UpdateDeployment(deployment)
+		return deployment
+	}
+	deployment := deploy("preview-1")
+
+	preview, err := d.storage.GetPreview("ejfox", "site", "preview-pr-7")
+	if err != nil {
+		t.Fatalf("preview not tracked: %v", err)
+	}
+	if preview.TTL != 72*time.Hour || preview.IdleTimeout != 48*time.Hour {
+		t.Errorf("limits = %s, %s; want the server's TTL and the repo's idle timeout", preview.TTL, preview.IdleTimeout)
+	}
+	expires := preview.ExpiresAt()
+
+	// Nothing happens until the warning is due
+	d.reapPreviews(expires.Add(-2 * time.Hour))
+	if preview, _ = d.storage.GetPreview("ejfox", "site", "preview-pr-7"); !preview.WarnedAt.IsZero() {
+		t.Error("warned before the warning was due")
+	}
+	d.reapPreviews(expires.Add(-30 * time.Minute))
+	if preview, _ = d.storage.GetPreview("ejfox", "site", "preview-pr-7"); preview.WarnedAt.IsZero() {
+		t.Error("not warned within the warning period")
+	}
+	if len(fake.running) != 1 {
+		t.Fatal("preview stopped before it expired")
+	}
+
+	d.reapPreviews(expires)
+	if len(fake.running) != 0 {
+		t.Errorf("apps still running after expiry: %v", fake.running)
+	}
+	if preview, _ = d.storage.GetPreview("ejfox", "site", "preview-pr-7"); preview.ExpiredAt.IsZero() {
+		t.Error("preview not marked expired")
+	}
+	if stored, _ := d.storage.GetDeployment(deployment.ID); stored.Status != models.StatusInactive {
+		t.Errorf("expired deployment status = %s, want %s", stored.Status, models.StatusInactive)
+	}
+
+	// A redeploy wakes it up with a fresh lifetime
+	deploy("preview-2")
+	preview, _ = d.storage.GetPreview("ejfox", "site", "preview-pr-7")
+	if !preview.ExpiredAt.IsZero() || !preview.WarnedAt.IsZero() || !preview.CreatedAt.After(expires.Add(-48*time.Hour)) {
+		t.Errorf("woken preview = %+v, want a new lifetime", preview)
+	}
+	if len(fake.running) != 1 {
+		t.Error("woken preview not running")
+	}
+}
+
+func TestPreviewExpiryWaitsForDeployments(t *testing.T) {
+	d := newTestDeployer(t)
+	now := time.Now()
+	preview := &models.Preview{
+		Owner: "ejfox", Repo: "site", Environment: "preview-pr-7",
+		CreatedAt: now.Add(-48 * time.Hour), LastPushAt: now.Add(-48 * time.Hour), IdleTimeout: 24 * time.Hour,
+	}
+	if err := d.storage.SavePreview(preview); err != nil {
+		t.Fatal(err)
+	}
+
+	// A push is waiting; it will extend the preview, so it's not reaped
+	queued := &models.Deployment{Owner: "ejfox", Repo: "site", SHA: "abcdef1234567", Environment: "preview-pr-7"}
+	if err := d.QueueDeployment(queued); err != nil {
+		t.Fatal(err)
+	}
+	d.reapPreviews(now)
+
+	if stored, _ := d.storage.GetPreview("ejfox", "site", "preview-pr-7"); !stored.ExpiredAt.IsZero() {
+		t.Error("preview expired while a deployment of it was queued")
+	}
+}
+
+func TestExpiresAt(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	pushed := created.Add(60 * time.Hour)
+
+	tests := []struct {
+		ttl, idle time.Duration
+		want      time.Time
+	}{
+		{0, 0, time.Time{}},
+		{72 * time.Hour, 0, created.Add(72 * time.Hour)},
+		{0, 24 * time.Hour, pushed.Add(24 * time.Hour)},
+		{72 * time.Hour, 24 * time.Hour, created.Add(72 * time.Hour)},
+		{96 * time.Hour, 24 * time.Hour, pushed.Add(24 * time.Hour)},
+	}
+	for _, tt := range tests {
+		preview := &models.Preview{CreatedAt: created, LastPushAt: pushed, TTL: tt.ttl, IdleTimeout: tt.idle}
+		if got := preview.ExpiresAt(); !got.Equal(tt.want) {
+			t.Errorf("ExpiresAt() with ttl %s, idle %s = %s, want %s", tt.ttl, tt.idle, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package deployer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ejfox/dockrune/internal/detector"
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectConfigVersion is the newest .dockrune.yml schema version we understand.
+const ProjectConfigVersion = 1
+
+// projectConfigFiles are the per-repo config file names, in lookup order.
+var projectConfigFiles = []string{".dockrune.yml", ".dockrune.yaml"}
+
+// ProjectConfig is the per-repository configuration read from .dockrune.yml.
+// Anything set here overrides what the detector guessed.
+type ProjectConfig struct {
+	Version     int                `yaml:"version"`
+	Install     string             `yaml:"install"`
+	Build       string             `yaml:"build"`
+	Start       string             `yaml:"start"`
+	Port        int                `yaml:"port"`
+	Domain      string             `yaml:"domain"`
+	Strategy    string             `yaml:"strategy"`
+	Runtime     string             `yaml:"runtime"`
+	Target      string             `yaml:"target"` // Dockerfile stage the docker runtime builds
+	Environment map[string]string  `yaml:"env"`
+	HealthCheck *HealthCheckConfig `yaml:"healthcheck"`
+	Hooks       HooksConfig        `yaml:"hooks"`
+	Timeouts    *Timeouts          `yaml:"timeouts"`
+	Preview     *PreviewConfig     `yaml:"preview"`
+}
+
+// HealthCheckConfig describes how to decide that a started app is healthy.
+type HealthCheckConfig struct {
+	Type           string        `yaml:"type"` // http, tcp, command or none
+	Path           string        `yaml:"path"`
+	ExpectedStatus int           `yaml:"expected_status"`
+	Command        string        `yaml:"command"`
+	Timeout        time.Duration `yaml:"timeout"`
+	Retries        int           `yaml:"retries"`
+	Interval       time.Duration `yaml:"interval"`
+}
+
+// HooksConfig lists commands run around a deployment.
+type HooksConfig struct {
+	PreDeploy  []string `yaml:"pre_deploy"`
+	PostDeploy []string `yaml:"post_deploy"`
+}
+
+// ProjectConfigError is a single problem found in a .dockrune.yml file.
+type ProjectConfigError struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (e *ProjectConfigError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.File, e.Message)
+}
+
+// Apply overrides the detected install/build/start/port with the configured
+// values.
+func (c *ProjectConfig) Apply(detection *detector.Detection) {
+	if c == nil || detection == nil {
+		return
+	}
+	if c.Install != "" {
+		detection.InstallCmd = c.Install
+	}
+	if c.Build != "" {
+		detection.BuildCmd = c.Build
+	}
+	if c.Start != "" {
+		detection.StartCmd = c.Start
+	}
+	if c.Port > 0 {
+		detection.Port = c.Port
+	}
+}
+
+// LoadProjectConfig reads .dockrune.yml from repoPath. It returns nil, nil if
+// the repository has no config file.
+func LoadProjectConfig(repoPath string) (*ProjectConfig, error) {
+	for _, name := range projectConfigFiles {
+		data, err := os.ReadFile(filepath.Join(repoPath, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		return ParseProjectConfig(name, data)
+	}
+	return nil, nil
+}
+
+// ParseProjectConfig parses and validates the contents of a .dockrune.yml
+// file. Errors carry the file name and line number of the offending key.
+func ParseProjectConfig(name string, data []byte) (*ProjectConfig, error) {
+	var cfg ProjectConfig
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && err != io.EOF {
+		return nil, yamlErrors(name, err)
+	}
+
+	var root yaml.Node
+	yaml.Unmarshal(data, &root)
+
+	var errs []error
+	invalid := func(msg string, path ...string) {
+		errs = append(errs, &ProjectConfigError{File: name, Line: lineOf(&root, path...), Message: msg})
+	}
+
+	if cfg.Version == 0 {
+		cfg.Version = ProjectConfigVersion
+	} else if cfg.Version < 0 || cfg.Version > ProjectConfigVersion {
+		invalid(fmt.Sprintf("unsupported version %d (this dockrune understands version %d)", cfg.Version, ProjectConfigVersion), "version")
+	}
+
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		invalid(fmt.Sprintf("port %d is out of range 1-65535", cfg.Port), "port")
+	}
+
+	if cfg.Domain != "" && !validHostname(cfg.Domain) {
+		invalid(fmt.Sprintf("domain %q is not a valid hostname", cfg.Domain), "domain")
+	}
+
+	switch cfg.Strategy {
+	case "", StrategyRecreate, StrategyBlueGreen:
+	default:
+		invalid(fmt.Sprintf("unknown strategy %q (want %s or %s)", cfg.Strategy, StrategyRecreate, StrategyBlueGreen), "strategy")
+	}
+
+	switch cfg.Runtime {
+	case "", RuntimeProcess, RuntimeDocker, RuntimeCompose:
+	default:
+		invalid(fmt.Sprintf("unknown runtime %q (want %s, %s or %s)", cfg.Runtime, RuntimeProcess, RuntimeDocker, RuntimeCompose), "runtime")
+	}
+
+	for key := range cfg.Environment {
+		if !envKeyPattern.MatchString(key) {
+			invalid(fmt.Sprintf("env key %q is not a valid variable name", key), "env", key)
+		}
+	}
+
+	if hc := cfg.HealthCheck; hc != nil {
+		switch hc.Type {
+		case "", "http", "tcp", "command", "none":
+		default:
+			invalid(fmt.Sprintf("unknown healthcheck type %q (want http, tcp, command or none)", hc.Type), "healthcheck", "type")
+		}
+		if hc.Type == "command" && hc.Command == "" {
+			invalid("healthcheck type command requires a command", "healthcheck", "type")
+		}
+		if hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+			invalid(fmt.Sprintf("healthcheck path %q must start with /", hc.Path), "healthcheck", "path")
+		}
+		if hc.ExpectedStatus != 0 && (hc.ExpectedStatus < 100 || hc.ExpectedStatus > 599) {
+			invalid(fmt.Sprintf("healthcheck expected_status %d is not an HTTP status", hc.ExpectedStatus), "healthcheck", "expected_status")
+		}
+		if hc.Timeout < 0 {
+			invalid("healthcheck timeout must not be negative", "healthcheck", "timeout")
+		}
+		if hc.Interval < 0 {
+			invalid("healthcheck interval must not be negative", "healthcheck", "interval")
+		}
+		if hc.Retries < 0 {
+			invalid("healthcheck retries must not be negative", "healthcheck", "retries")
+		}
+	}
+
+	if t := cfg.Timeouts; t != nil {
+		limits := []struct {
+			key   string
+			limit time.Duration
+		}{
+			{"clone", t.Clone}, {"build", t.Build}, {"start", t.Start}, {"healthcheck", t.HealthCheck}, {"deploy", t.Deploy},
+		}
+		for _, l := range limits {
+			if l.limit < 0 {
+				invalid(fmt.Sprintf("timeouts.%s must not be negative", l.key), "timeouts", l.key)
+			}
+		}
+	}
+
+	if p := cfg.Preview; p != nil {
+		if p.TTL != nil && *p.TTL < 0 {
+			invalid("preview.ttl must not be negative", "preview", "ttl")
+		}
+		if p.Idle != nil && *p.Idle < 0 {
+			invalid("preview.idle must not be negative", "preview", "idle")
+		}
+	}
+
+	for i, hook := range cfg.Hooks.PreDeploy {
+		if strings.TrimSpace(hook) == "" {
+			invalid(fmt.Sprintf("hooks.pre_deploy[%d] is empty", i), "hooks", "pre_deploy")
+		}
+	}
+	for i, hook := range cfg.Hooks.PostDeploy {
+		if strings.TrimSpace(hook) == "" {
+			invalid(fmt.Sprintf("hooks.post_deploy[%d] is empty", i), "hooks", "post_deploy")
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return &cfg, nil
+}
+
+var (
+	envKeyPattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	hostnamePattern = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+	yamlSyntaxError = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+	yamlTypeError   = regexp.MustCompile(`^line (\d+): (.*)$`)
+	yamlUnknownKey  = regexp.MustCompile(`^field (\S+) not found in type .*$`)
+)
+
+func validHostname(host string) bool {
+	return len(host) <= 253 && hostnamePattern.MatchString(host)
+}
+
+// yamlErrors turns yaml.v3 errors into ProjectConfigErrors so that every
+// message reads "file:line: problem".
+func yamlErrors(name string, err error) error {
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		errs := make([]error, 0, len(typeErr.Errors))
+		for _, msg := range typeErr.Errors {
+			line := 0
+			if m := yamlTypeError.FindStringSubmatch(msg); m != nil {
+				line, _ = strconv.Atoi(m[1])
+				msg = m[2]
+			}
+			if m := yamlUnknownKey.FindStringSubmatch(msg); m != nil {
+				msg = fmt.Sprintf("unknown key %q", m[1])
+			}
+			errs = append(errs, &ProjectConfigError{File: name, Line: line, Message: msg})
+		}
+		return errors.Join(errs...)
+	}
+
+	if m := yamlSyntaxError.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return &ProjectConfigError{File: name, Line: line, Message: m[2]}
+	}
+	return &ProjectConfigError{File: name, Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+}
+
+// lineOf returns the line of the value at path in a parsed YAML document,
+// falling back to the closest ancestor that exists.
+func lineOf(root *yaml.Node, path ...string) int {
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line := node.Line
+	for _, key := range path {
+		if node.Kind != yaml.MappingNode {
+			break
+		}
+		found := false
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				line = node.Content[i].Line
+				node = node.Content[i+1]
+				found = true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return line
+}
@@ -0,0 +1,166 @@
+package deployer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ejfox/dockrune/internal/detector"
+)
+
+func TestParseProjectConfig(t *testing.T) {
+	data := `version: 1
+build: make build
+start: ./bin/server --prod
+port: 9000
+domain: app.example.com
+env:
+  LOG_LEVEL: debug
+healthcheck:
+  type: http
+  path: /healthz
+  expected_status: 204
+  timeout: 5s
+  retries: 3
+  interval: 2s
+hooks:
+  pre_deploy:
+    - make migrate
+  post_deploy:
+    - make warm-cache
+`
+	cfg, err := ParseProjectConfig(".dockrune.yml", []byte(data))
+	if err != nil {
+		t.Fatalf("ParseProjectConfig() error = %v", err)
+	}
+
+	if cfg.Build != "make build" || cfg.Start != "./bin/server --prod" || cfg.Port != 9000 {
+		t.Errorf("unexpected commands: %+v", cfg)
+	}
+	if cfg.Domain != "app.example.com" {
+		t.Errorf("Domain = %v, want app.example.com", cfg.Domain)
+	}
+	if cfg.Environment["LOG_LEVEL"] != "debug" {
+		t.Errorf("env LOG_LEVEL = %v, want debug", cfg.Environment["LOG_LEVEL"])
+	}
+	if cfg.HealthCheck == nil || cfg.HealthCheck.Path != "/healthz" || cfg.HealthCheck.Timeout != 5*time.Second {
+		t.Errorf("unexpected healthcheck: %+v", cfg.HealthCheck)
+	}
+	if len(cfg.Hooks.PreDeploy) != 1 || len(cfg.Hooks.PostDeploy) != 1 {
+		t.Errorf("unexpected hooks: %+v", cfg.Hooks)
+	}
+}
+
+func TestParseProjectConfigDefaultsVersion(t *testing.T) {
+	cfg, err := ParseProjectConfig(".dockrune.yml", []byte("build: make build\nstart: ./bin/server\nport: 9000\n"))
+	if err != nil {
+		t.Fatalf("ParseProjectConfig() error = %v", err)
+	}
+	if cfg.Version != ProjectConfigVersion {
+		t.Errorf("Version = %d, want %d", cfg.Version, ProjectConfigVersion)
+	}
+}
+
+func TestParseProjectConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "syntax error",
+			data: "build: make\nstart: ./app\n\tport: 1\n",
+			want: "found a tab character that violates indentation",
+		},
+		{
+			name: "unknown key",
+			data: "build: make\nbuidl: make\n",
+			want: `.dockrune.yml:2: unknown key "buidl"`,
+		},
+		{
+			name: "wrong type",
+			data: "build: make\nport: lots\n",
+			want: ".dockrune.yml:2: cannot unmarshal",
+		},
+		{
+			name: "port out of range",
+			data: "start: ./app\n\nport: 70000\n",
+			want: ".dockrune.yml:3: port 70000 is out of range",
+		},
+		{
+			name: "unsupported version",
+			data: "version: 9\nstart: ./app\n",
+			want: ".dockrune.yml:1: unsupported version 9",
+		},
+		{
+			name: "bad healthcheck type",
+			data: "start: ./app\nhealthcheck:\n  type: ping\n",
+			want: `.dockrune.yml:3: unknown healthcheck type "ping"`,
+		},
+		{
+			name: "bad env key",
+			data: "env:\n  OK: yes\n  not-ok: no\n",
+			want: `.dockrune.yml:3: env key "not-ok"`,
+		},
+		{
+			name: "negative timeout",
+			data: "start: ./app\ntimeouts:\n  build: 10m\n  start: -1s\n",
+			want: ".dockrune.yml:4: timeouts.start must not be negative",
+		},
+		{
+			name: "unknown runtime",
+			data: "start: ./app\nruntime: podman\n",
+			want: `.dockrune.yml:2: unknown runtime "podman"`,
+		},
+		{
+			name: "negative preview ttl",
+			data: "start: ./app\npreview:\n  ttl: -24h\n",
+			want: ".dockrune.yml:3: preview.ttl must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseProjectConfig(".dockrune.yml", []byte(tt.data))
+			if err == nil {
+				t.Fatal("Expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadProjectConfig(t *testing.T) {
+	t.Run("missing file", func(t *testing.T) {
+		cfg, err := LoadProjectConfig(t.TempDir())
+		if err != nil || cfg != nil {
+			t.Errorf("LoadProjectConfig() = %v, %v; want nil, nil", cfg, err)
+		}
+	})
+
+	t.Run("overrides detection", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, ".dockrune.yml"), []byte("start: ./bin/server\nport: 9000\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg, err := LoadProjectConfig(dir)
+		if err != nil {
+			t.Fatalf("LoadProjectConfig() error = %v", err)
+		}
+
+		detection := &detector.Detection{Type: detector.TypeGo, BuildCmd: "go build -o app", StartCmd: "./app", Port: 8080}
+		cfg.Apply(detection)
+
+		if detection.BuildCmd != "go build -o app" {
+			t.Errorf("BuildCmd = %v, want detected value kept", detection.BuildCmd)
+		}
+		if detection.StartCmd != "./bin/server" || detection.Port != 9000 {
+			t.Errorf("detection not overridden: %+v", detection)
+		}
+	})
+}
@@ -0,0 +1,329 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ejfox/dockrune/internal/models"
+)
+
+// maxPending is how many queued deployments are held in memory. Anything
+// beyond that waits in storage and is loaded as the queue drains.
+const maxPending = 100
+
+// errInterrupted is recorded on deployments that were in progress when
+// dockrune stopped.
+var errInterrupted = fmt.Errorf("interrupted by restart")
+
+// environmentKey identifies the environment a deployment targets. Only one
+// deployment per key runs at a time, and a newer queued deployment replaces
+// an older one with the same key.
+func environmentKey(deployment *models.Deployment) string {
+	return fmt.Sprintf("%s/%s/%s", deployment.Owner, deployment.Repo, deployment.Environment)
+}
+
+// supersession is a queued deployment replaced by a newer one.
+type supersession struct {
+	old, newer *models.Deployment
+}
+
+// enqueue stores deployment as queued and adds it to the back of the
+// queue, taking out any deployments still waiting for the same environment.
+// Storage is the source of truth: once the in-memory queue is full, new
+// deployments are only stored and picked up by load later.
+func (d *Deployer) enqueue(deployment *models.Deployment) ([]supersession, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.storage.CreateDeployment(deployment); err != nil {
+		return nil, fmt.Errorf("failed to store deployment: %w", err)
+	}
+
+	if d.backlog || len(d.pending) >= maxPending {
+		d.backlog = true
+		return nil, nil
+	}
+	return d.push(deployment), nil
+}
+
+// push adds deployment to the in-memory queue. d.mu must be held.
+func (d *Deployer) push(deployment *models.Deployment) []supersession {
+	key := environmentKey(deployment)
+
+	var superseded []supersession
+	kept := d.pending[:0]
+	for _, queued := range d.pending {
+		if environmentKey(queued) == key {
+			superseded = append(superseded, supersession{old: queued, newer: deployment})
+			continue
+		}
+		kept = append(kept, queued)
+	}
+	d.pending = append(kept, deployment)
+	d.notify()
+	return superseded
+}
+
+// load fills the in-memory queue from deployments stored as queued, oldest
+// first, until it is full.
+func (d *Deployer) load() ([]supersession, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	queued, err := d.storage.ListDeploymentsByStatus(models.StatusQueued)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load queued deployments: %w", err)
+	}
+
+	inMemory := make(map[string]bool, len(d.pending))
+	for _, deployment := range d.pending {
+		inMemory[deployment.ID] = true
+	}
+
+	var superseded []supersession
+	d.backlog = false
+	for _, deployment := range queued {
+		if inMemory[deployment.ID] || d.active[deployment.ID] != nil {
+			continue
+		}
+		if len(d.pending) >= maxPending {
+			d.backlog = true
+			break
+		}
+		superseded = append(superseded, d.push(deployment)...)
+	}
+	return superseded, nil
+}
+
+// dequeue takes a queued deployment out of the queue by ID, whether it is
+// held in memory or still waiting in storage.
+func (d *Deployer) dequeue(id string) *models.Deployment {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, queued := range d.pending {
+		if queued.ID == id {
+			d.pending = append(d.pending[:i], d.pending[i+1:]...)
+			return queued
+		}
+	}
+
+	if !d.backlog || d.active[id] != nil {
+		return nil
+	}
+	deployment, err := d.storage.GetDeployment(id)
+	if err != nil || deployment.Status != models.StatusQueued {
+		return nil
+	}
+	// Mark it right away so load can't pick it up again
+	deployment.Status = models.StatusCancelled
+	d.storage.UpdateDeployment(deployment)
+	return deployment
+}
+
+// dequeueEnvironment takes every queued deployment of the environment key
+// out of the queue, whether held in memory or still waiting in storage.
+func (d *Deployer) dequeueEnvironment(key string) []*models.Deployment {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var dequeued []*models.Deployment
+	kept := d.pending[:0]
+	for _, queued := range d.pending {
+		if environmentKey(queued) == key {
+			dequeued = append(dequeued, queued)
+			continue
+		}
+		kept = append(kept, queued)
+	}
+	d.pending = kept
+
+	if !d.backlog {
+		return dequeued
+	}
+	stored, err := d.storage.ListDeploymentsByStatus(models.StatusQueued)
+	if err != nil {
+		log.Printf("Failed to list queued deployments of %s: %v", key, err)
+		return dequeued
+	}
+	for _, deployment := range stored {
+		if environmentKey(deployment) != key || d.active[deployment.ID] != nil || containsDeployment(dequeued, deployment.ID) {
+			continue
+		}
+		// Mark it right away so load can't pick it up again
+		deployment.Status = models.StatusCancelled
+		d.storage.UpdateDeployment(deployment)
+		dequeued = append(dequeued, deployment)
+	}
+	return dequeued
+}
+
+func containsDeployment(deployments []*models.Deployment, id string) bool {
+	for _, deployment := range deployments {
+		if deployment.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// next blocks until the oldest queued deployment whose environment is idle
+// can run, and claims its environment. It returns nil once ctx is done or
+// the deployer is stopped. The deployment runs on the returned context,
+// which Cancel can cancel from the moment it is claimed until release.
+func (d *Deployer) next(ctx context.Context) (*models.Deployment, context.Context) {
+	for {
+		d.mu.Lock()
+		backlog := d.backlog && len(d.pending) < maxPending
+		d.mu.Unlock()
+		if backlog {
+			superseded, err := d.load()
+			if err != nil {
+				log.Printf("Failed to refill queue: %v", err)
+			}
+			d.supersede(superseded)
+		}
+
+		d.mu.Lock()
+		if d.closed {
+			d.mu.Unlock()
+			return nil, nil
+		}
+		for i, queued := range d.pending {
+			key := environmentKey(queued)
+			if d.running[key] {
+				continue
+			}
+			d.pending = append(d.pending[:i], d.pending[i+1:]...)
+			d.running[key] = true
+			d.active[queued.ID] = queued
+			deployCtx, cancel := context.WithCancelCause(ctx)
+			d.cancels[queued.ID] = cancel
+			d.mu.Unlock()
+			return queued, deployCtx
+		}
+		changed := d.changed
+		d.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-changed:
+		}
+	}
+}
+
+// claim waits until no deployment of the environment key is running and
+// claims the environment as next does, so that none starts until unclaim.
+func (d *Deployer) claim(key string) {
+	for {
+		d.mu.Lock()
+		if !d.running[key] {
+			d.running[key] = true
+			d.mu.Unlock()
+			return
+		}
+		changed := d.changed
+		d.mu.Unlock()
+		<-changed
+	}
+}
+
+// tryClaim claims the environment key like claim, but only if no
+// deployment of it is running or waiting in the queue. It never blocks.
+func (d *Deployer) tryClaim(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.running[key] {
+		return false
+	}
+	for _, queued := range d.pending {
+		if environmentKey(queued) == key {
+			return false
+		}
+	}
+	d.running[key] = true
+	return true
+}
+
+func (d *Deployer) unclaim(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.running, key)
+	d.notify()
+}
+
+// release frees the environment claimed by next.
+func (d *Deployer) release(deployment *models.Deployment) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.running, environmentKey(deployment))
+	delete(d.active, deployment.ID)
+	if cancel, ok := d.cancels[deployment.ID]; ok {
+		cancel(nil)
+		delete(d.cancels, deployment.ID)
+	}
+	d.notify()
+}
+
+// notify wakes every worker waiting in next. d.mu must be held.
+func (d *Deployer) notify() {
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+// recover marks deployments left in progress by a previous run as failed
+// and queues the ones that never started.
+func (d *Deployer) recover() {
+	interrupted, err := d.storage.ListDeploymentsByStatus(models.StatusInProgress)
+	if err != nil {
+		log.Printf("Failed to list interrupted deployments: %v", err)
+	}
+	for _, deployment := range interrupted {
+		d.handleDeploymentError(deployment, errInterrupted)
+	}
+
+	superseded, err := d.load()
+	if err != nil {
+		log.Printf("Failed to re-enqueue deployments: %v", err)
+	}
+	d.supersede(superseded)
+
+	d.mu.Lock()
+	if n := len(d.pending); n > 0 {
+		log.Printf("Re-enqueued %d queued deployments", n)
+	}
+	d.mu.Unlock()
+}
+
+// supersede marks the old side of each supersession as superseded.
+func (d *Deployer) supersede(superseded []supersession) {
+	for _, s := range superseded {
+		d.handleDeploymentSuperseded(s.old, s.newer)
+	}
+}
+
+func (d *Deployer) handleDeploymentSuperseded(deployment, newer *models.Deployment) {
+	log.Printf("Deployment %s superseded by %s", deployment.ID, newer.ID)
+
+	deployment.Status = models.StatusSuperseded
+	deployment.Error = fmt.Sprintf("superseded by %s", newer.ID)
+	deployment.CompletedAt = time.Now()
+	d.storage.UpdateDeployment(deployment)
+
+	if d.github != nil && deployment.GitHubDeploymentID > 0 {
+		d.github.UpdateDeploymentStatus(
+			deployment.Owner,
+			deployment.Repo,
+			deployment.GitHubDeploymentID,
+			"inactive",
+			"",
+			fmt.Sprintf("Superseded by %s", shortSHA(newer.SHA)),
+		)
+	}
+}
@@ -0,0 +1,138 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ejfox/dockrune/internal/models"
+)
+
+func queueTestDeployment(t *testing.T, d *Deployer, repo, environment, sha string) *models.Deployment {
+	deployment := &models.Deployment{
+		Owner:       "ejfox",
+		Repo:        repo,
+		Ref:         "refs/heads/main",
+		SHA:         sha,
+		Environment: environment,
+	}
+	if err := d.QueueDeployment(deployment); err != nil {
+		t.Fatalf("QueueDeployment() error = %v", err)
+	}
+	return deployment
+}
+
+func TestNewerDeploymentSupersedesQueued(t *testing.T) {
+	d := newTestDeployer(t)
+
+	first := queueTestDeployment(t, d, "site", "production", "1111111aaaa")
+	other := queueTestDeployment(t, d, "site", "staging", "2222222bbbb")
+	second := queueTestDeployment(t, d, "site", "production", "3333333cccc")
+
+	stored, err := d.storage.GetDeployment(first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != models.StatusSuperseded {
+		t.Errorf("first Status = %s, want %s", stored.Status, models.StatusSuperseded)
+	}
+
+	var ids []string
+	for _, queued := range d.pending {
+		ids = append(ids, queued.ID)
+	}
+	if len(ids) != 2 || ids[0] != other.ID || ids[1] != second.ID {
+		t.Errorf("pending = %v, want [%s %s]", ids, other.ID, second.ID)
+	}
+}
+
+func TestOneDeploymentPerEnvironment(t *testing.T) {
+	d := newTestDeployer(t)
+
+	production := queueTestDeployment(t, d, "site", "production", "1111111aaaa")
+	staging := queueTestDeployment(t, d, "site", "staging", "2222222bbbb")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if got, _ := d.next(ctx); got != production {
+		t.Fatalf("next() = %v, want production", got)
+	}
+
+	// A new production deployment has to wait for the running one, but
+	// staging is free to go
+	newer := queueTestDeployment(t, d, "site", "production", "3333333cccc")
+	if got, _ := d.next(ctx); got != staging {
+		t.Fatalf("next() = %v, want staging", got)
+	}
+
+	short, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	if got, _ := d.next(short); got != nil {
+		t.Fatalf("next() = %s while production is running, want nil", got.ID)
+	}
+
+	d.release(production)
+	if got, _ := d.next(ctx); got != newer {
+		t.Fatalf("next() = %v after release, want the newer production deployment", got)
+	}
+}
+
+func TestRecoverAfterRestart(t *testing.T) {
+	d := newTestDeployer(t)
+
+	interrupted := &models.Deployment{
+		ID: "interrupted", Owner: "ejfox", Repo: "site", SHA: "1111111aaaa",
+		Environment: "production", Status: models.StatusInProgress,
+		LogPath: filepath.Join(d.config.LogsDir, "interrupted.log"),
+	}
+	waiting := &models.Deployment{
+		ID: "waiting", Owner: "ejfox", Repo: "site", SHA: "2222222bbbb",
+		Environment: "staging", Status: models.StatusQueued,
+	}
+	for _, deployment := range []*models.Deployment{interrupted, waiting} {
+		if err := d.storage.CreateDeployment(deployment); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d.recover()
+
+	stored, err := d.storage.GetDeployment("interrupted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != models.StatusFailed || stored.Error != errInterrupted.Error() {
+		t.Errorf("interrupted deployment = %s %q, want failed %q", stored.Status, stored.Error, errInterrupted)
+	}
+
+	if len(d.pending) != 1 || d.pending[0].ID != "waiting" {
+		t.Errorf("pending = %v, want the queued deployment", d.pending)
+	}
+}
+
+func TestBacklogWaitsInStorage(t *testing.T) {
+	d := newTestDeployer(t)
+
+	for i := 0; i < maxPending+1; i++ {
+		queueTestDeployment(t, d, "site", fmt.Sprintf("preview-%d", i), fmt.Sprintf("%07daaaa", i))
+	}
+	if len(d.pending) != maxPending || !d.backlog {
+		t.Fatalf("pending = %d, backlog = %v; want %d and true", len(d.pending), d.backlog, maxPending)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// Taking one frees a slot, so the next call pulls the last one in
+	d.next(ctx)
+	d.next(ctx)
+	if d.backlog {
+		t.Error("backlog still set after the queue drained")
+	}
+	if len(d.pending) != maxPending-1 {
+		t.Errorf("pending = %d, want %d", len(d.pending), maxPending-1)
+	}
+}
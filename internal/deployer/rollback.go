@@ -0,0 +1,130 @@
+package deployer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ejfox/dockrune/internal/models"
+)
+
+// rollback redeploys the environment's last successful deployment after
+// failed broke it. The rollback is recorded as its own deployment. It
+// returns nil if there was nothing to roll back to or the rollback failed.
+func (d *Deployer) rollback(ctx context.Context, failed *models.Deployment, cause error, logFile *deployLog) *models.Deployment {
+	if !d.config.AutoRollback {
+		return nil
+	}
+
+	previous, err := d.storage.GetLastSuccessfulDeployment(failed.Owner, failed.Repo, failed.Environment)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to look up last successful deployment for %s: %v", failed.ID, err)
+		}
+		fmt.Fprintf(logFile, "No previous successful deployment of %s to roll back to\n", failed.Environment)
+		return nil
+	}
+
+	fmt.Fprintf(logFile, "Rolling back %s to %s (deployment %s)...\n", failed.Environment, shortSHA(previous.SHA), previous.ID)
+	log.Printf("Rolling back %s to deployment %s", failed.ID, previous.ID)
+
+	rollback := &models.Deployment{
+		Owner:       failed.Owner,
+		Repo:        failed.Repo,
+		Ref:         previous.Ref,
+		SHA:         previous.SHA,
+		CloneURL:    failed.CloneURL,
+		Environment: failed.Environment,
+		PRNumber:    failed.PRNumber,
+		RollbackOf:  failed.ID,
+		Status:      models.StatusInProgress,
+		StartedAt:   time.Now(),
+	}
+	d.assignID(rollback, "-rollback")
+
+	if err := d.storage.CreateDeployment(rollback); err != nil {
+		fmt.Fprintf(logFile, "Rollback failed: could not store deployment: %v\n", err)
+		return nil
+	}
+
+	// Count it as active so its worktree isn't pruned while it builds
+	d.mu.Lock()
+	d.active[rollback.ID] = rollback
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.active, rollback.ID)
+		d.mu.Unlock()
+	}()
+
+	if d.github != nil {
+		deploymentID, err := d.github.CreateDeployment(rollback.Owner, rollback.Repo, rollback.SHA, rollback.Environment)
+		if err == nil {
+			rollback.GitHubDeploymentID = deploymentID
+			d.storage.UpdateDeployment(rollback)
+			d.github.UpdateDeploymentStatus(
+				rollback.Owner,
+				rollback.Repo,
+				rollback.GitHubDeploymentID,
+				"in_progress",
+				"",
+				fmt.Sprintf("Rolling back to %s", shortSHA(rollback.SHA)),
+			)
+		}
+	}
+
+	rollbackLog, err := d.openLog(rollback)
+	if err != nil {
+		d.handleDeploymentError(rollback, fmt.Errorf("failed to create log file: %w", err))
+		return nil
+	}
+	defer rollbackLog.Close()
+
+	fmt.Fprintf(rollbackLog, "Rolling back to %s after deployment %s of %s failed: %v\n",
+		shortSHA(rollback.SHA), failed.ID, shortSHA(failed.SHA), cause)
+
+	if err := d.deploy(ctx, rollback, rollbackLog); err != nil {
+		fmt.Fprintf(logFile, "Rollback failed: %v\n", err)
+		d.handleDeploymentError(rollback, fmt.Errorf("rollback failed: %w", err))
+		return nil
+	}
+
+	rollback.Status = models.StatusSuccess
+	rollback.CompletedAt = time.Now()
+	d.storage.UpdateDeployment(rollback)
+	fmt.Fprintf(logFile, "Rolled back to %s (deployment %s)\n", shortSHA(rollback.SHA), rollback.ID)
+
+	if d.github != nil && rollback.GitHubDeploymentID > 0 {
+		d.github.UpdateDeploymentStatus(
+			rollback.Owner,
+			rollback.Repo,
+			rollback.GitHubDeploymentID,
+			"success",
+			rollback.URL,
+			fmt.Sprintf("Rolled back to %s after %s failed", shortSHA(rollback.SHA), shortSHA(failed.SHA)),
+		)
+	}
+
+	if d.alerting != nil {
+		d.alerting.SendDeploymentRollback(
+			fmt.Sprintf("%s/%s", rollback.Owner, rollback.Repo),
+			rollback.Environment,
+			failed.SHA,
+			rollback.SHA,
+			cause.Error(),
+			rollback.URL,
+		)
+	}
+
+	return rollback
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
@@ -0,0 +1,183 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ejfox/dockrune/internal/config"
+	"github.com/ejfox/dockrune/internal/models"
+	"github.com/ejfox/dockrune/internal/proxy"
+	"github.com/ejfox/dockrune/internal/routing"
+)
+
+// ProxyBuiltin has dockrune serve deployments through its own proxy
+// instead of writing config for another one.
+const ProxyBuiltin = "builtin"
+
+// routeTimeout bounds the route phase, which may wait on a CA to issue a
+// certificate.
+const routeTimeout = 5 * time.Minute
+
+// routeTable keeps the hostname routes of deployed environments: a
+// reverse proxy's config file, or the built-in proxy.
+type routeTable interface {
+	Set(route routing.Route) error
+	RemoveEnvironment(owner, repo, environment string) error
+	Load(routes []routing.Route) error
+}
+
+// placeholders is implemented by route tables that can answer for an
+// environment that has no healthy backend.
+type placeholders interface {
+	Deploying(route routing.Route)
+	Failed(owner, repo, environment string)
+	Settled(owner, repo, environment string)
+}
+
+// newRouter returns the route table of the configured reverse proxy, or
+// nil if there is none.
+func newRouter(cfg *config.Config) routeTable {
+	switch cfg.Proxy {
+	case "":
+		return nil
+	case ProxyBuiltin:
+		return proxy.New(cfg.ProxyUpstream, cfg.DeploymentDomain)
+	}
+	router, err := routing.New(cfg.Proxy, cfg.ProxyConfig)
+	if err != nil {
+		log.Printf("Reverse proxy config disabled: %v", err)
+		return nil
+	}
+	if cfg.ProxyReload != "" {
+		router.Reload = cfg.ProxyReload
+	}
+	if cfg.ProxyUpstream != "" {
+		router.Upstream = cfg.ProxyUpstream
+	}
+	router.Challenge = challengeAddr(cfg)
+	return router
+}
+
+// ProxyHandler returns the built-in proxy, or nil if it isn't enabled.
+// With TLS it also answers ACME http-01 challenges.
+func (d *Deployer) ProxyHandler() http.Handler {
+	handler, ok := d.router.(http.Handler)
+	if !ok {
+		return nil
+	}
+	if d.certs != nil {
+		return d.certs.HTTPHandler(handler)
+	}
+	return handler
+}
+
+// proxyRoute is the route from the host of rawURL to a deployment's stable
+// port.
+func proxyRoute(deployment *models.Deployment, rawURL string) (routing.Route, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return routing.Route{}, false
+	}
+	return routing.Route{
+		Host:        u.Hostname(),
+		Port:        deployment.Port,
+		Owner:       deployment.Owner,
+		Repo:        deployment.Repo,
+		Environment: deployment.Environment,
+	}, true
+}
+
+// route points the reverse proxy at a deployment that is now serving its
+// environment, with a certificate if TLS is on.
+func (d *Deployer) route(ctx context.Context, deployment *models.Deployment, logFile *deployLog) error {
+	if d.router == nil {
+		return nil
+	}
+	route, ok := proxyRoute(deployment, deployment.URL)
+	if !ok || route.Port == 0 {
+		return nil
+	}
+	if d.certs != nil {
+		issued, err := d.secure(ctx, &route)
+		if err != nil {
+			return fmt.Errorf("certificate for %s: %w", route.Host, err)
+		}
+		if issued {
+			fmt.Fprintf(logFile, "Issued certificate for %s\n", route.Host)
+		}
+	}
+	if err := d.router.Set(route); err != nil {
+		return fmt.Errorf("failed to update reverse proxy: %w", err)
+	}
+	fmt.Fprintf(logFile, "Routing %s to port %d\n", route.Host, route.Port)
+	return nil
+}
+
+// showDeploying has the proxy answer for a deployment's environment with
+// the deploying page, as long as it has no backend that answers.
+func (d *Deployer) showDeploying(deployment *models.Deployment, projectConfig *ProjectConfig) {
+	p, ok := d.router.(placeholders)
+	if !ok {
+		return
+	}
+	if route, ok := proxyRoute(deployment, d.generateURL(deployment, projectConfig)); ok {
+		p.Deploying(route)
+	}
+}
+
+// showFailed turns a failed deployment's deploying page into the failed
+// page.
+func (d *Deployer) showFailed(deployment *models.Deployment) {
+	if p, ok := d.router.(placeholders); ok {
+		p.Failed(deployment.Owner, deployment.Repo, deployment.Environment)
+	}
+}
+
+// settle takes down a cancelled deployment's deploying page.
+func (d *Deployer) settle(deployment *models.Deployment) {
+	if p, ok := d.router.(placeholders); ok {
+		p.Settled(deployment.Owner, deployment.Repo, deployment.Environment)
+	}
+}
+
+// unroute removes an environment from the reverse proxy.
+func (d *Deployer) unroute(owner, repo, environment string) {
+	if d.router == nil {
+		return
+	}
+	if err := d.router.RemoveEnvironment(owner, repo, environment); err != nil {
+		log.Printf("Failed to remove %s/%s %s from reverse proxy: %v", owner, repo, environment, err)
+	}
+}
+
+// restoreProxyRoutes rewrites the reverse proxy config from the serving
+// deployments on startup, so that it matches what's running.
+func (d *Deployer) restoreProxyRoutes() {
+	if d.router == nil {
+		return
+	}
+	serving, err := d.servingDeployments()
+	if err != nil {
+		log.Printf("Failed to restore reverse proxy routes: %v", err)
+		return
+	}
+
+	var routes []routing.Route
+	for _, deployment := range serving {
+		route, ok := proxyRoute(deployment, deployment.URL)
+		if !ok || route.Port == 0 {
+			continue
+		}
+		if d.certs != nil {
+			route.CertFile, route.KeyFile, _ = d.certs.Files(route.Host)
+		}
+		routes = append(routes, route)
+	}
+	if err := d.router.Load(routes); err != nil {
+		log.Printf("Failed to restore reverse proxy routes: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package deployer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ejfox/dockrune/internal/detector"
+	"github.com/ejfox/dockrune/internal/models"
+	"github.com/ejfox/dockrune/internal/proxy"
+	"github.com/ejfox/dockrune/internal/routing"
+)
+
+func TestDeployRoutesProxy(t *testing.T) {
+	d := newTestDeployer(t)
+	d.detector = detector.NewManager()
+	d.config.DeploymentDomain = "example.com"
+	useFakeRunners(t, d)
+
+	router, err := routing.New(routing.ProxyCaddy, filepath.Join(t.TempDir(), "Caddyfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.Reload = ""
+	d.router = router
+
+	repo, _ := newTestRepo(t, "one")
+	sha := commitFile(t, repo, ".dockrune.yml", "start: ./server\n")
+
+	deployment := &models.Deployment{
+		ID: "preview-1", Owner: "ejfox", Repo: "site", SHA: sha,
+		CloneURL: repo, Environment: "preview-pr-7", LogPath: filepath.Join(t.TempDir(), "deploy.log"),
+	}
+	if err := d.storage.CreateDeployment(deployment); err != nil {
+		t.Fatal(err)
+	}
+	logFile, err := createLog(deployment.LogPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+	if err := d.deploy(context.Background(), deployment, logFile); err != nil {
+		t.Fatalf("deploy() error = %v", err)
+	}
+	deployment.Status = models.StatusSuccess
+	d.storage.UpdateDeployment(deployment)
+
+	caddyfile, _ := os.ReadFile(router.Path())
+	if !strings.Contains(string(caddyfile), "preview-pr-7.example.com {") {
+		t.Errorf("Caddyfile after deploy doesn't route the preview:\n%s", caddyfile)
+	}
+
+	if err := d.TeardownEnvironment("ejfox", "site", "preview-pr-7"); err != nil {
+		t.Fatal(err)
+	}
+	caddyfile, _ = os.ReadFile(router.Path())
+	if strings.Contains(string(caddyfile), "preview-pr-7.example.com") {
+		t.Errorf("Caddyfile still routes the torn down preview:\n%s", caddyfile)
+	}
+}
+
+func TestBuiltinProxyPlaceholders(t *testing.T) {
+	d := newTestDeployer(t)
+	d.detector = detector.NewManager()
+	d.config.DeploymentDomain = "example.com"
+	d.config.AutoRollback = false
+	d.router = proxy.New("127.0.0.1", "example.com")
+	useFakeRunners(t, d)
+
+	repo, _ := newTestRepo(t, "one")
+	sha := commitFile(t, repo, ".dockrune.yml", "start: ./server\nhealthcheck:\n  type: command\n  command: \"false\"\n  retries: 1\n")
+
+	deployment := &models.Deployment{
+		ID: "preview-1", Owner: "ejfox", Repo: "site", SHA: sha,
+		CloneURL: repo, Environment: "preview-pr-7", LogPath: filepath.Join(t.TempDir(), "deploy.log"),
+	}
+	if err := d.storage.CreateDeployment(deployment); err != nil {
+		t.Fatal(err)
+	}
+	d.processDeployment(context.Background(), context.Background(), deployment)
+
+	rec := httptest.NewRecorder()
+	d.ProxyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://preview-pr-7.example.com/", nil))
+	if rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), "Deployment failed") {
+		t.Errorf("failed preview answers %d %q, want the failed page", rec.Code, rec.Body.String())
+	}
+}
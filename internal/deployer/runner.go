@@ -0,0 +1,529 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ejfox/dockrune/internal/detector"
+	"github.com/ejfox/dockrune/internal/health"
+	"github.com/ejfox/dockrune/internal/models"
+	"github.com/ejfox/dockrune/internal/supervisor"
+)
+
+// Runtimes an app can run under.
+const (
+	// RuntimeProcess runs the start command under dockrune's supervisor.
+	RuntimeProcess = "process"
+	// RuntimeDocker builds the repo's Dockerfile and runs one container.
+	RuntimeDocker = "docker"
+	// RuntimeCompose runs the repo's docker-compose project.
+	RuntimeCompose = "compose"
+)
+
+// runtimes lists every runtime, in the order apps are stopped by name.
+var runtimes = []string{RuntimeProcess, RuntimeDocker, RuntimeCompose}
+
+// App is one version of an app as handed to a Runner.
+type App struct {
+	Name    string // process, container or compose project name
+	Dir     string // working tree
+	Command string // start command; only the process runtime uses it
+	Port    int
+	Env     map[string]string // the deployment's variables, without PORT
+	Version string            // commit being deployed; tags docker images
+	Target  string            // Dockerfile stage to build, "" for the last
+}
+
+// RunStatus is how a runtime sees an app.
+type RunStatus struct {
+	Running bool
+	State   string // as the runtime reports it, e.g. running, exited, crashloop
+	Detail  string
+}
+
+// Runner starts and stops apps under one runtime. Stopping an app the
+// runtime has never heard of is not an error, and neither is asking for
+// its status: it's just not running.
+type Runner interface {
+	Start(ctx context.Context, app App, logFile *deployLog) error
+	Stop(name string) error
+	Status(name string) (RunStatus, error)
+	Logs(name string, lines int) (string, error)
+}
+
+// cleaner is implemented by runners that leave something behind, such as
+// images, once an app has stopped. Clean is called when the app won't be
+// started again.
+type cleaner interface {
+	Clean(name string)
+}
+
+// pruner is implemented by runners that keep older versions of an app
+// around, such as images, to roll back to. Prune is called once a
+// deployment of the app has succeeded.
+type pruner interface {
+	Prune(app App, logFile *deployLog)
+}
+
+// builder is implemented by runners that build apps themselves. Build runs
+// as the build phase instead of the build command.
+type builder interface {
+	Build(ctx context.Context, app App, logFile *deployLog) error
+}
+
+// runtimeFor picks the runtime of a deployment: the project config's if it
+// names one, otherwise the one that fits what was detected.
+func runtimeFor(detection *detector.Detection, projectConfig *ProjectConfig) string {
+	if projectConfig != nil && projectConfig.Runtime != "" {
+		return projectConfig.Runtime
+	}
+	if detection.Type == detector.TypeDocker {
+		if _, ok := detection.Metadata["compose_file"]; ok {
+			return RuntimeCompose
+		}
+		return RuntimeDocker
+	}
+	return RuntimeProcess
+}
+
+// runner returns the runner of a deployment. Deployments made before
+// runtimes were recorded ran docker projects under compose.
+func (d *Deployer) runner(deployment *models.Deployment) Runner {
+	if r, ok := d.runners[deployment.Runtime]; ok {
+		return r
+	}
+	if deployment.ProjectType == string(detector.TypeDocker) {
+		return d.runners[RuntimeCompose]
+	}
+	return d.runners[RuntimeProcess]
+}
+
+// processRunner runs apps as supervised processes.
+type processRunner struct {
+	supervisor *supervisor.Supervisor
+}
+
+func (r *processRunner) Start(ctx context.Context, app App, logFile *deployLog) error {
+	err := r.supervisor.Start(supervisor.Spec{
+		Name:    app.Name,
+		Dir:     app.Dir,
+		Command: app.Command,
+		Env:     environ(app.Port, app.Env),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(logFile, "Started %s, output in %s\n", app.Name, r.supervisor.LogPath(app.Name))
+	return nil
+}
+
+func (r *processRunner) Stop(name string) error {
+	r.supervisor.Stop(name)
+	return nil
+}
+
+func (r *processRunner) Status(name string) (RunStatus, error) {
+	status, ok := r.supervisor.Status(name)
+	if !ok {
+		return RunStatus{State: "stopped"}, nil
+	}
+	return RunStatus{
+		Running: status.State == supervisor.StateRunning,
+		State:   string(status.State),
+		Detail:  status.LastExit,
+	}, nil
+}
+
+func (r *processRunner) Logs(name string, lines int) (string, error) {
+	out, err := r.supervisor.Tail(name, lines)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	return out, err
+}
+
+// dockerRunner builds a repo's Dockerfile into an image tagged with the
+// deployment's commit and runs it as a single container named after the
+// app. The app's port is published on the port the image exposes, or on
+// the same port if it exposes none, and PORT is set to that container
+// port. Older images of the app are removed once a new one is running.
+type dockerRunner struct{}
+
+// image is the repository an app's images are built under; it must be
+// lowercase.
+func (r *dockerRunner) image(name string) string {
+	return "dockrune/" + strings.ToLower(name)
+}
+
+// tag is the image a version of an app is built as.
+func (r *dockerRunner) tag(app App) string {
+	version := strings.ToLower(app.Version)
+	if len(version) > 12 {
+		version = version[:12]
+	}
+	if version == "" {
+		version = "latest"
+	}
+	return r.image(app.Name) + ":" + version
+}
+
+// stage reads the repo's Dockerfile and returns the stage being built.
+func (r *dockerRunner) stage(app App) (detector.DockerStage, error) {
+	dockerfile, err := detector.ParseDockerfile(filepath.Join(app.Dir, "Dockerfile"))
+	if err != nil {
+		return detector.DockerStage{}, err
+	}
+	stage, ok := dockerfile.Stage(app.Target)
+	if !ok {
+		if names := dockerfile.Names(); len(names) > 0 {
+			return stage, fmt.Errorf("Dockerfile has no stage %q (stages: %s)", app.Target, strings.Join(names, ", "))
+		}
+		return stage, fmt.Errorf("Dockerfile has no stage %q", app.Target)
+	}
+	return stage, nil
+}
+
+// containerPort is the port an app listens on inside its container.
+func (r *dockerRunner) containerPort(app App, stage detector.DockerStage) int {
+	if len(stage.Expose) > 0 {
+		return stage.Expose[0]
+	}
+	return app.Port
+}
+
+func (r *dockerRunner) buildArgs(app App) []string {
+	args := []string{"build", "-t", r.tag(app)}
+	if app.Target != "" {
+		args = append(args, "--target", app.Target)
+	}
+	return append(args, ".")
+}
+
+func (r *dockerRunner) runArgs(app App, containerPort int) []string {
+	args := []string{"run", "-d", "--name", app.Name, "--restart", "unless-stopped",
+		"-p", fmt.Sprintf("%d:%d", app.Port, containerPort)}
+	// Values are passed through our environment, not the command line,
+	// so they don't show up in ps
+	keys := make([]string, 0, len(app.Env))
+	for key := range app.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range append(keys, "PORT") {
+		args = append(args, "-e", key)
+	}
+	return append(args, r.tag(app))
+}
+
+func (r *dockerRunner) Build(ctx context.Context, app App, logFile *deployLog) error {
+	if _, err := r.stage(app); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(logFile, "Building image %s\n", r.tag(app))
+	cmd := newCommand(ctx, "docker", r.buildArgs(app)...)
+	cmd.Dir = app.Dir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	return cmd.Run()
+}
+
+func (r *dockerRunner) Start(ctx context.Context, app App, logFile *deployLog) error {
+	stage, err := r.stage(app)
+	if err != nil {
+		return err
+	}
+	if err := r.Stop(app.Name); err != nil {
+		return err
+	}
+
+	port := r.containerPort(app, stage)
+	if len(stage.Expose) == 0 {
+		fmt.Fprintf(logFile, "Dockerfile doesn't EXPOSE a port, the app should listen on PORT (%d)\n", port)
+	}
+	fmt.Fprintf(logFile, "Starting container %s, publishing port %d on port %d\n", app.Name, port, app.Port)
+	cmd := newCommand(ctx, "docker", r.runArgs(app, port)...)
+	cmd.Dir = app.Dir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.Env = environ(port, app.Env)
+	return cmd.Run()
+}
+
+// Prune removes the images of the app's older versions. It waits for the
+// deployment to succeed, so that a rollback finds the previous image.
+func (r *dockerRunner) Prune(app App, logFile *deployLog) {
+	r.removeImages(app.Name, r.tag(app), logFile)
+}
+
+// removeImages removes the images of an app except keep. Images that are
+// still in use stay.
+func (r *dockerRunner) removeImages(name, keep string, logFile io.Writer) {
+	out, err := exec.Command("docker", "images", "--format", "{{.Repository}}:{{.Tag}}", r.image(name)).Output()
+	if err != nil {
+		return
+	}
+	for _, image := range strings.Fields(string(out)) {
+		if image == keep || strings.HasSuffix(image, ":<none>") {
+			continue
+		}
+		if out, err := exec.Command("docker", "rmi", image).CombinedOutput(); err != nil {
+			fmt.Fprintf(logFile, "Could not remove old image %s: %s\n", image, bytes.TrimSpace(out))
+			continue
+		}
+		fmt.Fprintf(logFile, "Removed old image %s\n", image)
+	}
+}
+
+// Clean removes every image of an app that's gone for good.
+func (r *dockerRunner) Clean(name string) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return
+	}
+	r.removeImages(name, "", io.Discard)
+}
+
+func (r *dockerRunner) Stop(name string) error {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return nil // nothing can be running under docker
+	}
+	out, err := exec.Command("docker", "rm", "-f", name).CombinedOutput()
+	if err != nil && !bytes.Contains(out, []byte("No such container")) {
+		return fmt.Errorf("docker rm: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (r *dockerRunner) Status(name string) (RunStatus, error) {
+	out, err := exec.Command("docker", "inspect", "-f", "{{.State.Status}}", name).CombinedOutput()
+	if err != nil {
+		if bytes.Contains(out, []byte("No such object")) {
+			return RunStatus{State: "stopped"}, nil
+		}
+		return RunStatus{}, fmt.Errorf("docker inspect: %w: %s", err, bytes.TrimSpace(out))
+	}
+	state := strings.TrimSpace(string(out))
+	return RunStatus{Running: state == "running", State: state}, nil
+}
+
+func (r *dockerRunner) Logs(name string, lines int) (string, error) {
+	out, err := exec.Command("docker", "logs", "--tail", fmt.Sprint(lines), name).CombinedOutput()
+	return string(out), err
+}
+
+// dockerHealthInterval is how often a container's health is looked up
+// while waiting for its HEALTHCHECK to pass.
+const dockerHealthInterval = 2 * time.Second
+
+// dockerfileHealthcheck returns the HEALTHCHECK of the stage being built,
+// or nil if it has none or turns it off.
+func dockerfileHealthcheck(repoPath string, projectConfig *ProjectConfig) *detector.Healthcheck {
+	dockerfile, err := detector.ParseDockerfile(filepath.Join(repoPath, "Dockerfile"))
+	if err != nil {
+		return nil
+	}
+	var target string
+	if projectConfig != nil {
+		target = projectConfig.Target
+	}
+	stage, ok := dockerfile.Stage(target)
+	if !ok || stage.Healthcheck == nil || stage.Healthcheck.Disabled {
+		return nil
+	}
+	return stage.Healthcheck
+}
+
+// dockerHealthCheck waits for docker to report container name healthy,
+// for as long as its HEALTHCHECK may take to get there.
+func dockerHealthCheck(name string, hc *detector.Healthcheck) health.Check {
+	interval, retries := hc.Interval, hc.Retries
+	if interval <= 0 {
+		interval = 30 * time.Second // docker's defaults
+	}
+	if retries <= 0 {
+		retries = 3
+	}
+	wait := hc.StartPeriod + interval*time.Duration(retries+1)
+
+	return health.Check{
+		Type: health.TypeCommand,
+		Command: fmt.Sprintf(`status=$(docker inspect -f '{{if .State.Health}}{{.State.Health.Status}}{{else}}not checked{{end}}' %s) && echo "container is $status" && test "$status" = healthy`,
+			name),
+		Retries:  int(wait/dockerHealthInterval) + 1,
+		Interval: dockerHealthInterval,
+	}
+}
+
+// composeRunner runs a repo's docker-compose project under the app's name.
+// The web service is published on the app's port, through PORT if the
+// compose file maps it from there and through a generated override file
+// otherwise.
+type composeRunner struct {
+	once    sync.Once
+	version string // of docker-compose, "" if it couldn't be told
+}
+
+// composeOverride is the override file that publishes the web service,
+// written next to the repo's compose files.
+const composeOverride = ".dockrune.compose.yml"
+
+// composeOverrideTemplate maps the app's port to the web service's port.
+// For a service that pins a host port the ports are tagged !override, which
+// replaces them rather than adding to them, so that the pinned port doesn't
+// clash between environments.
+const composeOverrideTemplate = `# Generated by dockrune, publishes the web service on the deployment's port
+services:
+  %s:
+    ports:%s
+      - "%d:%d"
+`
+
+// composeOverrideVersion is the first docker compose release that
+// understands the !override tag.
+var composeOverrideVersion = [2]int{2, 24}
+
+// files returns the -f flags naming a repo's compose files. With publish,
+// it also makes sure the web service is published on the app's port.
+func (r *composeRunner) files(app App, logFile *deployLog, publish bool) ([]string, error) {
+	project, err := detector.LoadCompose(app.Dir)
+	if project == nil {
+		return nil, err // compose will complain about the missing file itself
+	}
+	var args []string
+	for _, file := range project.Files {
+		args = append(args, "-f", file)
+	}
+	if !publish {
+		return args, nil
+	}
+
+	switch {
+	case err != nil:
+		fmt.Fprintf(logFile, "Warning: can't find the web service: %v\n", err)
+	case project.Web == "" || project.Port == 0:
+		fmt.Fprintf(logFile, "Warning: no service in %s publishes or exposes a port, nothing is published on port %d\n", strings.Join(project.Files, ", "), app.Port)
+	case usesPortVariable(project.HostPort):
+		fmt.Fprintf(logFile, "Service %s publishes port %d on %s, port %d\n", project.Web, project.Port, project.HostPort, app.Port)
+	default:
+		tag := ""
+		if project.HostPort != "" {
+			if !r.supportsOverride() {
+				return nil, fmt.Errorf("service %s pins host port %s, and replacing it needs docker compose %d.%d or later (found %s); publish it from ${PORT} instead, e.g. \"${PORT:-%s}:%d\"",
+					project.Web, project.HostPort, composeOverrideVersion[0], composeOverrideVersion[1], r.version, project.HostPort, project.Port)
+			}
+			tag = " !override"
+		}
+		override := fmt.Sprintf(composeOverrideTemplate, project.Web, tag, app.Port, project.Port)
+		if err := os.WriteFile(filepath.Join(app.Dir, composeOverride), []byte(override), 0644); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(logFile, "Publishing service %s port %d on port %d\n", project.Web, project.Port, app.Port)
+		args = append(args, "-f", composeOverride)
+	}
+	return args, nil
+}
+
+// supportsOverride reports whether docker-compose understands !override. A
+// version that can't be told is assumed to be recent.
+func (r *composeRunner) supportsOverride() bool {
+	r.once.Do(func() {
+		out, err := exec.Command("docker-compose", "version", "--short").Output()
+		if err == nil {
+			r.version = strings.TrimSpace(string(out))
+		}
+	})
+	major, minor, ok := parseComposeVersion(r.version)
+	if !ok {
+		return true
+	}
+	return major > composeOverrideVersion[0] || major == composeOverrideVersion[0] && minor >= composeOverrideVersion[1]
+}
+
+// parseComposeVersion reads the major and minor version out of e.g.
+// "1.29.2" or "v2.24.6".
+func parseComposeVersion(version string) (major, minor int, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// usesPortVariable reports whether the host side of a port mapping is
+// taken from PORT, e.g. ${PORT} or ${PORT:-3000}.
+func usesPortVariable(hostPort string) bool {
+	return hostPort == "$PORT" || strings.HasPrefix(hostPort, "${PORT}") ||
+		strings.HasPrefix(hostPort, "${PORT:") || strings.HasPrefix(hostPort, "${PORT-")
+}
+
+func (r *composeRunner) command(ctx context.Context, app App, logFile *deployLog, args ...string) *exec.Cmd {
+	cmd := newCommand(ctx, "docker-compose", append([]string{"-p", app.Name}, args...)...)
+	cmd.Dir = app.Dir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.Env = append(environ(app.Port, app.Env), fmt.Sprintf("COMPOSE_PROJECT_NAME=%s", app.Name))
+	return cmd
+}
+
+func (r *composeRunner) Build(ctx context.Context, app App, logFile *deployLog) error {
+	files, err := r.files(app, logFile, false)
+	if err != nil {
+		return err
+	}
+	return r.command(ctx, app, logFile, append(files, "build")...).Run()
+}
+
+func (r *composeRunner) Start(ctx context.Context, app App, logFile *deployLog) error {
+	files, err := r.files(app, logFile, true)
+	if err != nil {
+		return err
+	}
+	return r.command(ctx, app, logFile, append(files, "up", "-d")...).Run()
+}
+
+func (r *composeRunner) Stop(name string) error {
+	if _, err := exec.LookPath("docker-compose"); err != nil {
+		return nil // nothing can be running under compose
+	}
+	out, err := exec.Command("docker-compose", "-p", name, "down").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("docker-compose down: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (r *composeRunner) Status(name string) (RunStatus, error) {
+	out, err := exec.Command("docker-compose", "-p", name, "ps", "--services", "--filter", "status=running").Output()
+	if err != nil {
+		return RunStatus{}, fmt.Errorf("docker-compose ps: %w", err)
+	}
+	services := strings.Fields(string(out))
+	if len(services) == 0 {
+		return RunStatus{State: "stopped"}, nil
+	}
+	return RunStatus{Running: true, State: "running", Detail: strings.Join(services, ", ")}, nil
+}
+
+func (r *composeRunner) Logs(name string, lines int) (string, error) {
+	out, err := exec.Command("docker-compose", "-p", name, "logs", "--no-color", "--tail", fmt.Sprint(lines)).CombinedOutput()
+	return string(out), err
+}
@@ -0,0 +1,518 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ejfox/dockrune/internal/detector"
+	"github.com/ejfox/dockrune/internal/models"
+	"github.com/ejfox/dockrune/internal/ports"
+)
+
+// fakeRunner stands in for a runtime in tests. A started app is a TCP
+// listener on its port, so the default health check passes against it.
+type fakeRunner struct {
+	mu      sync.Mutex
+	started []App
+	stopped []string
+	pruned  []string
+	running map[string]net.Listener
+}
+
+func newFakeRunner() *fakeRunner {
+	return &fakeRunner{running: make(map[string]net.Listener)}
+}
+
+func (r *fakeRunner) Start(ctx context.Context, app App, logFile *deployLog) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", app.Port))
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started = append(r.started, app)
+	r.running[app.Name] = ln
+	return nil
+}
+
+func (r *fakeRunner) Stop(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ln, ok := r.running[name]; ok {
+		ln.Close()
+		delete(r.running, name)
+		r.stopped = append(r.stopped, name)
+	}
+	return nil
+}
+
+func (r *fakeRunner) Prune(app App, logFile *deployLog) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pruned = append(r.pruned, app.Version)
+}
+
+func (r *fakeRunner) Status(name string) (RunStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.running[name]; ok {
+		return RunStatus{Running: true, State: "running"}, nil
+	}
+	return RunStatus{State: "stopped"}, nil
+}
+
+func (r *fakeRunner) Logs(name string, lines int) (string, error) {
+	return "", nil
+}
+
+// useFakeRunners replaces every runtime of d with one fake.
+func useFakeRunners(t *testing.T, d *Deployer) *fakeRunner {
+	fake := newFakeRunner()
+	for _, runtime := range runtimes {
+		d.runners[runtime] = fake
+	}
+	t.Cleanup(func() {
+		for name := range fake.running {
+			fake.Stop(name)
+		}
+	})
+	return fake
+}
+
+// commitFile adds a commit writing content to name in repo and returns its
+// SHA.
+func commitFile(t *testing.T, repo, name, content string) string {
+	if err := os.WriteFile(filepath.Join(repo, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"add", name}, {"commit", "-q", "-m", name}} {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	out, err := exec.Command("git", "-C", repo, "rev-parse", "HEAD").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestDeployUsesRunner(t *testing.T) {
+	d := newTestDeployer(t)
+	d.detector = detector.NewManager()
+	fake := useFakeRunners(t, d)
+
+	repo, _ := newTestRepo(t, "one")
+	sha := commitFile(t, repo, ".dockrune.yml", "start: ./server --quiet\nenv:\n  GREETING: hello\n")
+
+	deployment := &models.Deployment{
+		ID: "deploy-1", Owner: "ejfox", Repo: "site", SHA: sha,
+		CloneURL: repo, Environment: "production", LogPath: filepath.Join(t.TempDir(), "deploy.log"),
+	}
+	if err := d.storage.CreateDeployment(deployment); err != nil {
+		t.Fatal(err)
+	}
+	logFile, err := createLog(deployment.LogPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+
+	if err := d.deploy(context.Background(), deployment, logFile); err != nil {
+		logged, _ := os.ReadFile(deployment.LogPath)
+		t.Fatalf("deploy() error = %v\n%s", err, logged)
+	}
+
+	if deployment.Runtime != RuntimeProcess {
+		t.Errorf("Runtime = %q, want %q", deployment.Runtime, RuntimeProcess)
+	}
+	if len(fake.started) != 1 {
+		t.Fatalf("started %d apps, want 1", len(fake.started))
+	}
+	app := fake.started[0]
+	if app.Name != "ejfox-site-production" || app.Command != "./server --quiet" || app.Port != deployment.Port || app.Version != sha {
+		t.Errorf("started %+v", app)
+	}
+	if app.Env["GREETING"] != "hello" {
+		t.Errorf("app env = %v, want GREETING from the project config", app.Env)
+	}
+	if status, _ := d.runner(deployment).Status(app.Name); !status.Running {
+		t.Error("app not running after deploy")
+	}
+}
+
+func TestDeployInstallsBeforeBuilding(t *testing.T) {
+	d := newTestDeployer(t)
+	d.detector = detector.NewManager()
+	useFakeRunners(t, d)
+
+	repo, _ := newTestRepo(t, "one")
+	sha := commitFile(t, repo, ".dockrune.yml", "install: touch installed\nbuild: test -f installed\nstart: ./server\n")
+
+	deployment := &models.Deployment{
+		ID: "deploy-1", Owner: "ejfox", Repo: "site", SHA: sha,
+		CloneURL: repo, Environment: "production", LogPath: filepath.Join(t.TempDir(), "deploy.log"),
+	}
+	if err := d.storage.CreateDeployment(deployment); err != nil {
+		t.Fatal(err)
+	}
+	logFile, err := createLog(deployment.LogPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+
+	if err := d.deploy(context.Background(), deployment, logFile); err != nil {
+		logged, _ := os.ReadFile(deployment.LogPath)
+		t.Fatalf("deploy() error = %v\n%s", err, logged)
+	}
+}
+
+func TestDeployRejectsPortPinnedByAnotherEnvironment(t *testing.T) {
+	d := newTestDeployer(t)
+	d.detector = detector.NewManager()
+	useFakeRunners(t, d)
+
+	if err := d.ports.Reserve("ejfox", "blog", "production", 9123); err != nil {
+		t.Fatal(err)
+	}
+	repo, _ := newTestRepo(t, "one")
+	sha := commitFile(t, repo, ".dockrune.yml", "start: ./server\nport: 9123\n")
+
+	deployment := &models.Deployment{
+		ID: "deploy-1", Owner: "ejfox", Repo: "site", SHA: sha,
+		CloneURL: repo, Environment: "production", LogPath: filepath.Join(t.TempDir(), "deploy.log"),
+	}
+	if err := d.storage.CreateDeployment(deployment); err != nil {
+		t.Fatal(err)
+	}
+	logFile, err := createLog(deployment.LogPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+
+	if err := d.deploy(context.Background(), deployment, logFile); !errors.Is(err, ports.ErrLeased) {
+		t.Fatalf("deploy() error = %v, want ports.ErrLeased", err)
+	}
+	logged, _ := os.ReadFile(deployment.LogPath)
+	if want := "Can't use port 9123 pinned in project config: port is leased to another environment: 9123 is leased to ejfox/blog production"; !strings.Contains(string(logged), want) {
+		t.Errorf("deploy log doesn't contain %q:\n%s", want, logged)
+	}
+}
+
+func TestDeployStaticSite(t *testing.T) {
+	if _, err := exec.LookPath("python"); err != nil {
+		t.Skip("python not installed")
+	}
+	d := newTestDeployer(t)
+	d.detector = detector.NewManager()
+
+	repo, _ := newTestRepo(t, "one")
+	sha := commitFile(t, repo, "index.html", "<h1>hello from dockrune</h1>")
+
+	deployment := &models.Deployment{
+		ID: "deploy-1", Owner: "ejfox", Repo: "site", SHA: sha,
+		CloneURL: repo, Environment: "production", LogPath: filepath.Join(t.TempDir(), "deploy.log"),
+	}
+	if err := d.storage.CreateDeployment(deployment); err != nil {
+		t.Fatal(err)
+	}
+	logFile, err := createLog(deployment.LogPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+
+	err = d.deploy(context.Background(), deployment, logFile)
+	t.Cleanup(func() { d.stopProcess(d.processName(deployment)) })
+	if err != nil {
+		logged, _ := os.ReadFile(deployment.LogPath)
+		t.Fatalf("deploy() error = %v\n%s", err, logged)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/", deployment.Port))
+	if err != nil {
+		t.Fatalf("static site not served on its leased port %d: %v", deployment.Port, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "hello from dockrune") {
+		t.Errorf("GET / = %d %q, want the index page", resp.StatusCode, body)
+	}
+}
+
+func TestFailedBuildKeepsServingDeployment(t *testing.T) {
+	d := newTestDeployer(t)
+	d.detector = detector.NewManager()
+	d.config.AutoRollback = true
+	fake := useFakeRunners(t, d)
+
+	repo, _ := newTestRepo(t, "one")
+	logsDir := t.TempDir()
+	deploy := func(id, sha string) *models.Deployment {
+		deployment := &models.Deployment{
+			ID: id, Owner: "ejfox", Repo: "site", SHA: sha,
+			CloneURL: repo, Environment: "production", LogPath: filepath.Join(logsDir, id+".log"),
+		}
+		if err := d.storage.CreateDeployment(deployment); err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+		d.processDeployment(ctx, ctx, deployment)
+		return deployment
+	}
+
+	serving := deploy("deploy-1", commitFile(t, repo, ".dockrune.yml", "start: ./server\n"))
+	if serving.Status != models.StatusSuccess {
+		logged, _ := os.ReadFile(serving.LogPath)
+		t.Fatalf("first deployment %s:\n%s", serving.Status, logged)
+	}
+
+	broken := deploy("deploy-2", commitFile(t, repo, ".dockrune.yml", "build: \"false\"\nstart: ./server\n"))
+	if broken.Status != models.StatusFailed {
+		t.Fatalf("broken deployment %s, want failed", broken.Status)
+	}
+
+	if len(fake.started) != 1 || len(fake.stopped) != 0 {
+		t.Errorf("started %d and stopped %v, want the first deployment left running", len(fake.started), fake.stopped)
+	}
+	if status, _ := d.runner(serving).Status(d.processName(serving)); !status.Running {
+		t.Error("serving deployment stopped by a failed build")
+	}
+	logged, _ := os.ReadFile(broken.LogPath)
+	if strings.Contains(string(logged), "Rolling back") {
+		t.Errorf("failed build started a rollback:\n%s", logged)
+	}
+}
+
+func TestPruneOnlyAfterSuccess(t *testing.T) {
+	d := newTestDeployer(t)
+	d.detector = detector.NewManager()
+	fake := useFakeRunners(t, d)
+
+	repo, _ := newTestRepo(t, "one")
+	logsDir := t.TempDir()
+	deploy := func(id, sha string) error {
+		deployment := &models.Deployment{
+			ID: id, Owner: "ejfox", Repo: "site", SHA: sha,
+			CloneURL: repo, Environment: "production", LogPath: filepath.Join(logsDir, id+".log"),
+		}
+		if err := d.storage.CreateDeployment(deployment); err != nil {
+			t.Fatal(err)
+		}
+		logFile, err := createLog(deployment.LogPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer logFile.Close()
+		return d.deploy(context.Background(), deployment, logFile)
+	}
+
+	good := commitFile(t, repo, ".dockrune.yml", "start: ./server\n")
+	if err := deploy("deploy-1", good); err != nil {
+		t.Fatalf("deploy() error = %v", err)
+	}
+	unhealthy := commitFile(t, repo, ".dockrune.yml", "start: ./server\nhealthcheck:\n  type: command\n  command: \"false\"\n  retries: 1\n")
+	if err := deploy("deploy-2", unhealthy); err == nil {
+		t.Fatal("deploy() of an unhealthy version succeeded")
+	}
+
+	if len(fake.pruned) != 1 || fake.pruned[0] != good {
+		t.Errorf("pruned after %v, want only after the healthy deployment", fake.pruned)
+	}
+}
+
+func TestRuntimeFor(t *testing.T) {
+	compose := &detector.Detection{Type: detector.TypeDocker, Metadata: map[string]interface{}{"compose_file": "docker-compose.yml"}}
+	dockerfile := &detector.Detection{Type: detector.TypeDocker, Metadata: map[string]interface{}{"dockerfile": "Dockerfile"}}
+	node := &detector.Detection{Type: detector.TypeNode}
+
+	tests := []struct {
+		detection     *detector.Detection
+		projectConfig *ProjectConfig
+		want          string
+	}{
+		{compose, nil, RuntimeCompose},
+		{dockerfile, nil, RuntimeDocker},
+		{node, nil, RuntimeProcess},
+		{node, &ProjectConfig{}, RuntimeProcess},
+		{dockerfile, &ProjectConfig{Runtime: RuntimeProcess}, RuntimeProcess},
+	}
+	for _, tt := range tests {
+		if got := runtimeFor(tt.detection, tt.projectConfig); got != tt.want {
+			t.Errorf("runtimeFor(%s, %+v) = %q, want %q", tt.detection.Type, tt.projectConfig, got, tt.want)
+		}
+	}
+}
+
+func TestRunnerOfOlderDeployments(t *testing.T) {
+	d := newTestDeployer(t)
+
+	if _, ok := d.runner(&models.Deployment{ProjectType: "docker"}).(*composeRunner); !ok {
+		t.Error("docker deployment without a runtime should use compose")
+	}
+	if _, ok := d.runner(&models.Deployment{ProjectType: "node"}).(*processRunner); !ok {
+		t.Error("node deployment without a runtime should use the process runner")
+	}
+}
+
+func TestComposePublishesWebService(t *testing.T) {
+	tests := []struct {
+		name     string
+		compose  string
+		version  string // of docker-compose
+		override string // "" for no override file
+		log      string
+		err      string
+	}{
+		{
+			name:     "pinned port is replaced",
+			compose:  "services:\n  web:\n    build: .\n    ports: [\"8080:3000\"]\n  db:\n    image: postgres\n",
+			version:  "v2.24.6",
+			override: "services:\n  web:\n    ports: !override\n      - \"3005:3000\"\n",
+			log:      "Publishing service web port 3000 on port 3005",
+		},
+		{
+			name:    "port taken from PORT",
+			compose: "services:\n  web:\n    build: .\n    ports: [\"${PORT:-8080}:3000\"]\n",
+			log:     "Service web publishes port 3000 on ${PORT:-8080}",
+		},
+		{
+			name:     "exposed port is published",
+			compose:  "services:\n  api:\n    build: .\n    expose: [4000]\n",
+			version:  "1.29.2",
+			override: "services:\n  api:\n    ports:\n      - \"3005:4000\"\n",
+			log:      "Publishing service api port 4000 on port 3005",
+		},
+		{
+			name:    "pinned port with compose v1",
+			compose: "services:\n  web:\n    build: .\n    ports: [\"8080:3000\"]\n",
+			version: "1.29.2",
+			err:     "needs docker compose 2.24 or later (found 1.29.2)",
+		},
+		{
+			name:    "no port to publish",
+			compose: "services:\n  worker:\n    build: .\n",
+			log:     "Warning: no service in docker-compose.yml publishes or exposes a port",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "docker-compose.yml"), []byte(tt.compose), 0644); err != nil {
+				t.Fatal(err)
+			}
+			logPath := filepath.Join(dir, "deploy.log")
+			logFile, err := createLog(logPath, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer logFile.Close()
+
+			r := &composeRunner{}
+			r.once.Do(func() { r.version = tt.version })
+			files, err := r.files(App{Name: "ejfox-site-production", Dir: dir, Port: 3005}, logFile, true)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("files() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("files() error = %v", err)
+			}
+
+			want := []string{"-f", "docker-compose.yml"}
+			if tt.override != "" {
+				want = append(want, "-f", composeOverride)
+			}
+			if strings.Join(files, " ") != strings.Join(want, " ") {
+				t.Errorf("files() = %v, want %v", files, want)
+			}
+			written, _ := os.ReadFile(filepath.Join(dir, composeOverride))
+			if tt.override != "" && !strings.HasSuffix(string(written), tt.override) {
+				t.Errorf("override file:\n%s\nwant it to end in:\n%s", written, tt.override)
+			}
+			logged, _ := os.ReadFile(logPath)
+			if !strings.Contains(string(logged), tt.log) {
+				t.Errorf("log = %q, want %q", logged, tt.log)
+			}
+		})
+	}
+}
+
+func TestDockerRunnerArgs(t *testing.T) {
+	dir := t.TempDir()
+	dockerfile := "FROM node:20 AS build\nEXPOSE 9229\nFROM nginx:alpine AS web\nEXPOSE 80\nFROM golang:1.22 AS api\nEXPOSE 8080\n"
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &dockerRunner{}
+	app := App{
+		Name: "ejfox-Site-production", Dir: dir, Port: 3005, Target: "web",
+		Version: "ABCDEF1234567890", Env: map[string]string{"TOKEN": "x", "API_URL": "y"},
+	}
+
+	stage, err := r.stage(app)
+	if err != nil {
+		t.Fatalf("stage() error = %v", err)
+	}
+	if port := r.containerPort(app, stage); port != 80 {
+		t.Errorf("containerPort() = %d, want the web stage's exposed port 80", port)
+	}
+	if got, want := strings.Join(r.buildArgs(app), " "), "build -t dockrune/ejfox-site-production:abcdef123456 --target web ."; got != want {
+		t.Errorf("buildArgs() = %q, want %q", got, want)
+	}
+	want := "run -d --name ejfox-Site-production --restart unless-stopped -p 3005:80 -e API_URL -e TOKEN -e PORT dockrune/ejfox-site-production:abcdef123456"
+	if got := strings.Join(r.runArgs(app, 80), " "); got != want {
+		t.Errorf("runArgs() = %q, want %q", got, want)
+	}
+
+	// Without EXPOSE the app listens on its own port
+	if port := r.containerPort(App{Port: 3005}, detector.DockerStage{}); port != 3005 {
+		t.Errorf("containerPort() without EXPOSE = %d, want 3005", port)
+	}
+
+	app.Target = "release"
+	if _, err := r.stage(app); err == nil || !strings.Contains(err.Error(), "stages: build, web, api") {
+		t.Errorf("stage() with an unknown target error = %v", err)
+	}
+}
+
+func TestDockerHealthCheck(t *testing.T) {
+	check := dockerHealthCheck("ejfox-site-production", &detector.Healthcheck{Command: "true", Interval: 5 * time.Second, StartPeriod: 10 * time.Second})
+	if !strings.Contains(check.Command, "docker inspect") || !strings.Contains(check.Command, " ejfox-site-production)") {
+		t.Errorf("command = %q", check.Command)
+	}
+	// 10s start period plus 4 intervals of 5s, looked up every 2s
+	if check.Retries != 16 || check.Interval != dockerHealthInterval {
+		t.Errorf("retries = %d every %s, want 16 every %s", check.Retries, check.Interval, dockerHealthInterval)
+	}
+
+	repo := t.TempDir()
+	if err := os.WriteFile(filepath.Join(repo, "Dockerfile"), []byte("FROM nginx AS web\nHEALTHCHECK CMD curl -f localhost\nFROM web AS quiet\nHEALTHCHECK NONE\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if hc := dockerfileHealthcheck(repo, nil); hc != nil {
+		t.Errorf("HEALTHCHECK NONE gave %+v", hc)
+	}
+	if hc := dockerfileHealthcheck(repo, &ProjectConfig{Target: "web"}); hc == nil || hc.Command != "curl -f localhost" {
+		t.Errorf("web stage healthcheck = %+v", hc)
+	}
+}
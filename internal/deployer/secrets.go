@@ -0,0 +1,109 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/ejfox/dockrune/internal/models"
+)
+
+// ValidateSecret checks a secret's name and, for file secrets, its path.
+func ValidateSecret(name, path string) error {
+	if path == "" {
+		return ValidateEnvKey(name)
+	}
+	if !envKeyPattern.MatchString(name) {
+		return fmt.Errorf("%q is not a valid secret name", name)
+	}
+	if !filepath.IsLocal(path) {
+		return fmt.Errorf("secret path %q must be relative and stay inside the repository", path)
+	}
+	return nil
+}
+
+// loadSecrets returns the decrypted secrets of a deployment. One stored
+// for its environment replaces a repo-wide one with the same name.
+func (d *Deployer) loadSecrets(deployment *models.Deployment) ([]*models.Secret, error) {
+	byName := make(map[string]int)
+	var secrets []*models.Secret
+	for _, environment := range []string{"", deployment.Environment} {
+		stored, err := d.storage.GetSecrets(deployment.Owner, deployment.Repo, environment)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load secrets: %w", err)
+		}
+		for _, secret := range stored {
+			if i, ok := byName[secret.Name]; ok {
+				secrets[i] = secret
+				continue
+			}
+			byName[secret.Name] = len(secrets)
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets, nil
+}
+
+// writeSecretFiles writes the secrets that have a path into the working
+// tree. The repo decides what its directories are, so the paths are
+// resolved and must still lead inside repoPath: a symlinked config
+// directory can't send a decrypted secret elsewhere on the host.
+func writeSecretFiles(repoPath string, secrets []*models.Secret, logFile *deployLog) error {
+	root, err := filepath.EvalSymlinks(repoPath)
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets {
+		if secret.Path == "" {
+			continue
+		}
+		if !filepath.IsLocal(secret.Path) {
+			return fmt.Errorf("secret %s has invalid path %q", secret.Name, secret.Path)
+		}
+
+		dir, err := secretDir(root, filepath.Dir(secret.Path))
+		if err != nil {
+			return fmt.Errorf("failed to write secret %s: %w", secret.Name, err)
+		}
+		// O_NOFOLLOW so a symlink in place of the file isn't written through
+		path := filepath.Join(dir, filepath.Base(secret.Path))
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to write secret %s: %w", secret.Name, err)
+		}
+		_, err = file.WriteString(secret.Value)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write secret %s: %w", secret.Name, err)
+		}
+		fmt.Fprintf(logFile, "Wrote secret %s to %s\n", secret.Name, secret.Path)
+	}
+	return nil
+}
+
+// secretDir resolves dir, relative to root, creating what's missing of it.
+// The deepest part that exists is resolved first, so MkdirAll never
+// follows a link out of root.
+func secretDir(root, dir string) (string, error) {
+	existing, missing := dir, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(filepath.Join(root, existing))
+		if err == nil {
+			if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) {
+				return "", fmt.Errorf("%s leads outside the repository", existing)
+			}
+			dir := filepath.Join(resolved, missing)
+			return dir, os.MkdirAll(dir, 0755)
+		}
+		if !errors.Is(err, fs.ErrNotExist) || existing == "." {
+			return "", err
+		}
+		missing = filepath.Join(filepath.Base(existing), missing)
+		existing = filepath.Dir(existing)
+	}
+}
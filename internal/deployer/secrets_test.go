@@ -0,0 +1,121 @@
+package deployer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ejfox/dockrune/internal/models"
+	"github.com/ejfox/dockrune/internal/storage"
+)
+
+func TestSecretsInjected(t *testing.T) {
+	d := newTestDeployer(t)
+	if err := d.storage.(*storage.SQLiteStorage).SetSecretsKey(bytes.Repeat([]byte{7}, storage.SecretsKeySize)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []*models.Secret{
+		{Owner: "ejfox", Repo: "site", Name: "DATABASE_URL", Value: "postgres://shared"},
+		{Owner: "ejfox", Repo: "site", Environment: "production", Name: "DATABASE_URL", Value: "postgres://prod"},
+		{Owner: "ejfox", Repo: "site", Environment: "production", Name: "GCP_KEY", Path: "config/gcp.json", Value: "{}"},
+	} {
+		if err := d.storage.SetSecret(secret); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.storage.SetEnvVar(&models.EnvVar{Owner: "ejfox", Repo: "site", Key: "DATABASE_URL", Value: "sqlite://"})
+
+	deployment := &models.Deployment{Owner: "ejfox", Repo: "site", Environment: "production"}
+	secrets, err := d.loadSecrets(deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := d.loadEnv(deployment, nil, secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env["DATABASE_URL"] != "postgres://prod" {
+		t.Errorf("DATABASE_URL = %q, want the production secret", env["DATABASE_URL"])
+	}
+	if _, ok := env["GCP_KEY"]; ok {
+		t.Error("file secrets should not be set in the environment")
+	}
+
+	repoPath := t.TempDir()
+	logFile, err := createLog(filepath.Join(t.TempDir(), "deploy.log"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+	if err := writeSecretFiles(repoPath, secrets, logFile); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(repoPath, "config", "gcp.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("secret file mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestValidateSecret(t *testing.T) {
+	for _, tc := range []struct {
+		name, path string
+		ok         bool
+	}{
+		{"DATABASE_URL", "", true},
+		{"PORT", "", false},
+		{"gcp_key", "config/gcp.json", true},
+		{"gcp_key", "../gcp.json", false},
+		{"gcp_key", "/etc/passwd", false},
+	} {
+		if err := ValidateSecret(tc.name, tc.path); (err == nil) != tc.ok {
+			t.Errorf("ValidateSecret(%q, %q) = %v", tc.name, tc.path, err)
+		}
+	}
+}
+
+func TestSecretFilesStayInRepo(t *testing.T) {
+	repoPath, outside := t.TempDir(), t.TempDir()
+	for link, target := range map[string]string{
+		"config":   outside,
+		"gone":     filepath.Join(outside, "gone"),
+		"key.json": filepath.Join(outside, "key.json"),
+		"shared":   "deploy",
+	} {
+		if err := os.Symlink(target, filepath.Join(repoPath, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Mkdir(filepath.Join(repoPath, "deploy"), 0755)
+
+	logFile, err := createLog(filepath.Join(t.TempDir(), "deploy.log"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+
+	for _, path := range []string{"config/gcp.json", "config/new/gcp.json", "gone/gcp.json", "key.json"} {
+		secrets := []*models.Secret{{Name: "GCP_KEY", Path: path, Value: "{}"}}
+		if err := writeSecretFiles(repoPath, secrets, logFile); err == nil {
+			t.Errorf("writeSecretFiles(%q) succeeded", path)
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("secrets written outside the repo: %v", entries)
+	}
+
+	// Links that stay inside the repo are fine
+	secrets := []*models.Secret{{Name: "GCP_KEY", Path: "shared/gcp.json", Value: "{}"}}
+	if err := writeSecretFiles(repoPath, secrets, logFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(repoPath, "deploy", "gcp.json")); err != nil {
+		t.Error(err)
+	}
+}
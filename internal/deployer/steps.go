@@ -0,0 +1,70 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os/exec"
+	"time"
+
+	"github.com/ejfox/dockrune/internal/models"
+	"github.com/ejfox/dockrune/internal/storage"
+)
+
+// stepRecorder runs the phases of one deployment and stores each as a
+// models.DeploymentStep, with timing, exit code and where its output is
+// in the deployment log.
+type stepRecorder struct {
+	storage    storage.Storage
+	deployment *models.Deployment
+	logFile    *deployLog
+}
+
+func (d *Deployer) newStepRecorder(deployment *models.Deployment, logFile *deployLog) *stepRecorder {
+	return &stepRecorder{storage: d.storage, deployment: deployment, logFile: logFile}
+}
+
+// run runs fn as the named step through runPhase, so limit and the
+// overall deadline apply.
+func (r *stepRecorder) run(ctx context.Context, name string, limit time.Duration, fn func(ctx context.Context) error) error {
+	step := &models.DeploymentStep{
+		DeploymentID: r.deployment.ID,
+		Name:         name,
+		Status:       models.StatusInProgress,
+		StartedAt:    time.Now(),
+		LogOffset:    r.logFile.Offset(),
+	}
+	if err := r.storage.CreateDeploymentStep(step); err != nil {
+		log.Printf("Failed to record %s step of %s: %v", name, r.deployment.ID, err)
+	}
+
+	err := runPhase(ctx, name, limit, r.logFile, fn)
+
+	step.CompletedAt = time.Now()
+	step.LogLength = r.logFile.Offset() - step.LogOffset
+	step.Status = models.StatusSuccess
+	if err != nil {
+		step.Status = models.StatusFailed
+		step.Error = err.Error()
+	}
+	step.ExitCode = exitCode(err)
+	if step.ID > 0 {
+		r.storage.UpdateDeploymentStep(step)
+	}
+	return err
+}
+
+// exitCode is 0 for a step that succeeded, the exit status of the command
+// that failed it, or nil if it failed some other way.
+func exitCode(err error) *int {
+	code := 0
+	if err == nil {
+		return &code
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() < 0 {
+		return nil
+	}
+	code = exitErr.ExitCode()
+	return &code
+}
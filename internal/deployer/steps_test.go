@@ -0,0 +1,142 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ejfox/dockrune/internal/detector"
+	"github.com/ejfox/dockrune/internal/models"
+	"github.com/ejfox/dockrune/internal/routing"
+)
+
+func TestStepRecorder(t *testing.T) {
+	d := newTestDeployer(t)
+
+	logPath := filepath.Join(t.TempDir(), "deploy.log")
+	logFile, err := createLog(logPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+
+	deployment := &models.Deployment{ID: "deploy-1"}
+	steps := d.newStepRecorder(deployment, logFile)
+
+	ctx := context.Background()
+	steps.run(ctx, PhaseDetect, 0, func(ctx context.Context) error {
+		fmt.Fprintf(logFile, "Detected node\n")
+		return nil
+	})
+	steps.run(ctx, PhaseBuild, 0, func(ctx context.Context) error {
+		cmd := newCommand(ctx, "sh", "-c", "echo building; exit 3")
+		cmd.Stdout = logFile
+		return cmd.Run()
+	})
+
+	stored, err := d.storage.ListDeploymentSteps("deploy-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 {
+		t.Fatalf("got %d steps, want 2", len(stored))
+	}
+
+	detect, build := stored[0], stored[1]
+	if detect.Status != models.StatusSuccess || detect.ExitCode == nil || *detect.ExitCode != 0 {
+		t.Errorf("detect step = %+v, want success with exit code 0", detect)
+	}
+	if build.Status != models.StatusFailed || build.ExitCode == nil || *build.ExitCode != 3 {
+		t.Errorf("build step = %+v, want failed with exit code 3", build)
+	}
+
+	logged, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for step, want := range map[*models.DeploymentStep]string{detect: "Detected node\n", build: "building\n"} {
+		got := string(logged[step.LogOffset : step.LogOffset+step.LogLength])
+		if got != want {
+			t.Errorf("%s output = %q, want %q", step.Name, got, want)
+		}
+	}
+}
+
+// brokenRouter is a route table that takes no routes.
+type brokenRouter struct{}
+
+func (brokenRouter) Set(route routing.Route) error                           { return errors.New("config is read-only") }
+func (brokenRouter) RemoveEnvironment(owner, repo, environment string) error { return nil }
+func (brokenRouter) Load(routes []routing.Route) error                       { return nil }
+
+func TestDeployRecordsRouteStep(t *testing.T) {
+	tests := []struct {
+		name       string
+		router     func(t *testing.T, d *Deployer)
+		wantStatus models.DeploymentStatus
+	}{
+		{
+			name:       "routed with a certificate",
+			router:     func(t *testing.T, d *Deployer) { useNginxTLS(t, d) },
+			wantStatus: models.StatusSuccess,
+		},
+		{
+			name:       "proxy refuses the route",
+			router:     func(t *testing.T, d *Deployer) { d.router = brokenRouter{} },
+			wantStatus: models.StatusFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDeployer(t)
+			d.detector = detector.NewManager()
+			d.config.DeploymentDomain = "example.com"
+			useFakeRunners(t, d)
+			tt.router(t, d)
+
+			repo, _ := newTestRepo(t, "one")
+			sha := commitFile(t, repo, ".dockrune.yml", "start: ./server\n")
+
+			deployment := &models.Deployment{
+				ID: "preview-1", Owner: "ejfox", Repo: "site", SHA: sha,
+				CloneURL: repo, Environment: "preview-pr-7", LogPath: filepath.Join(t.TempDir(), "deploy.log"),
+			}
+			if err := d.storage.CreateDeployment(deployment); err != nil {
+				t.Fatal(err)
+			}
+			logFile, err := createLog(deployment.LogPath, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer logFile.Close()
+
+			err = d.deploy(context.Background(), deployment, logFile)
+			var phaseErr *phaseError
+			if tt.wantStatus == models.StatusFailed && (!errors.As(err, &phaseErr) || phaseErr.Phase != PhaseRoute) {
+				t.Errorf("deploy() error = %v, want a route phase error", err)
+			}
+			if tt.wantStatus == models.StatusSuccess && err != nil {
+				t.Errorf("deploy() error = %v", err)
+			}
+
+			stored, err := d.storage.ListDeploymentSteps(deployment.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			last := stored[len(stored)-1]
+			if last.Name != PhaseRoute || last.Status != tt.wantStatus || last.CompletedAt.Before(last.StartedAt) {
+				t.Errorf("last step = %+v, want a %s route step", last, tt.wantStatus)
+			}
+			logged, _ := os.ReadFile(deployment.LogPath)
+			output := string(logged[last.LogOffset : last.LogOffset+last.LogLength])
+			if tt.wantStatus == models.StatusSuccess && !strings.Contains(output, "Routing preview-pr-7.example.com to port") {
+				t.Errorf("route step output = %q", output)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package deployer
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ejfox/dockrune/internal/models"
+)
+
+// errTornDown is the context cause of deployments cancelled because their
+// environment is being torn down. They are not rolled back.
+var errTornDown = fmt.Errorf("%w: environment torn down", ErrCancelled)
+
+// TeardownEnvironment removes owner/repo/environment for good: queued and
+// running deployments of it are cancelled, its app is stopped, its ports,
+// routes and worktrees are released and its deployments are marked
+// inactive, on GitHub too. A pull request's preview comment is edited to
+// say the preview is gone.
+func (d *Deployer) TeardownEnvironment(owner, repo, environment string) error {
+	key := fmt.Sprintf("%s/%s/%s", owner, repo, environment)
+
+	for _, deployment := range d.dequeueEnvironment(key) {
+		d.handleDeploymentCancelled(deployment, "cancelled, environment torn down")
+	}
+	d.mu.Lock()
+	for id, deployment := range d.active {
+		if cancel, ok := d.cancels[id]; ok && environmentKey(deployment) == key {
+			cancel(errTornDown)
+		}
+	}
+	d.mu.Unlock()
+
+	// Wait for those to wind down and keep new ones out until we're done
+	d.claim(key)
+	defer d.unclaim(key)
+
+	serving, err := d.stopEnvironment(owner, repo, environment, "Environment torn down")
+	if err != nil {
+		return err
+	}
+	if err := d.storage.DeletePreview(owner, repo, environment); err != nil {
+		log.Printf("Failed to forget preview %s: %v", key, err)
+	}
+	if serving != nil && serving.PRNumber > 0 && d.github != nil {
+		d.removePRComment(owner, repo, serving.PRNumber, "🧹 Preview deployment removed")
+	}
+
+	log.Printf("Tore down %s/%s %s", owner, repo, environment)
+	return nil
+}
+
+// stopEnvironment stops an environment's app and cleans up after it,
+// releases its ports, routes and worktrees and marks its deployments
+// inactive, on GitHub with the given description. It returns the
+// deployment that was serving it, if any. The caller must have claimed
+// the environment.
+func (d *Deployer) stopEnvironment(owner, repo, environment, description string) (*models.Deployment, error) {
+	serving, _ := d.storage.GetLastSuccessfulDeployment(owner, repo, environment)
+
+	d.stopExistingDeployment(owner, repo, environment)
+	base := d.sanitizeProcessName(owner, repo, environment)
+	d.cleanProcess(base)
+	for _, slot := range []string{SlotBlue, SlotGreen} {
+		d.stopProcess(fmt.Sprintf("%s-%s", base, slot))
+		d.cleanProcess(fmt.Sprintf("%s-%s", base, slot))
+		if err := d.ports.Release(owner, repo, slotEnvironment(environment, slot)); err != nil {
+			return nil, err
+		}
+	}
+
+	if port, ok := d.ports.Lookup(owner, repo, environment); ok {
+		d.forwarder.Remove(port)
+	}
+	d.unroute(owner, repo, environment)
+	if err := d.ports.Release(owner, repo, environment); err != nil {
+		return nil, err
+	}
+
+	// Nothing serves the environment any more, so its worktrees go too
+	if err := d.storage.DeactivateDeployments(owner, repo, environment); err != nil {
+		return nil, fmt.Errorf("failed to mark deployments inactive: %w", err)
+	}
+	d.pruneWorktrees(owner, repo)
+
+	if serving != nil && serving.GitHubDeploymentID > 0 && d.github != nil {
+		d.github.UpdateDeploymentStatus(owner, repo, serving.GitHubDeploymentID, "inactive", "", description)
+	}
+	return serving, nil
+}
+
+// commentOnPR keeps a single preview comment per pull request: the first
+// deployment creates it, later ones edit it.
+func (d *Deployer) commentOnPR(owner, repo string, prNumber int, body string) {
+	id, err := d.storage.GetPRComment(owner, repo, prNumber)
+	if err == nil && id > 0 {
+		if err := d.github.EditPRComment(owner, repo, id, body); err == nil {
+			return
+		}
+		// Deleted on GitHub, most likely; post a new one
+	}
+
+	id, err = d.github.AddPRComment(owner, repo, prNumber, body)
+	if err != nil {
+		log.Printf("Failed to comment on %s/%s#%d: %v", owner, repo, prNumber, err)
+		return
+	}
+	d.storage.SetPRComment(owner, repo, prNumber, id)
+}
+
+// removePRComment replaces a pull request's preview comment with body, to
+// say the preview is gone, and forgets it so the next deployment posts a
+// new one.
+func (d *Deployer) removePRComment(owner, repo string, prNumber int, body string) {
+	id, err := d.storage.GetPRComment(owner, repo, prNumber)
+	if err != nil || id == 0 {
+		return
+	}
+	if err := d.github.EditPRComment(owner, repo, id, body); err != nil {
+		log.Printf("Failed to update comment on %s/%s#%d: %v", owner, repo, prNumber, err)
+	}
+	d.storage.DeletePRComment(owner, repo, prNumber)
+}
@@ -0,0 +1,73 @@
+package deployer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ejfox/dockrune/internal/detector"
+	"github.com/ejfox/dockrune/internal/models"
+)
+
+func TestTeardownEnvironment(t *testing.T) {
+	d := newTestDeployer(t)
+	d.detector = detector.NewManager()
+	fake := useFakeRunners(t, d)
+
+	repo, _ := newTestRepo(t, "one")
+	sha := commitFile(t, repo, ".dockrune.yml", "start: ./server\n")
+
+	deployment := &models.Deployment{
+		ID: "preview-1", Owner: "ejfox", Repo: "site", SHA: sha, PRNumber: 7,
+		CloneURL: repo, Environment: "preview-pr-7", LogPath: filepath.Join(t.TempDir(), "deploy.log"),
+	}
+	if err := d.storage.CreateDeployment(deployment); err != nil {
+		t.Fatal(err)
+	}
+	logFile, err := createLog(deployment.LogPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+	if err := d.deploy(context.Background(), deployment, logFile); err != nil {
+		t.Fatalf("deploy() error = %v", err)
+	}
+	deployment.Status = models.StatusSuccess
+	d.storage.UpdateDeployment(deployment)
+
+	// A later push to the PR is still waiting when it closes
+	queued := &models.Deployment{Owner: "ejfox", Repo: "site", SHA: sha, Environment: "preview-pr-7"}
+	if err := d.QueueDeployment(queued); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.TeardownEnvironment("ejfox", "site", "preview-pr-7"); err != nil {
+		t.Fatalf("TeardownEnvironment() error = %v", err)
+	}
+
+	if len(fake.running) != 0 {
+		t.Errorf("apps still running after teardown: %v", fake.running)
+	}
+	if _, ok := d.ports.Lookup("ejfox", "site", "preview-pr-7"); ok {
+		t.Error("port lease not released")
+	}
+	if _, err := os.Stat(d.worktreePath(deployment)); !os.IsNotExist(err) {
+		t.Errorf("worktree still exists (err = %v)", err)
+	}
+
+	stored, err := d.storage.GetDeployment(deployment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != models.StatusInactive {
+		t.Errorf("torn down deployment status = %s, want %s", stored.Status, models.StatusInactive)
+	}
+	stored, err = d.storage.GetDeployment(queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != models.StatusCancelled {
+		t.Errorf("queued deployment status = %s, want %s", stored.Status, models.StatusCancelled)
+	}
+}
@@ -0,0 +1,133 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Timeouts limits how long a deployment may spend in each phase and in
+// total. Zero means no limit.
+type Timeouts struct {
+	Clone       time.Duration `yaml:"clone"`
+	Build       time.Duration `yaml:"build"`
+	Start       time.Duration `yaml:"start"`
+	HealthCheck time.Duration `yaml:"healthcheck"`
+	Deploy      time.Duration `yaml:"deploy"`
+}
+
+// merge returns t with every limit set in override replacing its own.
+func (t Timeouts) merge(override *Timeouts) Timeouts {
+	if override == nil {
+		return t
+	}
+	if override.Clone > 0 {
+		t.Clone = override.Clone
+	}
+	if override.Build > 0 {
+		t.Build = override.Build
+	}
+	if override.Start > 0 {
+		t.Start = override.Start
+	}
+	if override.HealthCheck > 0 {
+		t.HealthCheck = override.HealthCheck
+	}
+	if override.Deploy > 0 {
+		t.Deploy = override.Deploy
+	}
+	return t
+}
+
+// timeouts returns the server-wide limits from the config.
+func (d *Deployer) timeouts() Timeouts {
+	return Timeouts{
+		Clone:       d.config.CloneTimeout,
+		Build:       d.config.BuildTimeout,
+		Start:       d.config.StartTimeout,
+		HealthCheck: d.config.HealthCheckTimeout,
+		Deploy:      d.config.DeployTimeout,
+	}
+}
+
+// timeoutError is the error of a deployment that ran out of time, either
+// in one phase or against its overall deadline.
+type timeoutError struct {
+	Phase    string
+	Limit    time.Duration
+	Deadline bool // the overall deadline, not the phase's own limit
+}
+
+func (e *timeoutError) Error() string {
+	if e.Deadline {
+		return fmt.Sprintf("deployment deadline of %s exceeded during %s", e.Limit, e.Phase)
+	}
+	return fmt.Sprintf("%s timed out after %s", e.Phase, e.Limit)
+}
+
+// deadline bounds a whole deployment. Unlike a context deadline it can be
+// moved once the project config is known, since that may set its own limit.
+type deadline struct {
+	started time.Time
+	cancel  context.CancelCauseFunc
+
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// withDeadline returns a context that is cancelled once limit has passed.
+// A zero limit never expires. The deadline must be stopped when done.
+func withDeadline(ctx context.Context, limit time.Duration) (context.Context, *deadline) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	dl := &deadline{started: time.Now(), cancel: cancel}
+	dl.reset(limit)
+	return ctx, dl
+}
+
+// reset replaces the limit, still counting from when the deadline started.
+func (dl *deadline) reset(limit time.Duration) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	if dl.timer != nil {
+		dl.timer.Stop()
+		dl.timer = nil
+	}
+	if limit <= 0 {
+		return
+	}
+	dl.timer = time.AfterFunc(limit-time.Since(dl.started), func() {
+		dl.cancel(&timeoutError{Limit: limit, Deadline: true})
+	})
+}
+
+func (dl *deadline) stop() {
+	dl.reset(0)
+	dl.cancel(nil)
+}
+
+// runPhase runs fn with ctx limited to limit. If the phase or the overall
+// deadline runs out, fn's processes are killed through ctx and the error is
+// a *timeoutError naming the phase, which is also written to logFile.
+func runPhase(ctx context.Context, phase string, limit time.Duration, logFile *deployLog, fn func(ctx context.Context) error) error {
+	if limit > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, limit, &timeoutError{Phase: phase, Limit: limit})
+		defer cancel()
+	}
+
+	err := fn(ctx)
+	if err == nil {
+		return nil
+	}
+
+	var timeout *timeoutError
+	if !errors.As(context.Cause(ctx), &timeout) {
+		return err
+	}
+	timeout = &timeoutError{Phase: phase, Limit: timeout.Limit, Deadline: timeout.Deadline}
+	fmt.Fprintf(logFile, "%s, killed\n", timeout)
+	return timeout
+}
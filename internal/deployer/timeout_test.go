@@ -0,0 +1,60 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunPhaseTimeout(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "deploy.log")
+	logFile, err := createLog(logPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+
+	started := time.Now()
+	err = runPhase(context.Background(), PhaseBuild, 200*time.Millisecond, logFile, func(ctx context.Context) error {
+		return newCommand(ctx, "sh", "-c", "sleep 30 & sleep 30").Run()
+	})
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Fatalf("runPhase took %s, want the build killed after its timeout", elapsed)
+	}
+
+	var timeout *timeoutError
+	if !errors.As(err, &timeout) || timeout.Phase != PhaseBuild || timeout.Deadline {
+		t.Fatalf("error = %v, want a build timeout", err)
+	}
+
+	logged, _ := os.ReadFile(logPath)
+	if !strings.Contains(string(logged), "build timed out after 200ms") {
+		t.Errorf("log = %q, want the timeout recorded", logged)
+	}
+}
+
+func TestDeadlineNamesRunningPhase(t *testing.T) {
+	logFile, err := createLog(filepath.Join(t.TempDir(), "deploy.log"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+
+	ctx, deadline := withDeadline(context.Background(), time.Hour)
+	defer deadline.stop()
+	deadline.reset(100 * time.Millisecond)
+
+	err = runPhase(ctx, PhaseStart, time.Minute, logFile, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	want := "deployment deadline of 100ms exceeded during start"
+	if err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,172 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ejfox/dockrune/internal/models"
+)
+
+// Each repository is kept as a bare mirror, and every deployment builds and
+// runs from its own worktree of that mirror. Nothing is checked out in
+// place, so concurrent deployments of one repo can't touch each other's
+// files or the files under a running app.
+
+// mirrorPath is the bare mirror shared by every deployment of a repo.
+func (d *Deployer) mirrorPath(owner, repo string) string {
+	return filepath.Join(d.config.ReposDir, owner, repo+".git")
+}
+
+// worktreesDir holds the worktrees of a repo, one directory per deployment ID.
+func (d *Deployer) worktreesDir(owner, repo string) string {
+	return filepath.Join(d.config.ReposDir, owner, repo+".worktrees")
+}
+
+// worktreePath is where deployment is built and run from.
+func (d *Deployer) worktreePath(deployment *models.Deployment) string {
+	return filepath.Join(d.worktreesDir(deployment.Owner, deployment.Repo), deployment.ID)
+}
+
+// repoLock serializes git operations on a repo's mirror.
+func (d *Deployer) repoLock(owner, repo string) *sync.Mutex {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := owner + "/" + repo
+	lock, ok := d.repoLocks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		d.repoLocks[key] = lock
+	}
+	return lock
+}
+
+// updateMirror clones the repo's mirror, or fetches into it if it exists.
+func (d *Deployer) updateMirror(ctx context.Context, deployment *models.Deployment, logFile *deployLog) error {
+	lock := d.repoLock(deployment.Owner, deployment.Repo)
+	lock.Lock()
+	defer lock.Unlock()
+
+	mirror := d.mirrorPath(deployment.Owner, deployment.Repo)
+	if _, err := os.Stat(filepath.Join(mirror, "HEAD")); err == nil {
+		fmt.Fprintf(logFile, "Updating repository mirror...\n")
+		cmd := newCommand(ctx, "git", "--git-dir", mirror, "fetch", "--prune", "origin")
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
+		return cmd.Run()
+	}
+
+	fmt.Fprintf(logFile, "Cloning repository mirror...\n")
+	os.MkdirAll(filepath.Dir(mirror), 0755)
+
+	// Use GitHub token if available
+	cloneURL := deployment.CloneURL
+	if d.config.GitHubToken != "" {
+		cloneURL = fmt.Sprintf("https://%s@%s", d.config.GitHubToken,
+			strings.TrimPrefix(deployment.CloneURL, "https://"))
+	}
+
+	cmd := newCommand(ctx, "git", "clone", "--mirror", cloneURL, mirror)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	return cmd.Run()
+}
+
+// addWorktree checks deployment's SHA out into a fresh worktree and returns
+// its path.
+func (d *Deployer) addWorktree(ctx context.Context, deployment *models.Deployment, logFile *deployLog) (string, error) {
+	lock := d.repoLock(deployment.Owner, deployment.Repo)
+	lock.Lock()
+	defer lock.Unlock()
+
+	path := d.worktreePath(deployment)
+	mirror := d.mirrorPath(deployment.Owner, deployment.Repo)
+
+	// Left over from an earlier attempt with the same ID
+	if _, err := os.Stat(path); err == nil {
+		os.RemoveAll(path)
+		newCommand(ctx, "git", "--git-dir", mirror, "worktree", "prune").Run()
+	}
+
+	fmt.Fprintf(logFile, "Checking out %s into its own worktree...\n", deployment.SHA)
+	os.MkdirAll(filepath.Dir(path), 0755)
+	cmd := newCommand(ctx, "git", "--git-dir", mirror, "worktree", "add", "--detach", path, deployment.SHA)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// pruneWorktrees removes the worktrees of owner/repo that no longer back a
+// running deployment: the one serving each environment and any still in
+// progress are kept.
+func (d *Deployer) pruneWorktrees(owner, repo string) {
+	lock := d.repoLock(owner, repo)
+	lock.Lock()
+	defer lock.Unlock()
+
+	deployments, err := d.storage.GetActiveDeployments()
+	if err != nil {
+		log.Printf("Failed to list deployments to prune worktrees of %s/%s: %v", owner, repo, err)
+		return
+	}
+
+	// Deployments come in the order they completed, latest first, as in
+	// GetLastSuccessfulDeployment, so the first success per environment
+	// is the one serving it
+	keep := make(map[string]bool)
+	serving := make(map[string]bool)
+	for _, deployment := range deployments {
+		if deployment.Owner != owner || deployment.Repo != repo || deployment.Status != models.StatusSuccess {
+			continue
+		}
+		if serving[deployment.Environment] {
+			continue
+		}
+		serving[deployment.Environment] = true
+		keep[deployment.ID] = true
+	}
+
+	d.mu.Lock()
+	for id, deployment := range d.active {
+		if deployment.Owner == owner && deployment.Repo == repo {
+			keep[id] = true
+		}
+	}
+	d.mu.Unlock()
+
+	dir := d.worktreesDir(owner, repo)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || keep[entry.Name()] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			log.Printf("Failed to remove worktree %s: %v", entry.Name(), err)
+			continue
+		}
+		removed++
+	}
+	if removed == 0 {
+		return
+	}
+
+	// Drop git's bookkeeping for the trees that are gone
+	mirror := d.mirrorPath(owner, repo)
+	if err := newCommand(context.Background(), "git", "--git-dir", mirror, "worktree", "prune").Run(); err != nil {
+		log.Printf("Failed to prune worktrees of %s/%s: %v", owner, repo, err)
+	}
+	log.Printf("Removed %d unused worktrees of %s/%s", removed, owner, repo)
+}
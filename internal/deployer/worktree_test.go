@@ -0,0 +1,91 @@
+package deployer
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ejfox/dockrune/internal/models"
+)
+
+// newTestRepo creates a git repo with one commit per version, each writing
+// the version to VERSION, and returns its path and the commit SHAs.
+func newTestRepo(t *testing.T, versions ...string) (string, []string) {
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	git("init", "-q")
+	var shas []string
+	for _, version := range versions {
+		if err := os.WriteFile(filepath.Join(dir, "VERSION"), []byte(version), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", "VERSION")
+		git("commit", "-q", "-m", version)
+		shas = append(shas, git("rev-parse", "HEAD"))
+	}
+	return dir, shas
+}
+
+func TestWorktreesAreIsolatedAndPruned(t *testing.T) {
+	d := newTestDeployer(t)
+	repo, shas := newTestRepo(t, "one", "two")
+
+	logFile, err := createLog(filepath.Join(t.TempDir(), "deploy.log"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+
+	production := &models.Deployment{
+		ID: "production", Owner: "ejfox", Repo: "site", SHA: shas[0],
+		CloneURL: repo, Environment: "production", Status: models.StatusSuccess,
+	}
+	preview := &models.Deployment{
+		ID: "preview", Owner: "ejfox", Repo: "site", SHA: shas[1],
+		CloneURL: repo, Environment: "pr-1", Status: models.StatusFailed,
+	}
+
+	ctx := context.Background()
+	for _, deployment := range []*models.Deployment{production, preview} {
+		if err := d.storage.CreateDeployment(deployment); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.updateMirror(ctx, deployment, logFile); err != nil {
+			t.Fatalf("updateMirror() error = %v", err)
+		}
+		if _, err := d.addWorktree(ctx, deployment, logFile); err != nil {
+			t.Fatalf("addWorktree() error = %v", err)
+		}
+	}
+
+	for deployment, want := range map[*models.Deployment]string{production: "one", preview: "two"} {
+		got, err := os.ReadFile(filepath.Join(d.worktreePath(deployment), "VERSION"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s VERSION = %q, want %q", deployment.ID, got, want)
+		}
+	}
+
+	d.pruneWorktrees("ejfox", "site")
+
+	if _, err := os.Stat(d.worktreePath(production)); err != nil {
+		t.Errorf("serving worktree was removed: %v", err)
+	}
+	if _, err := os.Stat(d.worktreePath(preview)); !os.IsNotExist(err) {
+		t.Errorf("failed deployment's worktree still exists (err = %v)", err)
+	}
+}
@@ -0,0 +1,249 @@
+package detector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// composeFiles are the compose file names docker compose looks for, in its
+// order of preference, each with the override file it merges on top.
+var composeFiles = []struct{ base, override string }{
+	{"compose.yaml", "compose.override.yaml"},
+	{"compose.yml", "compose.override.yml"},
+	{"docker-compose.yaml", "docker-compose.override.yaml"},
+	{"docker-compose.yml", "docker-compose.override.yml"},
+}
+
+// webServiceNames are the names a web-facing service usually goes by, most
+// likely first.
+var webServiceNames = []string{"web", "app", "frontend", "server", "api", "nginx"}
+
+// WebLabel marks a compose service as the web-facing one when the guess
+// would be wrong: `labels: {dockrune.web: "true"}`.
+const WebLabel = "dockrune.web"
+
+// ComposeProject is what dockrune needs to know about a compose project.
+type ComposeProject struct {
+	Files    []string // relative to the project, base file first
+	Services []string // sorted
+	Web      string   // the web-facing service, "" if there's none
+	Port     int      // port Web listens on inside its container, 0 if unknown
+	HostPort string   // host side of Web's published port as written, "" if unpublished
+}
+
+type composeFile struct {
+	Services map[string]*composeService `yaml:"services"`
+}
+
+type composeService struct {
+	Ports  []composePort `yaml:"ports"`
+	Expose []string      `yaml:"expose"`
+	Labels composeLabels `yaml:"labels"`
+}
+
+// composePort is a port in either the short syntax, "8080:3000", or the
+// long one, {target: 3000, published: 8080}.
+type composePort struct {
+	Target    int
+	Published string
+	Protocol  string
+}
+
+func (p *composePort) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return p.parse(node.Value)
+	}
+	var long struct {
+		Target    int    `yaml:"target"`
+		Published string `yaml:"published"`
+		Protocol  string `yaml:"protocol"`
+	}
+	if err := node.Decode(&long); err != nil {
+		return err
+	}
+	p.Target, p.Published, p.Protocol = long.Target, long.Published, long.Protocol
+	return nil
+}
+
+// parse reads the short syntax: [[ip:]published:]target[/protocol], where
+// either side may be a range or a ${VARIABLE}. A target that isn't a
+// number is left at 0.
+func (p *composePort) parse(spec string) error {
+	spec, p.Protocol, _ = strings.Cut(spec, "/")
+	parts := splitOutsideVariables(spec, ':')
+
+	target, _, _ := strings.Cut(parts[len(parts)-1], "-")
+	p.Target, _ = strconv.Atoi(target)
+	if len(parts) > 1 {
+		p.Published = parts[len(parts)-2]
+	}
+	return nil
+}
+
+// splitOutsideVariables splits s at sep, except inside ${...}.
+func splitOutsideVariables(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "${"):
+			depth++
+			i++
+		case s[i] == '}' && depth > 0:
+			depth--
+		case s[i] == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// composeLabels reads labels as either a map or a list of key=value.
+type composeLabels map[string]string
+
+func (l *composeLabels) UnmarshalYAML(node *yaml.Node) error {
+	*l = composeLabels{}
+	if node.Kind == yaml.SequenceNode {
+		var list []string
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+		for _, label := range list {
+			key, value, _ := strings.Cut(label, "=")
+			(*l)[key] = value
+		}
+		return nil
+	}
+	return node.Decode((*map[string]string)(l))
+}
+
+// LoadCompose reads the compose file of the project at projectPath along
+// with its override file, or returns nil if it has none. If they can't be
+// parsed, the project comes with only its Files along with the error.
+func LoadCompose(projectPath string) (*ComposeProject, error) {
+	var project ComposeProject
+	for _, names := range composeFiles {
+		if _, err := os.Stat(filepath.Join(projectPath, names.base)); err == nil {
+			project.Files = append(project.Files, names.base)
+			if _, err := os.Stat(filepath.Join(projectPath, names.override)); err == nil {
+				project.Files = append(project.Files, names.override)
+			}
+			break
+		}
+	}
+	if project.Files == nil {
+		return nil, nil
+	}
+
+	// Overrides add ports and labels to the services they name, like
+	// compose merges them
+	services := make(map[string]*composeService)
+	for _, name := range project.Files {
+		data, err := os.ReadFile(filepath.Join(projectPath, name))
+		if err != nil {
+			return &project, err
+		}
+		var file composeFile
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return &project, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		for serviceName, service := range file.Services {
+			if service == nil {
+				service = &composeService{}
+			}
+			merged, ok := services[serviceName]
+			if !ok {
+				services[serviceName] = service
+				continue
+			}
+			merged.Ports = append(merged.Ports, service.Ports...)
+			merged.Expose = append(merged.Expose, service.Expose...)
+			for key, value := range service.Labels {
+				if merged.Labels == nil {
+					merged.Labels = composeLabels{}
+				}
+				merged.Labels[key] = value
+			}
+		}
+	}
+
+	for name := range services {
+		project.Services = append(project.Services, name)
+	}
+	sort.Strings(project.Services)
+
+	project.Web = webService(project.Services, services)
+	if project.Web != "" {
+		project.Port, project.HostPort = servicePort(services[project.Web])
+	}
+	return &project, nil
+}
+
+// webService picks the service that serves the app: the one labelled
+// dockrune.web, else one that publishes a port, else one that exposes one,
+// going by webServiceNames and then by name among several.
+func webService(names []string, services map[string]*composeService) string {
+	for _, name := range names {
+		if v := services[name].Labels[WebLabel]; v == "true" {
+			return name
+		}
+	}
+
+	pick := func(candidate func(*composeService) bool) string {
+		var candidates []string
+		for _, name := range names {
+			if candidate(services[name]) {
+				candidates = append(candidates, name)
+			}
+		}
+		for _, preferred := range webServiceNames {
+			for _, name := range candidates {
+				if name == preferred {
+					return name
+				}
+			}
+		}
+		if len(candidates) > 0 {
+			return candidates[0]
+		}
+		return ""
+	}
+
+	if name := pick(func(s *composeService) bool { return len(tcpPorts(s)) > 0 }); name != "" {
+		return name
+	}
+	return pick(func(s *composeService) bool { return len(s.Expose) > 0 })
+}
+
+// servicePort returns the first TCP port a service publishes, or else
+// exposes, and the host side of its mapping.
+func servicePort(service *composeService) (port int, hostPort string) {
+	if ports := tcpPorts(service); len(ports) > 0 {
+		return ports[0].Target, ports[0].Published
+	}
+	for _, expose := range service.Expose {
+		spec, _, _ := strings.Cut(expose, "/")
+		spec, _, _ = strings.Cut(spec, "-")
+		if port, err := strconv.Atoi(spec); err == nil {
+			return port, ""
+		}
+	}
+	return 0, ""
+}
+
+func tcpPorts(service *composeService) []composePort {
+	var ports []composePort
+	for _, port := range service.Ports {
+		if port.Protocol == "" || port.Protocol == "tcp" {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
@@ -0,0 +1,113 @@
+package detector
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadCompose(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  *ComposeProject
+	}{
+		{
+			name:  "no compose file",
+			files: map[string]string{"Dockerfile": "FROM scratch"},
+			want:  nil,
+		},
+		{
+			name: "published port",
+			files: map[string]string{
+				"docker-compose.yml": "services:\n  db:\n    image: postgres\n    ports: [\"5432:5432\"]\n  web:\n    build: .\n    ports:\n      - \"127.0.0.1:8080:3000/tcp\"\n",
+			},
+			want: &ComposeProject{Files: []string{"docker-compose.yml"}, Services: []string{"db", "web"}, Web: "web", Port: 3000, HostPort: "8080"},
+		},
+		{
+			name: "variable host port and long syntax",
+			files: map[string]string{
+				"compose.yaml": "services:\n  site:\n    build: .\n    ports:\n      - target: 80\n        published: \"${PORT:-8080}\"\n",
+			},
+			want: &ComposeProject{Files: []string{"compose.yaml"}, Services: []string{"site"}, Web: "site", Port: 80, HostPort: "${PORT:-8080}"},
+		},
+		{
+			name: "short syntax variable with a colon",
+			files: map[string]string{
+				"compose.yml": "services:\n  app:\n    build: .\n    ports: [\"${PORT:-3000}:3000\"]\n",
+			},
+			want: &ComposeProject{Files: []string{"compose.yml"}, Services: []string{"app"}, Web: "app", Port: 3000, HostPort: "${PORT:-3000}"},
+		},
+		{
+			name: "compose.yaml wins, override adds ports",
+			files: map[string]string{
+				"compose.yaml":          "services:\n  worker:\n    build: .\n  frontend:\n    build: .\n",
+				"compose.override.yaml": "services:\n  frontend:\n    ports: [\"4000\"]\n",
+				"docker-compose.yml":    "services:\n  old:\n    image: nginx\n",
+			},
+			want: &ComposeProject{Files: []string{"compose.yaml", "compose.override.yaml"}, Services: []string{"frontend", "worker"}, Web: "frontend", Port: 4000},
+		},
+		{
+			name: "exposed only, udp ignored",
+			files: map[string]string{
+				"docker-compose.yml": "services:\n  dns:\n    image: coredns\n    ports: [\"53:53/udp\"]\n  backend:\n    build: .\n    expose: [8000]\n",
+			},
+			want: &ComposeProject{Files: []string{"docker-compose.yml"}, Services: []string{"backend", "dns"}, Web: "backend", Port: 8000},
+		},
+		{
+			name: "label picks the web service",
+			files: map[string]string{
+				"docker-compose.yml": "services:\n  web:\n    image: nginx\n    ports: [\"80:80\"]\n  admin:\n    build: .\n    expose: [\"9000\"]\n    labels:\n      - dockrune.web=true\n",
+			},
+			want: &ComposeProject{Files: []string{"docker-compose.yml"}, Services: []string{"admin", "web"}, Web: "admin", Port: 9000},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := LoadCompose(dir)
+			if err != nil {
+				t.Fatalf("LoadCompose() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadCompose() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDockerDetectorComposeMetadata(t *testing.T) {
+	dir := t.TempDir()
+	compose := "services:\n  web:\n    build: .\n    ports: [\"8080:3000\"]\n  redis:\n    image: redis\n"
+	if err := os.WriteFile(filepath.Join(dir, "docker-compose.yml"), []byte(compose), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	detection, err := (&DockerDetector{}).Detect(dir)
+	if err != nil || detection == nil {
+		t.Fatalf("Detect() = %v, %v", detection, err)
+	}
+	if detection.Port != 3000 {
+		t.Errorf("Port = %d, want the web service's container port 3000", detection.Port)
+	}
+	if detection.Metadata["web_service"] != "web" || !reflect.DeepEqual(detection.Metadata["services"], []string{"redis", "web"}) {
+		t.Errorf("Metadata = %v", detection.Metadata)
+	}
+
+	// A broken file is still a compose project
+	if err := os.WriteFile(filepath.Join(dir, "docker-compose.yml"), []byte("services: [oops"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	detection, _ = (&DockerDetector{}).Detect(dir)
+	if detection == nil || detection.Metadata["compose_file"] != "docker-compose.yml" || detection.Metadata["compose_error"] == nil {
+		t.Errorf("broken compose file detected as %+v", detection)
+	}
+}
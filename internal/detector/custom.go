@@ -0,0 +1,271 @@
+package detector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Defaults of detectors declared in the config file. They win confidence
+// ties with every built-in detector.
+const (
+	DefaultCustomPriority   = 200
+	DefaultCustomConfidence = 1.0
+)
+
+// Spec declares a detector in the dockrune config file:
+//
+//	detectors:
+//	  - name: remix
+//	    match:
+//	      - file: remix.config.js
+//	      - json: dependencies.@remix-run/node
+//	    install: npm ci
+//	    build: npm run build
+//	    start: npm run start
+//
+// Install, Build and Start are text/template templates. They see .Match, the
+// named groups of the regex rules, and .Package, the decoded package.json.
+type Spec struct {
+	Name       string  `mapstructure:"name"`
+	Type       string  `mapstructure:"type"`       // project type reported, Name if unset
+	Priority   int     `mapstructure:"priority"`   // DefaultCustomPriority if unset
+	Confidence float32 `mapstructure:"confidence"` // DefaultCustomConfidence if unset
+	Match      []Rule  `mapstructure:"match"`      // all of them must hold
+	Install    string  `mapstructure:"install"`
+	Build      string  `mapstructure:"build"`
+	Start      string  `mapstructure:"start"`
+}
+
+// Rule is one condition of a Spec. File alone must exist (it may be a glob),
+// File with Regex must contain a match, and JSON is a dotted path that must
+// be present in File, package.json by default.
+type Rule struct {
+	File  string `mapstructure:"file"`
+	JSON  string `mapstructure:"json"`
+	Regex string `mapstructure:"regex"`
+}
+
+// CustomDetector is a detector declared in the config file.
+type CustomDetector struct {
+	spec  Spec
+	rules []rule
+
+	install, build, start *template.Template
+}
+
+type rule struct {
+	Rule
+	regex *regexp.Regexp
+}
+
+// templateData is what Install, Build and Start templates see.
+type templateData struct {
+	Match   map[string]string
+	Package map[string]interface{}
+}
+
+// NewCustomDetector checks a Spec and compiles its rules and templates.
+func NewCustomDetector(spec Spec) (*CustomDetector, error) {
+	if spec.Name == "" {
+		return nil, errors.New("detector has no name")
+	}
+	if spec.Type == "" {
+		spec.Type = spec.Name
+	}
+	if spec.Priority == 0 {
+		spec.Priority = DefaultCustomPriority
+	}
+	if spec.Confidence == 0 {
+		spec.Confidence = DefaultCustomConfidence
+	}
+	if spec.Confidence < 0 || spec.Confidence > 1 {
+		return nil, fmt.Errorf("detector %s: confidence %g is not between 0 and 1", spec.Name, spec.Confidence)
+	}
+	if len(spec.Match) == 0 {
+		return nil, fmt.Errorf("detector %s: no match rules", spec.Name)
+	}
+
+	d := &CustomDetector{spec: spec}
+	for i, r := range spec.Match {
+		compiled := rule{Rule: r}
+		switch {
+		case r.JSON != "" && r.Regex != "":
+			return nil, fmt.Errorf("detector %s: match[%d] has both json and regex", spec.Name, i)
+		case r.JSON != "":
+			if compiled.File == "" {
+				compiled.File = "package.json"
+			}
+		case r.Regex != "":
+			if r.File == "" {
+				return nil, fmt.Errorf("detector %s: match[%d] regex needs a file to look in", spec.Name, i)
+			}
+			var err error
+			if compiled.regex, err = regexp.Compile(r.Regex); err != nil {
+				return nil, fmt.Errorf("detector %s: match[%d]: %w", spec.Name, i, err)
+			}
+		case r.File == "":
+			return nil, fmt.Errorf("detector %s: match[%d] is empty", spec.Name, i)
+		}
+		d.rules = append(d.rules, compiled)
+	}
+
+	templates := []struct {
+		name string
+		text string
+		t    **template.Template
+	}{
+		{"install", spec.Install, &d.install}, {"build", spec.Build, &d.build}, {"start", spec.Start, &d.start},
+	}
+	for _, tmpl := range templates {
+		t, err := template.New(tmpl.name).Option("missingkey=zero").Parse(tmpl.text)
+		if err != nil {
+			return nil, fmt.Errorf("detector %s: %w", spec.Name, err)
+		}
+		*tmpl.t = t
+	}
+	return d, nil
+}
+
+// NewCustomDetectors compiles every Spec, in order.
+func NewCustomDetectors(specs []Spec) ([]Detector, error) {
+	detectors := make([]Detector, 0, len(specs))
+	for _, spec := range specs {
+		d, err := NewCustomDetector(spec)
+		if err != nil {
+			return nil, err
+		}
+		detectors = append(detectors, d)
+	}
+	return detectors, nil
+}
+
+func (d *CustomDetector) Name() string  { return d.spec.Name }
+func (d *CustomDetector) Priority() int { return d.spec.Priority }
+
+// Detect matches every rule against the project and renders the
+// templates if they all hold.
+func (d *CustomDetector) Detect(projectPath string) (*Detection, error) {
+	data := templateData{Match: map[string]string{}}
+	if raw, err := os.ReadFile(filepath.Join(projectPath, "package.json")); err == nil {
+		json.Unmarshal(raw, &data.Package)
+	}
+
+	var evidence []string
+	for _, r := range d.rules {
+		found, ok := r.match(projectPath, data.Match)
+		if !ok {
+			return nil, nil
+		}
+		evidence = append(evidence, found)
+	}
+
+	detection := &Detection{
+		Type:       ProjectType(d.spec.Type),
+		Confidence: d.spec.Confidence,
+		Evidence:   evidence,
+		Metadata: map[string]interface{}{
+			"detector": d.spec.Name,
+		},
+	}
+	var err error
+	if detection.InstallCmd, err = render(d.install, data); err != nil {
+		return nil, fmt.Errorf("detector %s: %w", d.spec.Name, err)
+	}
+	if detection.BuildCmd, err = render(d.build, data); err != nil {
+		return nil, fmt.Errorf("detector %s: %w", d.spec.Name, err)
+	}
+	if detection.StartCmd, err = render(d.start, data); err != nil {
+		return nil, fmt.Errorf("detector %s: %w", d.spec.Name, err)
+	}
+	return detection, nil
+}
+
+// match reports whether r holds for the project, describing what it found.
+// Named groups of a regex are added to groups.
+func (r rule) match(projectPath string, groups map[string]string) (string, bool) {
+	matches, _ := filepath.Glob(filepath.Join(projectPath, r.File))
+	if len(matches) == 0 {
+		return "", false
+	}
+
+	switch {
+	case r.JSON != "":
+		raw, err := os.ReadFile(matches[0])
+		if err != nil {
+			return "", false
+		}
+		var doc interface{}
+		if err := json.Unmarshal(raw, &doc); err != nil || !hasJSONPath(doc, r.JSON) {
+			return "", false
+		}
+		return fmt.Sprintf("%s has %s", relative(projectPath, matches[0]), r.JSON), true
+
+	case r.regex != nil:
+		for _, path := range matches {
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			m := r.regex.FindSubmatch(raw)
+			if m == nil {
+				continue
+			}
+			for i, name := range r.regex.SubexpNames() {
+				if name != "" && m[i] != nil {
+					groups[name] = string(m[i])
+				}
+			}
+			return fmt.Sprintf("%s matches %s", relative(projectPath, path), r.Regex), true
+		}
+		return "", false
+
+	default:
+		return fmt.Sprintf("%s exists", relative(projectPath, matches[0])), true
+	}
+}
+
+// hasJSONPath reports whether the dotted path leads somewhere in doc. Keys
+// may contain dots themselves, as in dependencies.socket.io.
+func hasJSONPath(doc interface{}, path string) bool {
+	if path == "" {
+		return true
+	}
+	object, ok := doc.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	if _, ok := object[path]; ok {
+		return true
+	}
+	for i := 0; i < len(path); i++ {
+		if path[i] != '.' {
+			continue
+		}
+		if value, ok := object[path[:i]]; ok && hasJSONPath(value, path[i+1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func render(t *template.Template, data templateData) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func relative(base, path string) string {
+	if rel, err := filepath.Rel(base, path); err == nil {
+		return rel
+	}
+	return path
+}
@@ -0,0 +1,122 @@
+package detector
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestCustomDetector(t *testing.T) {
+	spec := Spec{
+		Name: "phoenix",
+		Match: []Rule{
+			{File: "mix.exs"},
+			{File: "config/*.exs", Regex: `config :(?P<app>\w+), Endpoint`},
+			{JSON: "dependencies.socket.io"},
+		},
+		Install: "mix deps.get",
+		Build:   "mix assets.deploy",
+		Start:   "_build/prod/rel/{{.Match.app}}/bin/{{.Package.name}} start",
+	}
+	d, err := NewCustomDetector(spec)
+	if err != nil {
+		t.Fatalf("NewCustomDetector() error = %v", err)
+	}
+	if d.Priority() != DefaultCustomPriority {
+		t.Errorf("Priority() = %d, want the default %d", d.Priority(), DefaultCustomPriority)
+	}
+
+	dir := writeFiles(t, map[string]string{
+		"mix.exs":         "defmodule App.MixProject do",
+		"config/prod.exs": "config :app, Endpoint, http: [port: 4000]",
+		"package.json":    `{"name": "chat", "dependencies": {"socket.io": "^4"}}`,
+	})
+	detection, err := d.Detect(dir)
+	if err != nil || detection == nil {
+		t.Fatalf("Detect() = %v, %v", detection, err)
+	}
+	want := &Detection{
+		Type:       "phoenix",
+		Confidence: DefaultCustomConfidence,
+		InstallCmd: "mix deps.get",
+		BuildCmd:   "mix assets.deploy",
+		StartCmd:   "_build/prod/rel/app/bin/chat start",
+		Evidence: []string{
+			"mix.exs exists",
+			`config/prod.exs matches config :(?P<app>\w+), Endpoint`,
+			"package.json has dependencies.socket.io",
+		},
+		Metadata: map[string]interface{}{"detector": "phoenix"},
+	}
+	if !reflect.DeepEqual(detection, want) {
+		t.Errorf("Detect() = %+v, want %+v", detection, want)
+	}
+
+	// Every rule has to hold
+	os.Remove(filepath.Join(dir, "config", "prod.exs"))
+	if detection, _ := d.Detect(dir); detection != nil {
+		t.Errorf("Detect() without a matching config file = %+v", detection)
+	}
+}
+
+func TestCustomDetectorInvalid(t *testing.T) {
+	tests := []struct {
+		spec Spec
+		want string
+	}{
+		{Spec{Match: []Rule{{File: "x"}}}, "no name"},
+		{Spec{Name: "a"}, "no match rules"},
+		{Spec{Name: "a", Match: []Rule{{}}}, "match[0] is empty"},
+		{Spec{Name: "a", Match: []Rule{{Regex: "x"}}}, "needs a file"},
+		{Spec{Name: "a", Match: []Rule{{File: "x", Regex: "("}}}, "missing closing )"},
+		{Spec{Name: "a", Match: []Rule{{File: "x"}}, Start: "{{.Oops"}, "unclosed action"},
+		{Spec{Name: "a", Match: []Rule{{File: "x"}}, Confidence: 2}, "not between 0 and 1"},
+	}
+	for _, tt := range tests {
+		_, err := NewCustomDetector(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("NewCustomDetector(%+v) error = %v, want %q", tt.spec, err, tt.want)
+		}
+	}
+}
+
+func TestManagerPriority(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"package.json": `{"dependencies": {"express": "^4"}, "internal": {"stack": "acme"}}`,
+	})
+
+	// Same confidence as the node detector, so the higher priority wins
+	acme, err := NewCustomDetector(Spec{Name: "acme", Confidence: 0.8, Match: []Rule{{JSON: "internal.stack"}}, Start: "acme-run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	detection, _ := NewManager(acme).DetectProject(dir)
+	if detection.Type != "acme" {
+		t.Errorf("DetectProject() = %s, want the custom detector", detection.Type)
+	}
+
+	low, err := NewCustomDetector(Spec{Name: "low", Confidence: 0.8, Priority: 10, Match: []Rule{{File: "package.json"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	detection, _ = NewManager(low).DetectProject(dir)
+	if detection.Type != TypeNode {
+		t.Errorf("DetectProject() = %s, want node to win the tie", detection.Type)
+	}
+}
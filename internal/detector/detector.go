@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 )
 
 type ProjectType string
@@ -23,95 +24,115 @@ const (
 type Detection struct {
 	Type       ProjectType
 	Confidence float32
+	InstallCmd string // run before BuildCmd, e.g. "npm ci"
 	BuildCmd   string
 	StartCmd   string
 	Port       int
+	Evidence   []string // what the detector found, e.g. "go.mod exists"
 	Metadata   map[string]interface{}
 }
 
 type Detector interface {
+	Name() string
 	Detect(projectPath string) (*Detection, error)
 	Priority() int
 }
 
 type Manager struct {
-	detectors []Detector
+	detectors []Detector // highest priority first
 }
 
-func NewManager() *Manager {
-	return &Manager{
-		detectors: []Detector{
-			&DockerDetector{},
-			&NuxtDetector{},
-			&GoDetector{},
-			&RustDetector{},
-			&NodeDetector{},
-			&PythonDetector{},
-			&StaticDetector{},
-		},
-	}
+// NewManager returns a manager with the built-in detectors and any extra
+// ones, such as those declared in the config file.
+func NewManager(extra ...Detector) *Manager {
+	detectors := append([]Detector{
+		&DockerDetector{},
+		&NuxtDetector{},
+		&GoDetector{},
+		&RustDetector{},
+		&NodeDetector{},
+		&PythonDetector{},
+		&StaticDetector{},
+	}, extra...)
+	sort.SliceStable(detectors, func(i, j int) bool {
+		return detectors[i].Priority() > detectors[j].Priority()
+	})
+	return &Manager{detectors: detectors}
 }
 
+// DetectProject returns the detection with the highest confidence. Ties go
+// to the detector with the higher priority.
 func (m *Manager) DetectProject(projectPath string) (*Detection, error) {
-	var bestDetection *Detection
-	var highestConfidence float32
-
-	for _, detector := range m.detectors {
-		detection, err := detector.Detect(projectPath)
-		if err != nil {
-			continue
-		}
-
-		if detection != nil && detection.Confidence > highestConfidence {
-			highestConfidence = detection.Confidence
-			bestDetection = detection
-		}
-	}
-
-	if bestDetection == nil {
-		return &Detection{
-			Type:       TypeUnknown,
-			Confidence: 0,
-		}, nil
-	}
-
-	return bestDetection, nil
+	return m.Explain(projectPath).Detection(), nil
 }
 
 // DockerDetector detects Docker-based projects
 type DockerDetector struct{}
 
+func (d *DockerDetector) Name() string  { return "docker" }
 func (d *DockerDetector) Priority() int { return 100 }
 
 func (d *DockerDetector) Detect(projectPath string) (*Detection, error) {
-	// Check for docker-compose.yml
-	composePath := filepath.Join(projectPath, "docker-compose.yml")
-	if _, err := os.Stat(composePath); err == nil {
-		return &Detection{
+	// Check for a compose file. One that can't be parsed is still a compose
+	// project; compose itself will say what's wrong with it
+	project, err := LoadCompose(projectPath)
+	if project != nil {
+		detection := &Detection{
 			Type:       TypeDocker,
 			Confidence: 1.0,
 			BuildCmd:   "docker-compose build",
 			StartCmd:   "docker-compose up -d",
-			Port:       0, // Will be detected from compose file
+			Port:       project.Port,
+			Evidence:   []string{project.Files[0] + " exists"},
 			Metadata: map[string]interface{}{
-				"compose_file": "docker-compose.yml",
+				"compose_file":  project.Files[0],
+				"compose_files": project.Files,
 			},
-		}, nil
+		}
+		if err != nil {
+			detection.Metadata["compose_error"] = err.Error()
+			return detection, nil
+		}
+		detection.Metadata["services"] = project.Services
+		if project.Web != "" {
+			detection.Metadata["web_service"] = project.Web
+			detection.Evidence = append(detection.Evidence, fmt.Sprintf("service %s is the web service, on port %d", project.Web, project.Port))
+		}
+		return detection, nil
 	}
 
-	// Check for Dockerfile
+	// Check for Dockerfile. The docker runtime builds and runs it itself,
+	// so there are no commands to go with it
 	dockerfilePath := filepath.Join(projectPath, "Dockerfile")
 	if _, err := os.Stat(dockerfilePath); err == nil {
-		return &Detection{
+		detection := &Detection{
 			Type:       TypeDocker,
 			Confidence: 0.9,
-			BuildCmd:   "docker build -t app .",
-			StartCmd:   "docker run -d --name app -p ${PORT}:${PORT} app",
 			Port:       3000,
+			Evidence:   []string{"Dockerfile exists"},
 			Metadata: map[string]interface{}{
 				"dockerfile": "Dockerfile",
 			},
-		}, nil
+		}
+		dockerfile, err := ParseDockerfile(dockerfilePath)
+		if err != nil {
+			detection.Metadata["dockerfile_error"] = err.Error()
+			return detection, nil
+		}
+		if names := dockerfile.Names(); len(names) > 0 {
+			detection.Metadata["stages"] = names
+		}
+		if stage, ok := dockerfile.Stage(""); ok {
+			if len(stage.Expose) > 0 {
+				detection.Port = stage.Expose[0]
+				detection.Metadata["expose"] = stage.Expose
+				detection.Evidence = append(detection.Evidence, fmt.Sprintf("Dockerfile exposes port %d", stage.Expose[0]))
+			}
+			if hc := stage.Healthcheck; hc != nil && !hc.Disabled {
+				detection.Metadata["healthcheck"] = hc.Command
+			}
+		}
+		return detection, nil
 	}
 
 	return nil, nil
@@ -120,6 +141,7 @@ func (d *DockerDetector) Detect(projectPath string) (*Detection, error) {
 // NuxtDetector detects Nuxt.js projects
 type NuxtDetector struct{}
 
+func (n *NuxtDetector) Name() string  { return "nuxt" }
 func (n *NuxtDetector) Priority() int { return 90 }
 
 func (n *NuxtDetector) Detect(projectPath string) (*Detection, error) {
@@ -151,31 +173,38 @@ func (n *NuxtDetector) Detect(projectPath string) (*Detection, error) {
 	}
 
 	if isNuxt3 {
+		pm, pmEvidence := DetectPackageManager(projectPath, pkg)
+		evidence := append([]string{"package.json depends on nuxt"}, pmEvidence...)
+
 		// Check for Nitro output
 		nitroPath := filepath.Join(projectPath, ".output", "server", "index.mjs")
 		if _, err := os.Stat(nitroPath); err == nil {
 			return &Detection{
 				Type:       TypeNuxt,
 				Confidence: 1.0,
-				BuildCmd:   "npm run build",
+				InstallCmd: pm.Install(),
+				BuildCmd:   pm.Run("build"),
 				StartCmd:   "node .output/server/index.mjs",
 				Port:       3000,
-				Metadata: map[string]interface{}{
+				Evidence:   append(evidence, ".output/server/index.mjs exists"),
+				Metadata: pm.metadata(map[string]interface{}{
 					"version": "3",
 					"nitro":   true,
-				},
+				}),
 			}, nil
 		}
 
 		return &Detection{
 			Type:       TypeNuxt,
 			Confidence: 0.95,
-			BuildCmd:   "npm install && npm run build",
-			StartCmd:   "npm run start",
+			InstallCmd: pm.Install(),
+			BuildCmd:   pm.Run("build"),
+			StartCmd:   pm.Run("start"),
 			Port:       3000,
-			Metadata: map[string]interface{}{
+			Evidence:   evidence,
+			Metadata: pm.metadata(map[string]interface{}{
 				"version": "3",
-			},
+			}),
 		}, nil
 	}
 
@@ -185,6 +214,7 @@ func (n *NuxtDetector) Detect(projectPath string) (*Detection, error) {
 // GoDetector detects Go projects
 type GoDetector struct{}
 
+func (g *GoDetector) Name() string  { return "go" }
 func (g *GoDetector) Priority() int { return 85 }
 
 func (g *GoDetector) Detect(projectPath string) (*Detection, error) {
@@ -217,6 +247,7 @@ func (g *GoDetector) Detect(projectPath string) (*Detection, error) {
 		BuildCmd:   "go build -o app",
 		StartCmd:   startCmd,
 		Port:       8080,
+		Evidence:   []string{"go.mod exists"},
 		Metadata: map[string]interface{}{
 			"has_go_mod": true,
 		},
@@ -226,6 +257,7 @@ func (g *GoDetector) Detect(projectPath string) (*Detection, error) {
 // RustDetector detects Rust projects
 type RustDetector struct{}
 
+func (r *RustDetector) Name() string  { return "rust" }
 func (r *RustDetector) Priority() int { return 85 }
 
 func (r *RustDetector) Detect(projectPath string) (*Detection, error) {
@@ -240,6 +272,7 @@ func (r *RustDetector) Detect(projectPath string) (*Detection, error) {
 		BuildCmd:   "cargo build --release",
 		StartCmd:   "./target/release/app",
 		Port:       8080,
+		Evidence:   []string{"Cargo.toml exists"},
 		Metadata: map[string]interface{}{
 			"has_cargo": true,
 		},
@@ -249,6 +282,7 @@ func (r *RustDetector) Detect(projectPath string) (*Detection, error) {
 // NodeDetector detects generic Node.js projects
 type NodeDetector struct{}
 
+func (n *NodeDetector) Name() string  { return "node" }
 func (n *NodeDetector) Priority() int { return 70 }
 
 func (n *NodeDetector) Detect(projectPath string) (*Detection, error) {
@@ -263,17 +297,16 @@ func (n *NodeDetector) Detect(projectPath string) (*Detection, error) {
 		return nil, nil
 	}
 
+	pm, pmEvidence := DetectPackageManager(projectPath, pkg)
+
 	// Check scripts
 	scripts, _ := pkg["scripts"].(map[string]interface{})
-	startCmd := "npm start"
+	startCmd := pm.Run("start")
 	buildCmd := ""
 
 	if scripts != nil {
-		if _, ok := scripts["start"]; ok {
-			startCmd = "npm start"
-		}
 		if _, ok := scripts["build"]; ok {
-			buildCmd = "npm run build && "
+			buildCmd = pm.Run("build")
 		}
 	}
 
@@ -281,44 +314,52 @@ func (n *NodeDetector) Detect(projectPath string) (*Detection, error) {
 	deps, _ := pkg["dependencies"].(map[string]interface{})
 	framework := "generic"
 	port := 3000
+	evidence := append([]string{"package.json exists"}, pmEvidence...)
 
 	if deps != nil {
 		if _, ok := deps["express"]; ok {
 			framework = "express"
 			port = 3000
+			evidence = append(evidence, "package.json depends on express")
 		} else if _, ok := deps["fastify"]; ok {
 			framework = "fastify"
 			port = 3000
+			evidence = append(evidence, "package.json depends on fastify")
 		} else if _, ok := deps["@nestjs/core"]; ok {
 			framework = "nestjs"
 			port = 3000
+			evidence = append(evidence, "package.json depends on @nestjs/core")
 		} else if _, ok := deps["next"]; ok {
 			framework = "nextjs"
 			port = 3000
-			startCmd = "npm run start"
-			buildCmd = "npm run build && "
+			buildCmd = pm.Run("build")
+			evidence = append(evidence, "package.json depends on next")
 		}
 	}
 
 	return &Detection{
 		Type:       TypeNode,
 		Confidence: 0.8,
-		BuildCmd:   buildCmd + "npm install",
+		InstallCmd: pm.Install(),
+		BuildCmd:   buildCmd,
 		StartCmd:   startCmd,
 		Port:       port,
-		Metadata: map[string]interface{}{
+		Evidence:   evidence,
+		Metadata: pm.metadata(map[string]interface{}{
 			"framework": framework,
-		},
+		}),
 	}, nil
 }
 
 // PythonDetector detects Python projects
 type PythonDetector struct{}
 
+func (p *PythonDetector) Name() string  { return "python" }
 func (p *PythonDetector) Priority() int { return 70 }
 
 func (p *PythonDetector) Detect(projectPath string) (*Detection, error) {
 	// Check for requirements.txt
+	evidence := []string{"requirements.txt exists"}
 	reqPath := filepath.Join(projectPath, "requirements.txt")
 	if _, err := os.Stat(reqPath); err != nil {
 		// Check for pyproject.toml
@@ -326,6 +367,7 @@ func (p *PythonDetector) Detect(projectPath string) (*Detection, error) {
 		if _, err := os.Stat(pyprojectPath); err != nil {
 			return nil, nil
 		}
+		evidence = []string{"pyproject.toml exists"}
 	}
 
 	// Detect framework
@@ -338,6 +380,7 @@ func (p *PythonDetector) Detect(projectPath string) (*Detection, error) {
 		framework = "django"
 		startCmd = "python manage.py runserver 0.0.0.0:${PORT}"
 		port = 8000
+		evidence = append(evidence, "manage.py exists")
 	} else if _, err := os.Stat(filepath.Join(projectPath, "app.py")); err == nil {
 		// Could be Flask or FastAPI
 		data, _ := os.ReadFile(filepath.Join(projectPath, "app.py"))
@@ -345,12 +388,14 @@ func (p *PythonDetector) Detect(projectPath string) (*Detection, error) {
 		if len(content) > 0 {
 			if contains(content, "from flask") || contains(content, "import flask") {
 				framework = "flask"
-				startCmd = "python app.py"
+				startCmd = "flask --app app run --host 0.0.0.0 --port ${PORT}"
 				port = 5000
+				evidence = append(evidence, "app.py imports flask")
 			} else if contains(content, "from fastapi") || contains(content, "import fastapi") {
 				framework = "fastapi"
-				startCmd = "uvicorn app:app --host 0.0.0.0 --port 8000"
+				startCmd = "uvicorn app:app --host 0.0.0.0 --port ${PORT}"
 				port = 8000
+				evidence = append(evidence, "app.py imports fastapi")
 			}
 		}
 	}
@@ -361,6 +406,7 @@ func (p *PythonDetector) Detect(projectPath string) (*Detection, error) {
 		BuildCmd:   "pip install -r requirements.txt",
 		StartCmd:   startCmd,
 		Port:       port,
+		Evidence:   evidence,
 		Metadata: map[string]interface{}{
 			"framework": framework,
 		},
@@ -370,6 +416,7 @@ func (p *PythonDetector) Detect(projectPath string) (*Detection, error) {
 // StaticDetector detects static sites
 type StaticDetector struct{}
 
+func (s *StaticDetector) Name() string  { return "static" }
 func (s *StaticDetector) Priority() int { return 50 }
 
 func (s *StaticDetector) Detect(projectPath string) (*Detection, error) {
@@ -380,8 +427,9 @@ func (s *StaticDetector) Detect(projectPath string) (*Detection, error) {
 			Type:       TypeStatic,
 			Confidence: 0.7,
 			BuildCmd:   "",
-			StartCmd:   "python -m http.server 8080",
+			StartCmd:   "python -m http.server ${PORT}",
 			Port:       8080,
+			Evidence:   []string{"index.html exists"},
 			Metadata: map[string]interface{}{
 				"type": "html",
 			},
@@ -394,8 +442,9 @@ func (s *StaticDetector) Detect(projectPath string) (*Detection, error) {
 			Type:       TypeStatic,
 			Confidence: 0.8,
 			BuildCmd:   "jekyll build",
-			StartCmd:   "jekyll serve --host 0.0.0.0",
+			StartCmd:   "jekyll serve --host 0.0.0.0 --port ${PORT}",
 			Port:       4000,
+			Evidence:   []string{"_config.yml exists"},
 			Metadata: map[string]interface{}{
 				"generator": "jekyll",
 			},
@@ -407,8 +456,9 @@ func (s *StaticDetector) Detect(projectPath string) (*Detection, error) {
 			Type:       TypeStatic,
 			Confidence: 0.8,
 			BuildCmd:   "hugo",
-			StartCmd:   "hugo server --bind 0.0.0.0",
+			StartCmd:   "hugo server --bind 0.0.0.0 --port ${PORT}",
 			Port:       1313,
+			Evidence:   []string{"config.toml exists"},
 			Metadata: map[string]interface{}{
 				"generator": "hugo",
 			},
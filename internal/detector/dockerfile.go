@@ -0,0 +1,217 @@
+package detector
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dockerfile is what dockrune needs to know about a Dockerfile: its build
+// stages, in order.
+type Dockerfile struct {
+	Stages []DockerStage
+}
+
+// DockerStage is one FROM section of a Dockerfile.
+type DockerStage struct {
+	Name        string // as in FROM image AS name, "" if unnamed
+	From        string // base image or earlier stage
+	Expose      []int  // TCP ports, in order
+	Healthcheck *Healthcheck
+}
+
+// Healthcheck is a HEALTHCHECK instruction. Zero durations and retries
+// mean docker's defaults.
+type Healthcheck struct {
+	Disabled    bool // HEALTHCHECK NONE
+	Command     string
+	Interval    time.Duration
+	Timeout     time.Duration
+	StartPeriod time.Duration
+	Retries     int
+}
+
+// ParseDockerfile reads the Dockerfile at path. Instructions dockrune
+// doesn't care about are skipped, and so are ports it can't make out, such
+// as EXPOSE $PORT.
+func ParseDockerfile(path string) (*Dockerfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file Dockerfile
+	for _, line := range dockerfileInstructions(string(data)) {
+		keyword, args, _ := strings.Cut(line, " ")
+		args = strings.TrimSpace(args)
+
+		switch strings.ToUpper(keyword) {
+		case "FROM":
+			file.Stages = append(file.Stages, parseFrom(args))
+		case "EXPOSE":
+			if len(file.Stages) == 0 {
+				continue
+			}
+			stage := &file.Stages[len(file.Stages)-1]
+			stage.Expose = append(stage.Expose, parseExpose(args)...)
+		case "HEALTHCHECK":
+			if len(file.Stages) == 0 {
+				continue
+			}
+			file.Stages[len(file.Stages)-1].Healthcheck = parseHealthcheck(args)
+		}
+	}
+	return &file, nil
+}
+
+// dockerfileInstructions splits a Dockerfile into instructions, joining
+// continued lines and dropping comments and blank lines.
+func dockerfileInstructions(data string) []string {
+	var instructions []string
+	var current strings.Builder
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if rest, ok := strings.CutSuffix(line, "\\"); ok {
+			current.WriteString(rest)
+			current.WriteByte(' ')
+			continue
+		}
+		current.WriteString(line)
+		instructions = append(instructions, strings.Join(strings.Fields(current.String()), " "))
+		current.Reset()
+	}
+	if current.Len() > 0 {
+		instructions = append(instructions, strings.Join(strings.Fields(current.String()), " "))
+	}
+	return instructions
+}
+
+// parseFrom reads FROM [--platform=...] image [AS name].
+func parseFrom(args string) DockerStage {
+	var fields []string
+	for _, field := range strings.Fields(args) {
+		if !strings.HasPrefix(field, "--") {
+			fields = append(fields, field)
+		}
+	}
+
+	var stage DockerStage
+	if len(fields) > 0 {
+		stage.From = fields[0]
+	}
+	if len(fields) == 3 && strings.EqualFold(fields[1], "AS") {
+		stage.Name = fields[2]
+	}
+	return stage
+}
+
+// parseExpose reads EXPOSE port[/protocol]..., keeping TCP ports and the
+// first port of ranges.
+func parseExpose(args string) []int {
+	var ports []int
+	for _, spec := range strings.Fields(args) {
+		spec, protocol, _ := strings.Cut(spec, "/")
+		if protocol != "" && !strings.EqualFold(protocol, "tcp") {
+			continue
+		}
+		spec, _, _ = strings.Cut(spec, "-")
+		if port, err := strconv.Atoi(spec); err == nil && port > 0 {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// parseHealthcheck reads HEALTHCHECK NONE or HEALTHCHECK [options] CMD
+// command. Options that don't parse are left at docker's defaults.
+func parseHealthcheck(args string) *Healthcheck {
+	var check Healthcheck
+	for args != "" {
+		field, rest, _ := strings.Cut(args, " ")
+		if !strings.HasPrefix(field, "--") {
+			break
+		}
+		args = rest
+
+		option, value, _ := strings.Cut(strings.TrimPrefix(field, "--"), "=")
+		switch option {
+		case "interval":
+			check.Interval, _ = time.ParseDuration(value)
+		case "timeout":
+			check.Timeout, _ = time.ParseDuration(value)
+		case "start-period":
+			check.StartPeriod, _ = time.ParseDuration(value)
+		case "retries":
+			check.Retries, _ = strconv.Atoi(value)
+		}
+	}
+
+	keyword, command, _ := strings.Cut(args, " ")
+	if strings.EqualFold(keyword, "NONE") {
+		return &Healthcheck{Disabled: true}
+	}
+	check.Command = strings.TrimSpace(command)
+	return &check
+}
+
+// Names returns the names of the named stages, in order.
+func (f *Dockerfile) Names() []string {
+	var names []string
+	for _, stage := range f.Stages {
+		if stage.Name != "" {
+			names = append(names, stage.Name)
+		}
+	}
+	return names
+}
+
+// Stage returns the stage docker builds for target, the last one if target
+// is "", with the ports and health check it gets from earlier stages it's
+// built on.
+func (f *Dockerfile) Stage(target string) (DockerStage, bool) {
+	index := len(f.Stages) - 1
+	if target != "" {
+		index = f.stageIndex(target, len(f.Stages))
+	}
+	if index < 0 {
+		return DockerStage{}, false
+	}
+	return f.resolve(index), true
+}
+
+// stageIndex finds the stage a FROM or --target refers to among the first
+// n stages, by name or by number, or returns -1.
+func (f *Dockerfile) stageIndex(ref string, n int) int {
+	for i := 0; i < n; i++ {
+		if f.Stages[i].Name != "" && strings.EqualFold(f.Stages[i].Name, ref) {
+			return i
+		}
+	}
+	if i, err := strconv.Atoi(ref); err == nil && i >= 0 && i < n {
+		return i
+	}
+	return -1
+}
+
+func (f *Dockerfile) resolve(index int) DockerStage {
+	stage := f.Stages[index]
+	base := f.stageIndex(stage.From, index)
+	if base < 0 {
+		return stage
+	}
+
+	parent := f.resolve(base)
+	stage.Expose = append(append([]int(nil), parent.Expose...), stage.Expose...)
+	if stage.Healthcheck == nil {
+		stage.Healthcheck = parent.Healthcheck
+	}
+	return stage
+}
@@ -0,0 +1,112 @@
+package detector
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDockerfile(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		target     string
+		wantNames  []string
+		want       DockerStage
+	}{
+		{
+			name:       "single stage",
+			dockerfile: "FROM node:20\nEXPOSE 8080\nCMD [\"node\", \"server.js\"]\n",
+			want:       DockerStage{From: "node:20", Expose: []int{8080}},
+		},
+		{
+			name: "comments, continuations and lowercase",
+			dockerfile: "# syntax=docker/dockerfile:1\nfrom --platform=$BUILDPLATFORM golang:1.22 as build\n" +
+				"RUN go build \\\n  # a comment inside\n  -o /app .\nexpose 9000/tcp \\\n  53/udp $PORT 7000-7010\n",
+			wantNames: []string{"build"},
+			want:      DockerStage{Name: "build", From: "golang:1.22", Expose: []int{9000, 7000}},
+		},
+		{
+			name: "multi-stage inherits from named stages",
+			dockerfile: "FROM node:20 AS base\nEXPOSE 3000\nHEALTHCHECK --interval=10s --retries=5 CMD curl -f http://localhost:3000/ || exit 1\n" +
+				"FROM base AS build\nRUN npm run build\n" +
+				"FROM build AS release\nEXPOSE 3001\n",
+			wantNames: []string{"base", "build", "release"},
+			want: DockerStage{Name: "release", From: "build", Expose: []int{3000, 3001}, Healthcheck: &Healthcheck{
+				Command: "curl -f http://localhost:3000/ || exit 1", Interval: 10 * time.Second, Retries: 5,
+			}},
+		},
+		{
+			name:       "target stage",
+			dockerfile: "FROM golang:1.22 AS dev\nEXPOSE 4000\nFROM gcr.io/distroless/static AS prod\nEXPOSE 8080\n",
+			target:     "dev",
+			wantNames:  []string{"dev", "prod"},
+			want:       DockerStage{Name: "dev", From: "golang:1.22", Expose: []int{4000}},
+		},
+		{
+			name:       "healthcheck turned off",
+			dockerfile: "FROM nginx AS web\nHEALTHCHECK CMD true\nFROM web\nHEALTHCHECK NONE\n",
+			wantNames:  []string{"web"},
+			want:       DockerStage{From: "web", Healthcheck: &Healthcheck{Disabled: true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "Dockerfile")
+			if err := os.WriteFile(path, []byte(tt.dockerfile), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			dockerfile, err := ParseDockerfile(path)
+			if err != nil {
+				t.Fatalf("ParseDockerfile() error = %v", err)
+			}
+			if names := dockerfile.Names(); !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("Names() = %v, want %v", names, tt.wantNames)
+			}
+			stage, ok := dockerfile.Stage(tt.target)
+			if !ok {
+				t.Fatalf("Stage(%q) not found", tt.target)
+			}
+			if !reflect.DeepEqual(stage, tt.want) {
+				t.Errorf("Stage(%q) = %+v, want %+v", tt.target, stage, tt.want)
+			}
+		})
+	}
+
+	dockerfile := &Dockerfile{Stages: []DockerStage{{Name: "build"}}}
+	if _, ok := dockerfile.Stage("missing"); ok {
+		t.Error("Stage() found a stage that doesn't exist")
+	}
+}
+
+func TestDockerDetectorDockerfileMetadata(t *testing.T) {
+	dir := t.TempDir()
+	dockerfile := "FROM node:20 AS deps\nFROM node:20-slim AS app\nEXPOSE 8080 9229\nHEALTHCHECK CMD wget -qO- localhost:8080\n"
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	detection, err := (&DockerDetector{}).Detect(dir)
+	if err != nil || detection == nil {
+		t.Fatalf("Detect() = %v, %v", detection, err)
+	}
+	if detection.Port != 8080 {
+		t.Errorf("Port = %d, want the first exposed port 8080", detection.Port)
+	}
+	if detection.BuildCmd != "" || detection.StartCmd != "" {
+		t.Errorf("commands = %q, %q, want none", detection.BuildCmd, detection.StartCmd)
+	}
+	want := map[string]interface{}{
+		"dockerfile":  "Dockerfile",
+		"stages":      []string{"deps", "app"},
+		"expose":      []int{8080, 9229},
+		"healthcheck": "wget -qO- localhost:8080",
+	}
+	if !reflect.DeepEqual(detection.Metadata, want) {
+		t.Errorf("Metadata = %v, want %v", detection.Metadata, want)
+	}
+}
@@ -0,0 +1,113 @@
+package detector
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Result is what one detector made of a project.
+type Result struct {
+	Detector  string
+	Priority  int
+	Detection *Detection // nil if it didn't match
+	Error     string
+}
+
+// Explanation is how a project was detected: what every detector found, in
+// the order they ran, and which detection was picked.
+type Explanation struct {
+	Source  string // what was looked at, a directory or owner/repo@sha
+	Results []Result
+	Chosen  int // index into Results, -1 if nothing matched
+}
+
+// Explain runs every detector against the project at projectPath.
+func (m *Manager) Explain(projectPath string) *Explanation {
+	explanation := &Explanation{Source: projectPath, Chosen: -1}
+	var highestConfidence float32
+
+	for i, detector := range m.detectors {
+		result := Result{Detector: detector.Name(), Priority: detector.Priority()}
+		detection, err := detector.Detect(projectPath)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Detection = detection
+		}
+		explanation.Results = append(explanation.Results, result)
+
+		// Detectors run in priority order, so the first one to reach a
+		// confidence keeps it
+		if detection != nil && err == nil && detection.Confidence > highestConfidence {
+			highestConfidence = detection.Confidence
+			explanation.Chosen = i
+		}
+	}
+	return explanation
+}
+
+// Detection returns the detection that was picked, or one of TypeUnknown.
+func (e *Explanation) Detection() *Detection {
+	if e.Chosen < 0 || e.Chosen >= len(e.Results) {
+		return &Detection{
+			Type:       TypeUnknown,
+			Confidence: 0,
+		}
+	}
+	return e.Results[e.Chosen].Detection
+}
+
+// Write prints the explanation for people, one detector after the other
+// with the one that was picked marked by a *.
+func (e *Explanation) Write(w io.Writer) {
+	fmt.Fprintf(w, "Detectors run against %s, by priority:\n", e.Source)
+
+	width := 0
+	for _, result := range e.Results {
+		width = max(width, len(result.Detector))
+	}
+	for i, result := range e.Results {
+		mark := " "
+		if i == e.Chosen {
+			mark = "*"
+		}
+		fmt.Fprintf(w, "%s %-*s  priority %-3d  ", mark, width, result.Detector, result.Priority)
+
+		detection := result.Detection
+		switch {
+		case result.Error != "":
+			fmt.Fprintf(w, "error: %s\n", result.Error)
+			continue
+		case detection == nil:
+			fmt.Fprintf(w, "no match\n")
+			continue
+		}
+		fmt.Fprintf(w, "%s, confidence %.2f\n", detection.Type, detection.Confidence)
+
+		indent := strings.Repeat(" ", width+4)
+		if len(detection.Evidence) > 0 {
+			fmt.Fprintf(w, "%sevidence: %s\n", indent, strings.Join(detection.Evidence, "; "))
+		}
+		if detection.InstallCmd != "" {
+			fmt.Fprintf(w, "%sinstall:  %s\n", indent, detection.InstallCmd)
+		}
+		fmt.Fprintf(w, "%sbuild:    %s\n", indent, orNone(detection.BuildCmd))
+		fmt.Fprintf(w, "%sstart:    %s\n", indent, orNone(detection.StartCmd))
+		fmt.Fprintf(w, "%sport:     %d\n", indent, detection.Port)
+	}
+
+	if e.Chosen < 0 {
+		fmt.Fprintf(w, "No detector matched, the project type is %s\n", TypeUnknown)
+		return
+	}
+	chosen := e.Results[e.Chosen]
+	fmt.Fprintf(w, "Picked %s from the %s detector (confidence %.2f)\n", chosen.Detection.Type, chosen.Detector, chosen.Detection.Confidence)
+}
+
+func orNone(command string) string {
+	if command == "" {
+		return "(none)"
+	}
+	return command
+}
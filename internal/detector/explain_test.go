@@ -0,0 +1,66 @@
+package detector
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"go.mod":     "module example.com/app\n",
+		"index.html": "<h1>hi</h1>",
+		"VERSION":    "one",
+	})
+	broken, err := NewCustomDetector(Spec{Name: "broken", Match: []Rule{{File: "VERSION"}}, Start: "{{.Match.port.number}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	explanation := NewManager(broken).Explain(dir)
+	var order []string
+	for _, result := range explanation.Results {
+		order = append(order, result.Detector)
+	}
+	if want := []string{"broken", "docker", "nuxt", "go", "rust", "node", "python", "static"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("detectors ran in order %v, want %v", order, want)
+	}
+	if explanation.Detection().Type != TypeGo {
+		t.Errorf("Detection() = %s, want go", explanation.Detection().Type)
+	}
+
+	var out bytes.Buffer
+	explanation.Write(&out)
+	for _, want := range []string{
+		`  broken  priority 200  error: detector broken: template: start:1:8: executing "start" at <.Match.port.number>: can't evaluate field number in type string`,
+		"* go      priority 85   go, confidence 1.00\n          evidence: go.mod exists\n          build:    go build -o app\n          start:    ./app\n          port:     8080\n",
+		"  static  priority 50   static, confidence 0.70\n",
+		"Picked go from the go detector (confidence 1.00)",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Write() output doesn't contain %q:\n%s", want, out.String())
+		}
+	}
+
+	// The admin API and `dockrune detect --json` pass it around as JSON
+	data, err := json.Marshal(explanation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Explanation
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Chosen != explanation.Chosen || decoded.Detection().StartCmd != "./app" {
+		t.Errorf("decoded explanation = %+v", decoded)
+	}
+
+	nothing := NewManager().Explain(t.TempDir())
+	out.Reset()
+	nothing.Write(&out)
+	if nothing.Detection().Type != TypeUnknown || !strings.Contains(out.String(), "No detector matched") {
+		t.Errorf("empty project explained as:\n%s", out.String())
+	}
+}
@@ -0,0 +1,140 @@
+package detector
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Node package managers.
+const (
+	PackageManagerNPM  = "npm"
+	PackageManagerPNPM = "pnpm"
+	PackageManagerYarn = "yarn"
+	PackageManagerBun  = "bun"
+)
+
+// lockfiles maps each lockfile to the package manager that writes it, in
+// the order they're looked for when package.json doesn't say.
+var lockfiles = []struct{ name, manager string }{
+	{"pnpm-lock.yaml", PackageManagerPNPM},
+	{"yarn.lock", PackageManagerYarn},
+	{"bun.lockb", PackageManagerBun},
+	{"bun.lock", PackageManagerBun},
+	{"package-lock.json", PackageManagerNPM},
+	{"npm-shrinkwrap.json", PackageManagerNPM},
+}
+
+// PackageManager is the tool a Node project installs its dependencies and
+// runs its scripts with.
+type PackageManager struct {
+	Name     string // npm, pnpm, yarn or bun
+	Version  string // from package.json's packageManager, "" if not pinned
+	Lockfile string // "" if the project has none for Name
+	Berry    bool   // yarn 2 or later
+}
+
+// DetectPackageManager works out the package manager of the project at
+// projectPath: the one package.json's packageManager field names, else
+// the one whose lockfile is there, else npm. It returns what it went by.
+func DetectPackageManager(projectPath string, pkg map[string]interface{}) (PackageManager, []string) {
+	var pm PackageManager
+	var evidence []string
+
+	if field, _ := pkg["packageManager"].(string); field != "" {
+		// name@version, optionally followed by +sha...
+		name, version, _ := strings.Cut(field, "@")
+		version, _, _ = strings.Cut(version, "+")
+		switch name {
+		case PackageManagerNPM, PackageManagerPNPM, PackageManagerYarn, PackageManagerBun:
+			pm = PackageManager{Name: name, Version: version}
+			evidence = append(evidence, "package.json packageManager is "+field)
+		}
+	}
+
+	for _, lockfile := range lockfiles {
+		if pm.Name != "" && lockfile.manager != pm.Name {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(projectPath, lockfile.name)); err == nil {
+			pm.Name = lockfile.manager
+			pm.Lockfile = lockfile.name
+			evidence = append(evidence, lockfile.name+" exists")
+			break
+		}
+	}
+	if pm.Name == "" {
+		pm.Name = PackageManagerNPM
+	}
+
+	if pm.Name == PackageManagerYarn {
+		pm.Berry = isYarnBerry(projectPath, pm)
+	}
+	return pm, evidence
+}
+
+// isYarnBerry tells yarn 2+ from yarn 1 by the pinned version, or else by
+// its lockfile, which unlike yarn 1's is YAML with a __metadata entry, or
+// its .yarnrc.yml.
+func isYarnBerry(projectPath string, pm PackageManager) bool {
+	if pm.Version != "" {
+		return !strings.HasPrefix(pm.Version, "1.")
+	}
+	if pm.Lockfile != "" {
+		data, err := os.ReadFile(filepath.Join(projectPath, pm.Lockfile))
+		if err == nil {
+			return bytes.Contains(data, []byte("\n__metadata:"))
+		}
+	}
+	_, err := os.Stat(filepath.Join(projectPath, ".yarnrc.yml"))
+	return err == nil
+}
+
+// String is the package manager as recorded in Metadata, e.g. "yarn
+// (berry)".
+func (pm PackageManager) String() string {
+	if pm.Name == PackageManagerYarn {
+		if pm.Berry {
+			return "yarn (berry)"
+		}
+		return "yarn (classic)"
+	}
+	return pm.Name
+}
+
+// Install installs the project's dependencies, exactly as locked if there
+// is a lockfile.
+func (pm PackageManager) Install() string {
+	if pm.Lockfile == "" {
+		return pm.Name + " install"
+	}
+	switch pm.Name {
+	case PackageManagerNPM:
+		return "npm ci"
+	case PackageManagerYarn:
+		if pm.Berry {
+			return "yarn install --immutable"
+		}
+		return "yarn install --frozen-lockfile"
+	default:
+		return pm.Name + " install --frozen-lockfile"
+	}
+}
+
+// Run runs a package.json script.
+func (pm PackageManager) Run(script string) string {
+	return pm.Name + " run " + script
+}
+
+// metadata records the package manager in a detection's metadata.
+func (pm PackageManager) metadata(metadata map[string]interface{}) map[string]interface{} {
+	metadata["package_manager"] = pm.String()
+	if pm.Version != "" {
+		metadata["package_manager_version"] = pm.Version
+	}
+	if pm.Lockfile != "" {
+		metadata["lockfile"] = pm.Lockfile
+	}
+	return metadata
+}
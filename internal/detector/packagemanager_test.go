@@ -0,0 +1,144 @@
+package detector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDetectPackageManager(t *testing.T) {
+	berryLock := "# This file is generated by running \"yarn install\"\n\n__metadata:\n  version: 6\n"
+	tests := []struct {
+		name        string
+		files       map[string]string
+		pkg         map[string]interface{}
+		want        PackageManager
+		wantInstall string
+		wantString  string
+	}{
+		{
+			name:        "no lockfile",
+			want:        PackageManager{Name: PackageManagerNPM},
+			wantInstall: "npm install",
+			wantString:  "npm",
+		},
+		{
+			name:        "npm",
+			files:       map[string]string{"package-lock.json": "{}"},
+			want:        PackageManager{Name: PackageManagerNPM, Lockfile: "package-lock.json"},
+			wantInstall: "npm ci",
+			wantString:  "npm",
+		},
+		{
+			name:        "pnpm",
+			files:       map[string]string{"pnpm-lock.yaml": "lockfileVersion: '9.0'\n"},
+			want:        PackageManager{Name: PackageManagerPNPM, Lockfile: "pnpm-lock.yaml"},
+			wantInstall: "pnpm install --frozen-lockfile",
+			wantString:  "pnpm",
+		},
+		{
+			name:        "yarn classic",
+			files:       map[string]string{"yarn.lock": "# yarn lockfile v1\n\nleft-pad@^1.3.0:\n  version \"1.3.0\"\n"},
+			want:        PackageManager{Name: PackageManagerYarn, Lockfile: "yarn.lock"},
+			wantInstall: "yarn install --frozen-lockfile",
+			wantString:  "yarn (classic)",
+		},
+		{
+			name:        "yarn berry",
+			files:       map[string]string{"yarn.lock": berryLock},
+			want:        PackageManager{Name: PackageManagerYarn, Lockfile: "yarn.lock", Berry: true},
+			wantInstall: "yarn install --immutable",
+			wantString:  "yarn (berry)",
+		},
+		{
+			name:        "bun",
+			files:       map[string]string{"bun.lockb": "\x00"},
+			want:        PackageManager{Name: PackageManagerBun, Lockfile: "bun.lockb"},
+			wantInstall: "bun install --frozen-lockfile",
+			wantString:  "bun",
+		},
+		{
+			name:        "packageManager wins over a stray lockfile",
+			files:       map[string]string{"package-lock.json": "{}", "pnpm-lock.yaml": "lockfileVersion: '9.0'\n"},
+			pkg:         map[string]interface{}{"packageManager": "pnpm@9.1.0+sha512.abc"},
+			want:        PackageManager{Name: PackageManagerPNPM, Version: "9.1.0", Lockfile: "pnpm-lock.yaml"},
+			wantInstall: "pnpm install --frozen-lockfile",
+			wantString:  "pnpm",
+		},
+		{
+			name:        "packageManager without its lockfile",
+			files:       map[string]string{"package-lock.json": "{}"},
+			pkg:         map[string]interface{}{"packageManager": "yarn@4.1.1"},
+			want:        PackageManager{Name: PackageManagerYarn, Version: "4.1.1", Berry: true},
+			wantInstall: "yarn install",
+			wantString:  "yarn (berry)",
+		},
+		{
+			name:        "unknown packageManager",
+			files:       map[string]string{"yarn.lock": "# yarn lockfile v1\n"},
+			pkg:         map[string]interface{}{"packageManager": "deno@2.0.0"},
+			want:        PackageManager{Name: PackageManagerYarn, Lockfile: "yarn.lock"},
+			wantInstall: "yarn install --frozen-lockfile",
+			wantString:  "yarn (classic)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeFiles(t, tt.files)
+
+			pm, _ := DetectPackageManager(dir, tt.pkg)
+			if pm != tt.want {
+				t.Errorf("DetectPackageManager() = %+v, want %+v", pm, tt.want)
+			}
+			if install := pm.Install(); install != tt.wantInstall {
+				t.Errorf("Install() = %q, want %q", install, tt.wantInstall)
+			}
+			if s := pm.String(); s != tt.wantString {
+				t.Errorf("String() = %q, want %q", s, tt.wantString)
+			}
+		})
+	}
+}
+
+func TestNodeDetectorPackageManager(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"package.json": `{"packageManager": "yarn@1.22.22", "scripts": {"build": "tsc", "start": "node dist"}}`,
+		"yarn.lock":    "# yarn lockfile v1\n",
+	})
+
+	detection, err := (&NodeDetector{}).Detect(dir)
+	if err != nil || detection == nil {
+		t.Fatalf("Detect() = %v, %v", detection, err)
+	}
+	if detection.InstallCmd != "yarn install --frozen-lockfile" || detection.BuildCmd != "yarn run build" || detection.StartCmd != "yarn run start" {
+		t.Errorf("commands = %q, %q, %q", detection.InstallCmd, detection.BuildCmd, detection.StartCmd)
+	}
+	wantEvidence := []string{"package.json exists", "package.json packageManager is yarn@1.22.22", "yarn.lock exists"}
+	if !reflect.DeepEqual(detection.Evidence, wantEvidence) {
+		t.Errorf("Evidence = %q, want %q", detection.Evidence, wantEvidence)
+	}
+	wantMetadata := map[string]interface{}{
+		"framework":               "generic",
+		"package_manager":         "yarn (classic)",
+		"package_manager_version": "1.22.22",
+		"lockfile":                "yarn.lock",
+	}
+	if !reflect.DeepEqual(detection.Metadata, wantMetadata) {
+		t.Errorf("Metadata = %v, want %v", detection.Metadata, wantMetadata)
+	}
+
+	nuxt := writeFiles(t, map[string]string{
+		"package.json":   `{"devDependencies": {"nuxt": "^3.12.0"}}`,
+		"pnpm-lock.yaml": "lockfileVersion: '9.0'\n",
+	})
+	detection, err = (&NuxtDetector{}).Detect(nuxt)
+	if err != nil || detection == nil {
+		t.Fatalf("Detect() = %v, %v", detection, err)
+	}
+	if detection.InstallCmd != "pnpm install --frozen-lockfile" || detection.BuildCmd != "pnpm run build" {
+		t.Errorf("commands = %q, %q", detection.InstallCmd, detection.BuildCmd)
+	}
+	if detection.Metadata["package_manager"] != "pnpm" {
+		t.Errorf("Metadata = %v, want pnpm recorded", detection.Metadata)
+	}
+}
@@ -0,0 +1,186 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Forwarder owns stable listening ports and forwards each accepted TCP
+// connection to the port currently behind it. Switching the backend is
+// atomic: connections accepted after Route returns go to the new backend,
+// connections already open keep talking to the old one until they close.
+type Forwarder struct {
+	mu     sync.Mutex
+	routes map[int]*route
+	conns  map[int]*atomic.Int64 // open connections per backend port
+}
+
+type route struct {
+	listener net.Listener
+	backend  atomic.Int64
+}
+
+func New() *Forwarder {
+	return &Forwarder{
+		routes: make(map[int]*route),
+		conns:  make(map[int]*atomic.Int64),
+	}
+}
+
+// Route makes listenPort forward to backendPort, starting a listener on
+// listenPort if there isn't one yet.
+func (f *Forwarder) Route(listenPort, backendPort int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r, ok := f.routes[listenPort]; ok {
+		r.backend.Store(int64(backendPort))
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", listenPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w", listenPort, err)
+	}
+
+	r := &route{listener: ln}
+	r.backend.Store(int64(backendPort))
+	f.routes[listenPort] = r
+
+	go f.serve(r)
+	return nil
+}
+
+// Backend returns the port listenPort currently forwards to, or 0.
+func (f *Forwarder) Backend(listenPort int) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r, ok := f.routes[listenPort]; ok {
+		return int(r.backend.Load())
+	}
+	return 0
+}
+
+// Remove stops listening on listenPort. Open connections are left to finish.
+func (f *Forwarder) Remove(listenPort int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r, ok := f.routes[listenPort]; ok {
+		r.listener.Close()
+		delete(f.routes, listenPort)
+	}
+}
+
+// Drain waits until no forwarded connections to backendPort are open, or
+// until timeout passes. It reports whether the backend drained fully.
+func (f *Forwarder) Drain(backendPort int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if f.openConns(backendPort) == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Close stops every listener.
+func (f *Forwarder) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for port, r := range f.routes {
+		r.listener.Close()
+		delete(f.routes, port)
+	}
+}
+
+func (f *Forwarder) openConns(backendPort int) int64 {
+	f.mu.Lock()
+	counter, ok := f.conns[backendPort]
+	f.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	return counter.Load()
+}
+
+func (f *Forwarder) counter(backendPort int) *atomic.Int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	counter, ok := f.conns[backendPort]
+	if !ok {
+		counter = new(atomic.Int64)
+		f.conns[backendPort] = counter
+	}
+	return counter
+}
+
+// serve accepts connections until the listener is closed by Remove or
+// Close. Other accept errors, like running out of file descriptors, are
+// waited out with a growing delay, as net/http does.
+func (f *Forwarder) serve(r *route) {
+	var delay time.Duration
+	for {
+		client, err := r.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else {
+				delay = min(2*delay, time.Second)
+			}
+			log.Printf("Forwarder: accept error on %s: %v; retrying in %s", r.listener.Addr(), err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		go f.forward(client, int(r.backend.Load()))
+	}
+}
+
+func (f *Forwarder) forward(client net.Conn, backendPort int) {
+	defer client.Close()
+
+	counter := f.counter(backendPort)
+	counter.Add(1)
+	defer counter.Add(-1)
+
+	backend, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", backendPort), 5*time.Second)
+	if err != nil {
+		log.Printf("Forwarder: failed to reach backend port %d: %v", backendPort, err)
+		return
+	}
+	defer backend.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(backend, client)
+		if c, ok := backend.(*net.TCPConn); ok {
+			c.CloseWrite()
+		}
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, backend)
+		if c, ok := client.(*net.TCPConn); ok {
+			c.CloseWrite()
+		}
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
@@ -0,0 +1,178 @@
+package forwarder
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// echoServer answers every line with "<name>: <line>".
+func echoServer(t *testing.T, name string) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					fmt.Fprintf(conn, "%s: %s\n", name, scanner.Text())
+				}
+			}(conn)
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func ask(t *testing.T, conn net.Conn, line string) string {
+	fmt.Fprintf(conn, "%s\n", line)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	return reply
+}
+
+func TestRouteAndSwap(t *testing.T) {
+	blue := echoServer(t, "blue")
+	green := echoServer(t, "green")
+	stable := freePort(t)
+
+	f := New()
+	defer f.Close()
+
+	if err := f.Route(stable, blue); err != nil {
+		t.Fatalf("Route() error = %v", err)
+	}
+
+	oldConn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", stable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer oldConn.Close()
+	if got := ask(t, oldConn, "hi"); got != "blue: hi\n" {
+		t.Errorf("reply = %q, want blue", got)
+	}
+
+	if err := f.Route(stable, green); err != nil {
+		t.Fatalf("Route() swap error = %v", err)
+	}
+	if f.Backend(stable) != green {
+		t.Errorf("Backend() = %d, want %d", f.Backend(stable), green)
+	}
+
+	newConn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", stable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer newConn.Close()
+	if got := ask(t, newConn, "hi"); got != "green: hi\n" {
+		t.Errorf("reply = %q, want green", got)
+	}
+
+	// The connection opened before the swap still talks to blue
+	if got := ask(t, oldConn, "still there?"); got != "blue: still there?\n" {
+		t.Errorf("reply = %q, want blue", got)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	blue := echoServer(t, "blue")
+	green := echoServer(t, "green")
+	stable := freePort(t)
+
+	f := New()
+	defer f.Close()
+	f.Route(stable, blue)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", stable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ask(t, conn, "hi")
+
+	f.Route(stable, green)
+	if f.Drain(blue, 200*time.Millisecond) {
+		t.Error("Drain() = true with a connection still open")
+	}
+
+	conn.Close()
+	if !f.Drain(blue, 2*time.Second) {
+		t.Error("Drain() = false after the connection closed")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	blue := echoServer(t, "blue")
+	stable := freePort(t)
+
+	f := New()
+	f.Route(stable, blue)
+	f.Remove(stable)
+
+	if f.Backend(stable) != 0 {
+		t.Errorf("Backend() = %d after Remove(), want 0", f.Backend(stable))
+	}
+	if _, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", stable), time.Second); err == nil {
+		t.Error("Expected dial to fail after Remove()")
+	}
+}
+
+// flakyListener fails its first accepts, like a listener out of file
+// descriptors.
+type flakyListener struct {
+	net.Listener
+	failures int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, errors.New("accept: too many open files")
+	}
+	return l.Listener.Accept()
+}
+
+func TestServeSurvivesAcceptErrors(t *testing.T) {
+	blue := echoServer(t, "blue")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	r := &route{listener: &flakyListener{Listener: ln, failures: 3}}
+	r.backend.Store(int64(blue))
+	go New().serve(r)
+
+	conn, err := net.DialTimeout("tcp", ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if reply := ask(t, conn, "hi"); reply != "blue: hi\n" {
+		t.Errorf("reply = %q after accept errors, want blue", reply)
+	}
+}
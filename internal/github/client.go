@@ -81,15 +81,51 @@ func (c *Client) CreateCommitStatus(owner, repo, sha, state, targetURL, descript
 	return nil
 }
 
-func (c *Client) AddPRComment(owner, repo string, prNumber int, body string) error {
+// AddPRComment comments on a pull request and returns the comment's ID.
+func (c *Client) AddPRComment(owner, repo string, prNumber int, body string) (int64, error) {
 	comment := &github.IssueComment{
 		Body: github.String(body),
 	}
 
-	_, _, err := c.client.Issues.CreateComment(c.ctx, owner, repo, prNumber, comment)
+	created, _, err := c.client.Issues.CreateComment(c.ctx, owner, repo, prNumber, comment)
 	if err != nil {
-		return fmt.Errorf("failed to add PR comment: %w", err)
+		return 0, fmt.Errorf("failed to add PR comment: %w", err)
+	}
+
+	return created.GetID(), nil
+}
+
+func (c *Client) EditPRComment(owner, repo string, commentID int64, body string) error {
+	comment := &github.IssueComment{
+		Body: github.String(body),
+	}
+
+	_, _, err := c.client.Issues.EditComment(c.ctx, owner, repo, commentID, comment)
+	if err != nil {
+		return fmt.Errorf("failed to edit PR comment: %w", err)
 	}
 
 	return nil
 }
+
+// PullRequest is the part of a pull request dockrune deploys from.
+type PullRequest struct {
+	Number  int
+	State   string // open or closed
+	HeadRef string
+	HeadSHA string
+}
+
+func (c *Client) GetPullRequest(owner, repo string, prNumber int) (*PullRequest, error) {
+	pr, _, err := c.client.PullRequests.Get(c.ctx, owner, repo, prNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pull request: %w", err)
+	}
+
+	return &PullRequest{
+		Number:  pr.GetNumber(),
+		State:   pr.GetState(),
+		HeadRef: pr.GetHead().GetRef(),
+		HeadSHA: pr.GetHead().GetSHA(),
+	}, nil
+}
@@ -0,0 +1,201 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+const (
+	TypeHTTP    = "http"
+	TypeTCP     = "tcp"
+	TypeCommand = "command"
+	TypeNone    = "none"
+)
+
+const (
+	DefaultTimeout  = 5 * time.Second
+	DefaultRetries  = 10
+	DefaultInterval = 2 * time.Second
+)
+
+// Check describes how to decide that a freshly started app is healthy.
+// Zero values fall back to the defaults above; an empty Type means TCP,
+// or HTTP if a Path is set.
+type Check struct {
+	Type           string
+	Path           string
+	ExpectedStatus int // 0 accepts any 2xx or 3xx
+	Command        string
+	Timeout        time.Duration // per attempt
+	Retries        int
+	Interval       time.Duration
+
+	// Dir and Env are used for command checks
+	Dir string
+	Env []string
+}
+
+// Run probes the app on host:port until the check passes or it runs out of
+// retries. Every attempt is written to w.
+func (c Check) Run(ctx context.Context, host string, port int, w io.Writer) error {
+	c = c.withDefaults()
+	if c.Type == TypeNone {
+		fmt.Fprintf(w, "Health check disabled\n")
+		return nil
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= c.Retries; attempt++ {
+		lastErr = c.probe(ctx, host, port)
+		if lastErr == nil {
+			fmt.Fprintf(w, "Health check passed (%s, attempt %d/%d)\n", c.describe(port), attempt, c.Retries)
+			return nil
+		}
+		fmt.Fprintf(w, "Health check attempt %d/%d failed: %v\n", attempt, c.Retries, lastErr)
+
+		if attempt == c.Retries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.Interval):
+		}
+	}
+
+	return fmt.Errorf("health check %s failed after %d attempts: %w", c.describe(port), c.Retries, lastErr)
+}
+
+func (c Check) withDefaults() Check {
+	if c.Type == "" {
+		c.Type = TypeTCP
+		if c.Path != "" {
+			c.Type = TypeHTTP
+		}
+	}
+	if c.Type == TypeHTTP && c.Path == "" {
+		c.Path = "/"
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+	if c.Retries <= 0 {
+		c.Retries = DefaultRetries
+	}
+	if c.Interval <= 0 {
+		c.Interval = DefaultInterval
+	}
+	return c
+}
+
+func (c Check) describe(port int) string {
+	switch c.Type {
+	case TypeHTTP:
+		return fmt.Sprintf("GET :%d%s", port, c.Path)
+	case TypeCommand:
+		return fmt.Sprintf("command %q", c.Command)
+	default:
+		return fmt.Sprintf("tcp :%d", port)
+	}
+}
+
+func (c Check) probe(ctx context.Context, host string, port int) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	switch c.Type {
+	case TypeHTTP:
+		return c.probeHTTP(ctx, host, port)
+	case TypeTCP:
+		return probeTCP(ctx, host, port)
+	case TypeCommand:
+		return c.probeCommand(ctx)
+	default:
+		return fmt.Errorf("unknown health check type %q", c.Type)
+	}
+}
+
+func (c Check) probeHTTP(ctx context.Context, host string, port int) error {
+	url := fmt.Sprintf("http://%s%s", net.JoinHostPort(host, fmt.Sprint(port)), c.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	// Don't follow redirects; a 3xx means the app is up
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if c.ExpectedStatus != 0 {
+		if resp.StatusCode != c.ExpectedStatus {
+			return fmt.Errorf("got status %d, want %d", resp.StatusCode, c.ExpectedStatus)
+		}
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("got status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func probeTCP(ctx context.Context, host string, port int) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, fmt.Sprint(port)))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (c Check) probeCommand(ctx context.Context) error {
+	if c.Command == "" {
+		return errors.New("no health check command configured")
+	}
+
+	// Kill the whole process group on timeout, so a background child can't
+	// keep the output pipe open and Wait from returning
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second
+	cmd.Dir = c.Dir
+	cmd.Env = c.Env
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if len(out) > 0 {
+			return fmt.Errorf("%w: %s", err, lastLine(out))
+		}
+		return err
+	}
+	return nil
+}
+
+func lastLine(out []byte) string {
+	end := len(out)
+	for end > 0 && (out[end-1] == '\n' || out[end-1] == '\r') {
+		end--
+	}
+	start := end
+	for start > 0 && out[start-1] != '\n' {
+		start--
+	}
+	return string(out[start:end])
+}
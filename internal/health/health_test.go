@@ -0,0 +1,133 @@
+package health
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func serverPort(t *testing.T, srv *httptest.Server) int {
+	_, portStr, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(portStr)
+	return port
+}
+
+func TestHTTPCheck(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	port := serverPort(t, srv)
+
+	tests := []struct {
+		name    string
+		check   Check
+		wantErr bool
+	}{
+		{
+			name:  "healthy path",
+			check: Check{Type: TypeHTTP, Path: "/healthz", Retries: 1},
+		},
+		{
+			name:  "expected status",
+			check: Check{Type: TypeHTTP, Path: "/healthz", ExpectedStatus: http.StatusNoContent, Retries: 1},
+		},
+		{
+			name:    "wrong status",
+			check:   Check{Type: TypeHTTP, Path: "/healthz", ExpectedStatus: http.StatusOK, Retries: 1},
+			wantErr: true,
+		},
+		{
+			name:    "server error",
+			check:   Check{Path: "/broken", Retries: 2, Interval: time.Millisecond},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.check.Run(context.Background(), "127.0.0.1", port, io.Discard)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHTTPCheckRetriesUntilHealthy(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	check := Check{Type: TypeHTTP, Retries: 5, Interval: time.Millisecond}
+	if err := check.Run(context.Background(), "127.0.0.1", serverPort(t, srv), io.Discard); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d, want 3", calls)
+	}
+}
+
+func TestTCPCheck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	check := Check{Retries: 1}
+	if err := check.Run(context.Background(), "127.0.0.1", port, io.Discard); err != nil {
+		t.Errorf("Run() against open port error = %v", err)
+	}
+
+	ln.Close()
+	if err := check.Run(context.Background(), "127.0.0.1", port, io.Discard); err == nil {
+		t.Error("Expected error against closed port")
+	}
+}
+
+func TestCommandCheck(t *testing.T) {
+	if err := (Check{Type: TypeCommand, Command: "true", Retries: 1}).Run(context.Background(), "127.0.0.1", 0, io.Discard); err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+	if err := (Check{Type: TypeCommand, Command: "false", Retries: 1}).Run(context.Background(), "127.0.0.1", 0, io.Discard); err == nil {
+		t.Error("Expected error from failing command")
+	}
+}
+
+func TestCommandCheckKillsBackgroundChildren(t *testing.T) {
+	check := Check{Type: TypeCommand, Command: "sleep 30 & wait", Timeout: 200 * time.Millisecond, Retries: 1}
+
+	start := time.Now()
+	if err := check.Run(context.Background(), "127.0.0.1", 0, io.Discard); err == nil {
+		t.Fatal("command check passed, want a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("command check took %s to time out, want about 200ms", elapsed)
+	}
+}
+
+func TestNoneCheck(t *testing.T) {
+	if err := (Check{Type: TypeNone}).Run(context.Background(), "127.0.0.1", 1, io.Discard); err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
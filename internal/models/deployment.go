@@ -11,6 +11,9 @@ const (
 	StatusInProgress DeploymentStatus = "in_progress"
 	StatusSuccess    DeploymentStatus = "success"
 	StatusFailed     DeploymentStatus = "failed"
+	StatusCancelled  DeploymentStatus = "cancelled"
+	StatusSuperseded DeploymentStatus = "superseded"
+	StatusInactive   DeploymentStatus = "inactive" // succeeded, environment since torn down
 )
 
 type Deployment struct {
@@ -31,4 +34,91 @@ type Deployment struct {
 	Port               int
 	ProjectType        string
 	Error              string
+	RollbackOf         string // ID of the failed deployment this one rolled back
+	Slot               string // blue or green for blue/green deployments
+	Runtime            string // process, docker or compose
+}
+
+// DeploymentStep is one phase of a deployment (clone, build, start...).
+// Its output is the LogLength bytes at LogOffset in the deployment's log.
+type DeploymentStep struct {
+	ID           int64
+	DeploymentID string
+	Name         string
+	Status       DeploymentStatus // in_progress, success or failed
+	StartedAt    time.Time
+	CompletedAt  time.Time
+	ExitCode     *int // nil if the step failed without a command exiting
+	LogOffset    int64
+	LogLength    int64
+	Error        string
+}
+
+// PortLease reserves a host port for one owner/repo/environment so that the
+// environment keeps the same port across redeploys.
+type PortLease struct {
+	Owner       string
+	Repo        string
+	Environment string
+	Port        int
+	CreatedAt   time.Time
+}
+
+// EnvVar is an environment variable passed to a repo's build, start and
+// hook commands. An empty Environment applies it to every environment of
+// the repo; one set for a specific environment takes precedence.
+type EnvVar struct {
+	Owner       string
+	Repo        string
+	Environment string
+	Key         string
+	Value       string
+	UpdatedAt   time.Time
+}
+
+// Secret is a credential given to a repo's deployments. It is injected as
+// the environment variable Name, or written to Path (relative to the
+// deployment's working tree) if that is set. Like EnvVar, an empty
+// Environment applies it to every environment of the repo.
+type Secret struct {
+	Owner       string
+	Repo        string
+	Environment string
+	Name        string
+	Value       string `json:"-"` // never sent back out
+	Path        string
+	UpdatedAt   time.Time
+}
+
+// Preview tracks a running preview environment so that it can be stopped
+// once it has lived too long or gone too long without a push. TTL and
+// IdleTimeout are the limits in force at its last deployment; zero means
+// no limit.
+type Preview struct {
+	Owner       string
+	Repo        string
+	Environment string
+	PRNumber    int
+	CreatedAt   time.Time // first deployed, or woken up after expiring
+	LastPushAt  time.Time // last deployed
+	TTL         time.Duration
+	IdleTimeout time.Duration
+	WarnedAt    time.Time // expiry warning posted; zero if not yet
+	ExpiredAt   time.Time // stopped by the reaper; zero while running
+}
+
+// ExpiresAt is when the preview is due to be stopped, or the zero time if
+// it has no limits.
+func (p *Preview) ExpiresAt() time.Time {
+	var expires time.Time
+	if p.TTL > 0 {
+		expires = p.CreatedAt.Add(p.TTL)
+	}
+	if p.IdleTimeout > 0 {
+		idle := p.LastPushAt.Add(p.IdleTimeout)
+		if expires.IsZero() || idle.Before(expires) {
+			expires = idle
+		}
+	}
+	return expires
 }
@@ -0,0 +1,190 @@
+package ports
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sync"
+
+	"github.com/ejfox/dockrune/internal/models"
+	"github.com/ejfox/dockrune/internal/storage"
+)
+
+// LeaseStore persists port leases. storage.Storage satisfies it.
+// CreatePortLease fails with storage.ErrPortTaken if another environment
+// holds the port.
+type LeaseStore interface {
+	GetPortLease(owner, repo, environment string) (*models.PortLease, error)
+	ListPortLeases() ([]*models.PortLease, error)
+	CreatePortLease(l *models.PortLease) error
+	DeletePortLease(owner, repo, environment string) error
+}
+
+// Allocator hands out host ports from a fixed range. Each owner/repo/environment
+// keeps its lease until it is released, so redeploys land on the same port.
+type Allocator struct {
+	store LeaseStore
+	start int
+	end   int
+	mu    sync.Mutex
+
+	// probe reports whether a port is free to bind. Swapped out in tests.
+	probe func(port int) bool
+}
+
+func NewAllocator(store LeaseStore, start, end int) *Allocator {
+	return &Allocator{
+		store: store,
+		start: start,
+		end:   end,
+		probe: portFree,
+	}
+}
+
+// Lease returns the port leased to owner/repo/environment, allocating a new
+// one if the environment has none yet.
+func (a *Allocator) Lease(owner, repo, environment string) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	existing, err := a.store.GetPortLease(owner, repo, environment)
+	if err == nil {
+		// The previous version of the app may still be bound to it, so
+		// there is no point probing our own lease.
+		return existing.Port, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("failed to look up port lease: %w", err)
+	}
+
+	leases, err := a.store.ListPortLeases()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list port leases: %w", err)
+	}
+	leased := make(map[int]bool, len(leases))
+	for _, l := range leases {
+		leased[l.Port] = true
+	}
+
+	// Start from a per-environment offset so environments that are created
+	// and torn down repeatedly don't all pile up at the bottom of the range
+	size := a.end - a.start + 1
+	offset := int(hashKey(owner, repo, environment) % uint32(size))
+
+	for i := 0; i < size; i++ {
+		port := a.start + (offset+i)%size
+		if leased[port] || !a.probe(port) {
+			continue
+		}
+
+		lease := &models.PortLease{
+			Owner:       owner,
+			Repo:        repo,
+			Environment: environment,
+			Port:        port,
+		}
+		err := a.store.CreatePortLease(lease)
+		if errors.Is(err, storage.ErrPortTaken) {
+			// Another process leased it in the meantime
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to lease port %d: %w", port, err)
+		}
+		return port, nil
+	}
+
+	return 0, fmt.Errorf("no free ports in range %d-%d", a.start, a.end)
+}
+
+// ErrLeased is returned by Reserve for a port another environment holds.
+var ErrLeased = errors.New("port is leased to another environment")
+
+// Reserve leases a specific port to owner/repo/environment, e.g. one pinned
+// in a project config, giving up any other port the environment held. The
+// port may be outside the range. It isn't probed: the environment's
+// previous version is most likely still bound to it.
+func (a *Allocator) Reserve(owner, repo, environment string, port int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	leases, err := a.store.ListPortLeases()
+	if err != nil {
+		return fmt.Errorf("failed to list port leases: %w", err)
+	}
+	var existing *models.PortLease
+	for _, l := range leases {
+		own := l.Owner == owner && l.Repo == repo && l.Environment == environment
+		switch {
+		case own:
+			existing = l
+		case l.Port == port:
+			return fmt.Errorf("%w: %d is leased to %s/%s %s", ErrLeased, port, l.Owner, l.Repo, l.Environment)
+		}
+	}
+	if existing != nil && existing.Port == port {
+		return nil
+	}
+
+	if existing != nil {
+		if err := a.store.DeletePortLease(owner, repo, environment); err != nil {
+			return fmt.Errorf("failed to release port lease: %w", err)
+		}
+	}
+	lease := &models.PortLease{
+		Owner:       owner,
+		Repo:        repo,
+		Environment: environment,
+		Port:        port,
+	}
+	err = a.store.CreatePortLease(lease)
+	if errors.Is(err, storage.ErrPortTaken) {
+		// Leased by another process since we listed them
+		return fmt.Errorf("%w: %d", ErrLeased, port)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lease port %d: %w", port, err)
+	}
+	return nil
+}
+
+// Lookup returns the port currently leased to owner/repo/environment
+// without allocating one.
+func (a *Allocator) Lookup(owner, repo, environment string) (int, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	lease, err := a.store.GetPortLease(owner, repo, environment)
+	if err != nil {
+		return 0, false
+	}
+	return lease.Port, true
+}
+
+// Release gives up the lease for owner/repo/environment.
+func (a *Allocator) Release(owner, repo, environment string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.store.DeletePortLease(owner, repo, environment); err != nil {
+		return fmt.Errorf("failed to release port lease: %w", err)
+	}
+	return nil
+}
+
+func portFree(port int) bool {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return false
+	}
+	ln.Close()
+	return true
+}
+
+func hashKey(owner, repo, environment string) uint32 {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s/%s/%s", owner, repo, environment)
+	return h.Sum32()
+}
@@ -0,0 +1,193 @@
+package ports
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/ejfox/dockrune/internal/models"
+	"github.com/ejfox/dockrune/internal/storage"
+)
+
+func newTestAllocator(t *testing.T, start, end int) *Allocator {
+	dbFile, err := os.CreateTemp("", "test-*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPath := dbFile.Name()
+	dbFile.Close()
+	t.Cleanup(func() { os.Remove(dbPath) })
+
+	store, err := storage.NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	a := NewAllocator(store, start, end)
+	a.probe = func(port int) bool { return true }
+	return a
+}
+
+func TestLeaseIsStable(t *testing.T) {
+	a := newTestAllocator(t, 3000, 3999)
+
+	first, err := a.Lease("ejfox", "site", "production")
+	if err != nil {
+		t.Fatalf("Lease() error = %v", err)
+	}
+
+	second, err := a.Lease("ejfox", "site", "production")
+	if err != nil {
+		t.Fatalf("Lease() error = %v", err)
+	}
+
+	if first != second {
+		t.Errorf("Lease() = %d then %d, want the same port", first, second)
+	}
+	if first < 3000 || first > 3999 {
+		t.Errorf("Lease() = %d, want a port in 3000-3999", first)
+	}
+}
+
+func TestLeasesDoNotCollide(t *testing.T) {
+	a := newTestAllocator(t, 3000, 3009)
+
+	// These two hashed to the same port with the old allocator
+	envs := []string{"preview-pr-12", "preview-pr-21", "production", "staging"}
+	seen := make(map[int]string)
+
+	for _, env := range envs {
+		port, err := a.Lease("ejfox", "site", env)
+		if err != nil {
+			t.Fatalf("Lease(%s) error = %v", env, err)
+		}
+		if other, ok := seen[port]; ok {
+			t.Errorf("Lease(%s) = %d, already leased to %s", env, port, other)
+		}
+		seen[port] = env
+	}
+
+	// Same environment name in another repo gets its own port too
+	port, err := a.Lease("ejfox", "other", "production")
+	if err != nil {
+		t.Fatalf("Lease() error = %v", err)
+	}
+	if other, ok := seen[port]; ok {
+		t.Errorf("Lease() = %d, already leased to %s", port, other)
+	}
+}
+
+func TestLeaseSkipsBusyPorts(t *testing.T) {
+	a := newTestAllocator(t, 3000, 3001)
+	a.probe = func(port int) bool { return port != 3000 }
+
+	port, err := a.Lease("ejfox", "site", "production")
+	if err != nil {
+		t.Fatalf("Lease() error = %v", err)
+	}
+	if port != 3001 {
+		t.Errorf("Lease() = %d, want 3001", port)
+	}
+
+	if _, err := a.Lease("ejfox", "site", "staging"); err == nil {
+		t.Error("Expected error when the range is exhausted")
+	}
+}
+
+func TestRelease(t *testing.T) {
+	a := newTestAllocator(t, 3000, 3000)
+
+	if _, err := a.Lease("ejfox", "site", "preview-pr-1"); err != nil {
+		t.Fatalf("Lease() error = %v", err)
+	}
+	if _, err := a.Lease("ejfox", "site", "preview-pr-2"); err == nil {
+		t.Fatal("Expected error while the only port is leased")
+	}
+
+	if err := a.Release("ejfox", "site", "preview-pr-1"); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+
+	port, err := a.Lease("ejfox", "site", "preview-pr-2")
+	if err != nil {
+		t.Fatalf("Lease() after Release() error = %v", err)
+	}
+	if port != 3000 {
+		t.Errorf("Lease() = %d, want 3000", port)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	a := newTestAllocator(t, 3000, 3001)
+
+	leased, err := a.Lease("ejfox", "site", "production")
+	if err != nil {
+		t.Fatalf("Lease() error = %v", err)
+	}
+
+	// Pinning a port gives up the leased one
+	if err := a.Reserve("ejfox", "site", "production", 9000); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	if err := a.Reserve("ejfox", "site", "production", 9000); err != nil {
+		t.Fatalf("Reserve() of the same port again error = %v", err)
+	}
+	if port, _ := a.Lookup("ejfox", "site", "production"); port != 9000 {
+		t.Errorf("Lookup() = %d, want the reserved 9000", port)
+	}
+
+	if err := a.Reserve("ejfox", "blog", "production", 9000); !errors.Is(err, ErrLeased) {
+		t.Errorf("Reserve() of a port another environment holds error = %v, want ErrLeased", err)
+	}
+
+	// A pin inside the range keeps Lease away from it
+	if err := a.Reserve("ejfox", "blog", "production", leased); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	port, err := a.Lease("ejfox", "site", "staging")
+	if err != nil {
+		t.Fatalf("Lease() error = %v", err)
+	}
+	if port == leased {
+		t.Errorf("Lease() = %d, the port reserved by another environment", port)
+	}
+}
+
+// racingStore leases every port it's asked for to someone else first, the
+// first time, as another dockrune process might.
+type racingStore struct {
+	LeaseStore
+	raced bool
+	err   error
+}
+
+func (s *racingStore) CreatePortLease(l *models.PortLease) error {
+	if s.err != nil {
+		return s.err
+	}
+	if !s.raced {
+		s.raced = true
+		s.LeaseStore.CreatePortLease(&models.PortLease{Owner: "ejfox", Repo: "blog", Environment: "production", Port: l.Port})
+	}
+	return s.LeaseStore.CreatePortLease(l)
+}
+
+func TestLeaseRetriesOnlyTakenPorts(t *testing.T) {
+	a := newTestAllocator(t, 3000, 3009)
+	store := &racingStore{LeaseStore: a.store}
+	a.store = store
+
+	port, err := a.Lease("ejfox", "site", "production")
+	if err != nil {
+		t.Fatalf("Lease() error = %v", err)
+	}
+	if blog, _ := a.Lookup("ejfox", "blog", "production"); blog == port {
+		t.Errorf("Lease() = %d, the port taken by another process", port)
+	}
+
+	store.err = errors.New("disk I/O error")
+	if _, err := a.Lease("ejfox", "site", "staging"); !errors.Is(err, store.err) {
+		t.Errorf("Lease() error = %v, want the store's error", err)
+	}
+}
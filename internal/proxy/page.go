@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// States only the proxy itself knows about.
+const (
+	stateUnavailable State = "unavailable" // routed, but the backend doesn't answer
+	stateNotFound    State = "not found"   // nothing deployed at this host
+)
+
+type page struct {
+	Status  int
+	Title   string
+	Message string
+	Refresh bool // reload every few seconds, for states that pass
+}
+
+var pages = map[State]page{
+	StateDeploying:   {http.StatusServiceUnavailable, "Deploying…", "A new version is on its way. This page reloads once it's up.", true},
+	StateFailed:      {http.StatusBadGateway, "Deployment failed", "The latest deployment didn't come up healthy. Check its log in the dockrune dashboard.", false},
+	stateUnavailable: {http.StatusBadGateway, "Not responding", "The app is deployed but isn't answering right now. It may be restarting.", true},
+	stateNotFound:    {http.StatusNotFound, "Nothing here", "There's no deployment at this address. It may have been torn down or expired.", false},
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+{{if .Refresh}}<meta http-equiv="refresh" content="5">
+{{end}}<title>{{.Title}} · {{.Host}}</title>
+<style>
+body { margin: 0; min-height: 100vh; display: flex; align-items: center; justify-content: center;
+	font-family: ui-monospace, SFMono-Regular, Menlo, monospace; background: #0d0d0d; color: #e6e6e6; }
+main { max-width: 32rem; padding: 2rem; }
+h1 { font-size: 1.25rem; margin: 0 0 1rem; }
+p { color: #9a9a9a; line-height: 1.5; }
+footer { margin-top: 2rem; font-size: 0.75rem; color: #555; }
+</style>
+</head>
+<body>
+<main>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+<footer>{{.Host}} · served by dockrune</footer>
+</main>
+</body>
+</html>
+`))
+
+// writePage answers with the placeholder page of state.
+func writePage(w http.ResponseWriter, state State, host string) {
+	p, ok := pages[state]
+	if !ok {
+		p = pages[stateUnavailable]
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if p.Refresh {
+		w.Header().Set("Retry-After", "5")
+	}
+	w.WriteHeader(p.Status)
+	pageTemplate.Execute(w, struct {
+		page
+		Host string
+	}{p, host})
+}
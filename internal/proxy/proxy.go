@@ -0,0 +1,223 @@
+// Package proxy is dockrune's built-in HTTP reverse proxy. It sends each
+// request to the port of the environment serving its Host, and answers
+// with a placeholder page while that environment has nothing healthy to
+// serve.
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ejfox/dockrune/internal/routing"
+)
+
+// State is what an environment without a healthy backend is up to.
+type State string
+
+const (
+	StateDeploying State = "deploying"
+	StateFailed    State = "failed"
+)
+
+// table is an immutable snapshot of the routes. Writers build a new one and
+// swap it in, so every request sees one consistent table.
+type table struct {
+	routes map[string]routing.Route // by host
+	states map[string]placeholder   // by host
+}
+
+type placeholder struct {
+	State State
+	Route routing.Route // Port may be zero
+}
+
+// Proxy routes requests by Host. Websocket and other upgraded connections
+// are passed through.
+type Proxy struct {
+	// Upstream is the host apps are reached on.
+	Upstream string
+	// Domain is the deployment domain; unknown hosts under it get a
+	// placeholder rather than a bare 404.
+	Domain string
+
+	mu    sync.Mutex // serializes writers
+	table atomic.Pointer[table]
+	proxy *httputil.ReverseProxy
+}
+
+type backendKey struct{}
+
+func New(upstream, domain string) *Proxy {
+	p := &Proxy{Upstream: upstream, Domain: domain}
+	p.table.Store(&table{routes: map[string]routing.Route{}, states: map[string]placeholder{}})
+	p.proxy = &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(r.In.Context().Value(backendKey{}).(*url.URL))
+			r.Out.Host = r.In.Host
+			r.SetXForwarded()
+		},
+		ErrorHandler: p.backendError,
+	}
+	return p
+}
+
+// update applies change to a copy of the current table and swaps it in.
+func (p *Proxy) update(change func(t *table)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	current := p.table.Load()
+	next := &table{
+		routes: make(map[string]routing.Route, len(current.routes)),
+		states: make(map[string]placeholder, len(current.states)),
+	}
+	for host, route := range current.routes {
+		next.routes[host] = route
+	}
+	for host, state := range current.states {
+		next.states[host] = state
+	}
+	change(next)
+	p.table.Store(next)
+}
+
+func inEnvironment(route routing.Route, owner, repo, environment string) bool {
+	return route.Owner == owner && route.Repo == repo && route.Environment == environment
+}
+
+// dropRoutes removes every route of owner/repo/environment.
+func (t *table) dropRoutes(owner, repo, environment string) {
+	for host, route := range t.routes {
+		if inEnvironment(route, owner, repo, environment) {
+			delete(t.routes, host)
+		}
+	}
+}
+
+// dropStates removes every placeholder of owner/repo/environment.
+func (t *table) dropStates(owner, repo, environment string) {
+	for host, state := range t.states {
+		if inEnvironment(state.Route, owner, repo, environment) {
+			delete(t.states, host)
+		}
+	}
+}
+
+// Set routes route.Host to route.Port from now on, replacing any other
+// host of the same environment and whatever placeholder it had.
+func (p *Proxy) Set(route routing.Route) error {
+	route.Host = normalizeHost(route.Host)
+	p.update(func(t *table) {
+		t.dropRoutes(route.Owner, route.Repo, route.Environment)
+		t.dropStates(route.Owner, route.Repo, route.Environment)
+		t.routes[route.Host] = route
+	})
+	return nil
+}
+
+// RemoveEnvironment stops routing owner/repo/environment.
+func (p *Proxy) RemoveEnvironment(owner, repo, environment string) error {
+	p.update(func(t *table) {
+		t.dropRoutes(owner, repo, environment)
+		t.dropStates(owner, repo, environment)
+	})
+	return nil
+}
+
+// Load replaces every route, as on startup.
+func (p *Proxy) Load(routes []routing.Route) error {
+	p.update(func(t *table) {
+		t.routes = make(map[string]routing.Route, len(routes))
+		for _, route := range routes {
+			route.Host = normalizeHost(route.Host)
+			t.routes[route.Host] = route
+		}
+	})
+	return nil
+}
+
+// Deploying shows the deploying page at route.Host until the environment
+// has a healthy backend.
+func (p *Proxy) Deploying(route routing.Route) {
+	route.Host = normalizeHost(route.Host)
+	p.update(func(t *table) {
+		t.dropStates(route.Owner, route.Repo, route.Environment)
+		t.states[route.Host] = placeholder{State: StateDeploying, Route: route}
+	})
+}
+
+// Failed turns an environment's deploying page into the failed page. If
+// the environment still has a backend that answers, that keeps serving.
+func (p *Proxy) Failed(owner, repo, environment string) {
+	p.update(func(t *table) {
+		for host, state := range t.states {
+			if inEnvironment(state.Route, owner, repo, environment) {
+				state.State = StateFailed
+				t.states[host] = state
+			}
+		}
+	})
+}
+
+// Settled drops an environment's placeholder, e.g. once a deployment was
+// cancelled and the previous version is still in place.
+func (p *Proxy) Settled(owner, repo, environment string) {
+	p.update(func(t *table) {
+		t.dropStates(owner, repo, environment)
+	})
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := normalizeHost(r.Host)
+	t := p.table.Load()
+
+	route, ok := t.routes[host]
+	if !ok {
+		if state, ok := t.states[host]; ok {
+			writePage(w, state.State, host)
+			return
+		}
+		if p.Domain != "" && strings.HasSuffix(host, "."+strings.ToLower(p.Domain)) {
+			writePage(w, stateNotFound, host)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	backend := &url.URL{Scheme: "http", Host: net.JoinHostPort(p.Upstream, fmt.Sprint(route.Port))}
+	p.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), backendKey{}, backend)))
+}
+
+// backendError answers for a backend that can't be reached, most likely
+// because it is being replaced or has crashed.
+func (p *Proxy) backendError(w http.ResponseWriter, r *http.Request, err error) {
+	if r.Context().Err() != nil {
+		return // the client went away
+	}
+	host := normalizeHost(r.Host)
+
+	// Look again: the deployment may have moved on since the request came in
+	state := stateUnavailable
+	if s, ok := p.table.Load().states[host]; ok {
+		state = s.State
+	}
+	log.Printf("Proxy: %s: %v", host, err)
+	writePage(w, state, host)
+}
+
+// normalizeHost strips the port from a Host header and lowercases it.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
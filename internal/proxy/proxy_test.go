@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ejfox/dockrune/internal/routing"
+)
+
+// backend starts an HTTP server answering "<name> <host>" and returns its
+// port.
+func backend(t *testing.T, name string) int {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", name, r.Host)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().(*net.TCPAddr).Port
+}
+
+// closedPort returns a port nothing listens on.
+func closedPort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	return port
+}
+
+func get(t *testing.T, p *Proxy, host string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+func site(host string, port int) routing.Route {
+	return routing.Route{Host: host, Port: port, Owner: "ejfox", Repo: "site", Environment: "production"}
+}
+
+func TestRoutesByHost(t *testing.T) {
+	p := New("127.0.0.1", "example.com")
+	p.Load([]routing.Route{
+		site("site.example.com", backend(t, "site")),
+		{Host: "pr-1.example.com", Port: backend(t, "preview"), Owner: "ejfox", Repo: "site", Environment: "preview-pr-1"},
+	})
+
+	for host, want := range map[string]string{
+		"site.example.com":      "site site.example.com",
+		"SITE.example.com:8080": "site SITE.example.com:8080",
+		"pr-1.example.com":      "preview pr-1.example.com",
+	} {
+		if code, body := get(t, p, host); code != http.StatusOK || body != want {
+			t.Errorf("GET %s = %d %q, want 200 %q", host, code, body, want)
+		}
+	}
+
+	if code, body := get(t, p, "nope.example.com"); code != http.StatusNotFound || !strings.Contains(body, "Nothing here") {
+		t.Errorf("unknown subdomain = %d %q, want the not found page", code, body)
+	}
+	if code, body := get(t, p, "elsewhere.org"); code != http.StatusNotFound || strings.Contains(body, "dockrune") {
+		t.Errorf("foreign host = %d %q, want a bare 404", code, body)
+	}
+}
+
+func TestSwitchBackend(t *testing.T) {
+	p := New("127.0.0.1", "example.com")
+	p.Set(site("site.example.com", backend(t, "blue")))
+	if _, body := get(t, p, "site.example.com"); !strings.HasPrefix(body, "blue") {
+		t.Fatalf("body = %q, want blue", body)
+	}
+
+	p.Set(site("site.example.com", backend(t, "green")))
+	if _, body := get(t, p, "site.example.com"); !strings.HasPrefix(body, "green") {
+		t.Errorf("body after switch = %q, want green", body)
+	}
+
+	// A new host for the environment replaces the old one
+	p.Set(site("www.example.com", backend(t, "green")))
+	if code, _ := get(t, p, "site.example.com"); code != http.StatusNotFound {
+		t.Errorf("old host still routed (status %d)", code)
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	p := New("127.0.0.1", "example.com")
+	preview := routing.Route{Host: "pr-2.example.com", Owner: "ejfox", Repo: "site", Environment: "preview-pr-2"}
+
+	p.Deploying(preview)
+	code, body := get(t, p, "pr-2.example.com")
+	if code != http.StatusServiceUnavailable || !strings.Contains(body, "Deploying") || !strings.Contains(body, `http-equiv="refresh"`) {
+		t.Errorf("deploying = %d %q", code, body)
+	}
+
+	p.Failed("ejfox", "site", "preview-pr-2")
+	if code, body := get(t, p, "pr-2.example.com"); code != http.StatusBadGateway || !strings.Contains(body, "Deployment failed") {
+		t.Errorf("failed = %d %q", code, body)
+	}
+
+	// A redeploy of a routed environment whose app is down shows the
+	// deploying page rather than an error
+	preview.Port = closedPort(t)
+	p.Set(preview)
+	if code, body := get(t, p, "pr-2.example.com"); code != http.StatusBadGateway || !strings.Contains(body, "Not responding") {
+		t.Errorf("dead backend = %d %q", code, body)
+	}
+	p.Deploying(preview)
+	if code, body := get(t, p, "pr-2.example.com"); code != http.StatusServiceUnavailable || !strings.Contains(body, "Deploying") {
+		t.Errorf("dead backend while deploying = %d %q", code, body)
+	}
+
+	p.RemoveEnvironment("ejfox", "site", "preview-pr-2")
+	if code, body := get(t, p, "pr-2.example.com"); code != http.StatusNotFound || !strings.Contains(body, "Nothing here") {
+		t.Errorf("removed = %d %q", code, body)
+	}
+}
+
+func TestWebsocketPassthrough(t *testing.T) {
+	// An echo server speaking just enough of the websocket handshake
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+		io.Copy(conn, rw)
+	}))
+	defer echo.Close()
+
+	p := New("127.0.0.1", "example.com")
+	p.Set(site("site.example.com", echo.Listener.Addr().(*net.TCPAddr).Port))
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	conn, err := net.Dial("tcp", front.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "GET /socket HTTP/1.1\r\nHost: site.example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+
+	fmt.Fprint(conn, "hello\n")
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "hello\n" {
+		t.Errorf("echo = %q, %v; want hello", line, err)
+	}
+}
@@ -0,0 +1,245 @@
+package routing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// header opens every rendered file that has comments.
+const header = "# Generated by dockrune, do not edit. Changes are overwritten on every deploy.\n"
+
+// challengePath is where ACME CAs fetch http-01 challenges from.
+const challengePath = "/.well-known/acme-challenge/"
+
+var renderers = map[string]func(data templateData) ([]byte, error){
+	ProxyCaddy:     renderCaddyfile,
+	ProxyCaddyJSON: renderCaddyJSON,
+	ProxyNginx:     renderNginx,
+	ProxyTraefik:   renderTraefik,
+}
+
+type templateData struct {
+	Routes    []Route
+	Upstream  string
+	Challenge string
+}
+
+func execute(tmpl *template.Template, data templateData) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Caddy gets certificates for every site on its own, unless the route
+// comes with one.
+var caddyfileTemplate = template.Must(template.New("Caddyfile").Parse(header + `{{range .Routes}}
+# {{.Owner}}/{{.Repo}} {{.Environment}}
+{{.Host}} {
+{{- if .CertFile}}
+	tls {{.CertFile}} {{.KeyFile}}
+{{- end}}
+{{- if $.Challenge}}
+	reverse_proxy ` + challengePath + `* {{$.Challenge}}
+{{- end}}
+	reverse_proxy {{$.Upstream}}:{{.Port}}
+}
+{{end}}`))
+
+func renderCaddyfile(data templateData) ([]byte, error) {
+	return execute(caddyfileTemplate, data)
+}
+
+// renderCaddyJSON renders a complete Caddy config, as loaded by
+// `caddy run --config` or the admin API's /load.
+func renderCaddyJSON(data templateData) ([]byte, error) {
+	type object = map[string]interface{}
+
+	var caddyRoutes []object
+	var certificates []object
+	for _, route := range data.Routes {
+		if data.Challenge != "" {
+			caddyRoutes = append(caddyRoutes, object{
+				"match": []object{{"host": []string{route.Host}, "path": []string{challengePath + "*"}}},
+				"handle": []object{{
+					"handler":   "reverse_proxy",
+					"upstreams": []object{{"dial": data.Challenge}},
+				}},
+				"terminal": true,
+			})
+		}
+		caddyRoutes = append(caddyRoutes, object{
+			"match": []object{{"host": []string{route.Host}}},
+			"handle": []object{{
+				"handler":   "reverse_proxy",
+				"upstreams": []object{{"dial": fmt.Sprintf("%s:%d", data.Upstream, route.Port)}},
+			}},
+			"terminal": true,
+		})
+		if route.CertFile != "" {
+			certificates = append(certificates, object{"certificate": route.CertFile, "key": route.KeyFile})
+		}
+	}
+	if caddyRoutes == nil {
+		caddyRoutes = []object{}
+	}
+
+	apps := object{
+		"http": object{
+			"servers": object{
+				"dockrune": object{
+					"listen": []string{":443"},
+					"routes": caddyRoutes,
+				},
+			},
+		},
+	}
+	if certificates != nil {
+		apps["tls"] = object{"certificates": object{"load_files": certificates}}
+	}
+	config, err := json.MarshalIndent(object{"apps": apps}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(config, '\n'), nil
+}
+
+// The file is meant to be included in nginx's http block. Hosts without a
+// certificate are served over plain HTTP; certificates for them are left
+// to whoever manages nginx.
+var nginxTemplate = template.Must(template.New("nginx").Parse(header + `
+map $http_upgrade $dockrune_connection_upgrade {
+	default upgrade;
+	''      close;
+}
+{{range .Routes}}
+# {{.Owner}}/{{.Repo}} {{.Environment}}
+server {
+	listen 80;
+	server_name {{.Host}};
+{{- if $.Challenge}}
+
+	location ` + challengePath + ` {
+		proxy_pass http://{{$.Challenge}};
+		proxy_set_header Host $host;
+	}
+{{- end}}
+{{- if .CertFile}}
+
+	location / {
+		return 301 https://$host$request_uri;
+	}
+}
+
+server {
+	listen 443 ssl;
+	server_name {{.Host}};
+	ssl_certificate {{.CertFile}};
+	ssl_certificate_key {{.KeyFile}};
+{{- end}}
+
+	location / {
+		proxy_pass http://{{$.Upstream}}:{{.Port}};
+		proxy_http_version 1.1;
+		proxy_set_header Host $host;
+		proxy_set_header X-Real-IP $remote_addr;
+		proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+		proxy_set_header X-Forwarded-Proto $scheme;
+		proxy_set_header Upgrade $http_upgrade;
+		proxy_set_header Connection $dockrune_connection_upgrade;
+	}
+}
+{{end}}`))
+
+func renderNginx(data templateData) ([]byte, error) {
+	return execute(nginxTemplate, data)
+}
+
+type traefikConfig struct {
+	HTTP struct {
+		Routers  map[string]traefikRouter  `yaml:"routers"`
+		Services map[string]traefikService `yaml:"services"`
+	} `yaml:"http"`
+	TLS *traefikTLS `yaml:"tls,omitempty"`
+}
+
+type traefikRouter struct {
+	Rule     string    `yaml:"rule"`
+	Service  string    `yaml:"service"`
+	Priority int       `yaml:"priority,omitempty"`
+	TLS      *struct{} `yaml:"tls,omitempty"`
+}
+
+type traefikService struct {
+	LoadBalancer struct {
+		Servers []traefikServer `yaml:"servers"`
+	} `yaml:"loadBalancer"`
+}
+
+type traefikServer struct {
+	URL string `yaml:"url"`
+}
+
+type traefikTLS struct {
+	Certificates []traefikCertificate `yaml:"certificates"`
+}
+
+type traefikCertificate struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+func traefikServiceFor(url string) traefikService {
+	var service traefikService
+	service.LoadBalancer.Servers = []traefikServer{{URL: url}}
+	return service
+}
+
+// renderTraefik renders a dynamic config for Traefik's file provider, one
+// router and service per host.
+func renderTraefik(data templateData) ([]byte, error) {
+	var config traefikConfig
+	config.HTTP.Routers = make(map[string]traefikRouter, len(data.Routes))
+	config.HTTP.Services = make(map[string]traefikService, len(data.Routes))
+	for _, route := range data.Routes {
+		name := "dockrune-" + strings.ReplaceAll(route.Host, ".", "-")
+		router := traefikRouter{
+			Rule:    fmt.Sprintf("Host(`%s`)", route.Host),
+			Service: name,
+		}
+		if route.CertFile != "" {
+			router.TLS = &struct{}{}
+			if config.TLS == nil {
+				config.TLS = &traefikTLS{}
+			}
+			config.TLS.Certificates = append(config.TLS.Certificates, traefikCertificate{CertFile: route.CertFile, KeyFile: route.KeyFile})
+		}
+		config.HTTP.Routers[name] = router
+		config.HTTP.Services[name] = traefikServiceFor(fmt.Sprintf("http://%s:%d", data.Upstream, route.Port))
+	}
+
+	// One router takes every challenge, ahead of the host routers
+	if data.Challenge != "" {
+		config.HTTP.Routers["dockrune-acme-challenge"] = traefikRouter{
+			Rule:     fmt.Sprintf("PathPrefix(`%s`)", challengePath),
+			Service:  "dockrune-acme-challenge",
+			Priority: 1000,
+		}
+		config.HTTP.Services["dockrune-acme-challenge"] = traefikServiceFor("http://" + data.Challenge)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(header)
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(config); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), enc.Close()
+}
@@ -0,0 +1,272 @@
+// Package routing keeps track of which hostname is served by which local
+// port and renders those routes into the config of a reverse proxy.
+package routing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proxies routes can be rendered for.
+const (
+	ProxyCaddy     = "caddy"      // Caddyfile
+	ProxyCaddyJSON = "caddy-json" // Caddy's native JSON config
+	ProxyNginx     = "nginx"      // nginx server blocks
+	ProxyTraefik   = "traefik"    // Traefik dynamic file config
+)
+
+// Proxies lists every supported proxy.
+var Proxies = []string{ProxyCaddy, ProxyCaddyJSON, ProxyNginx, ProxyTraefik}
+
+// reloadTimeout bounds a proxy reload.
+const reloadTimeout = 30 * time.Second
+
+// Route sends requests for Host to Port on the upstream host. Owner, Repo
+// and Environment say which environment it belongs to. CertFile and
+// KeyFile, if set, are the certificate the proxy serves Host with.
+type Route struct {
+	Host        string
+	Port        int
+	Owner       string
+	Repo        string
+	Environment string
+	CertFile    string
+	KeyFile     string
+}
+
+// Router holds the current routes and keeps the proxy's config file in
+// step with them. Every change re-renders the whole file, replaces it
+// atomically and reloads the proxy, unless the file came out unchanged.
+type Router struct {
+	// Upstream is the host the proxy reaches apps on.
+	Upstream string
+	// Reload is run after the config file changes: a shell command, or for
+	// caddy-json the URL of Caddy's admin API, which is sent the config.
+	// Empty for proxies that watch the file themselves.
+	Reload string
+	// Challenge is the address ACME http-01 challenges are passed to, so
+	// that dockrune can answer them; empty to leave them to the proxy.
+	Challenge string
+
+	proxy  string
+	path   string
+	render func(data templateData) ([]byte, error)
+
+	mu     sync.Mutex
+	routes map[string]Route // by host
+}
+
+// New returns a Router writing config for proxy to path, with the
+// proxy's default reload.
+func New(proxy, path string) (*Router, error) {
+	render, ok := renderers[proxy]
+	if !ok {
+		return nil, fmt.Errorf("unknown proxy %q (want one of %s)", proxy, strings.Join(Proxies, ", "))
+	}
+	return &Router{
+		Upstream: "127.0.0.1",
+		Reload:   DefaultReload(proxy, path),
+		proxy:    proxy,
+		path:     path,
+		render:   render,
+		routes:   make(map[string]Route),
+	}, nil
+}
+
+// DefaultReload is how a proxy is told about a new config at path.
+func DefaultReload(proxy, path string) string {
+	switch proxy {
+	case ProxyCaddy:
+		// Forced, so that Refresh picks up renewed certificates
+		return fmt.Sprintf("caddy reload --config %s --adapter caddyfile --force", shellQuote(path))
+	case ProxyCaddyJSON:
+		return "http://localhost:2019"
+	case ProxyNginx:
+		return "nginx -s reload"
+	}
+	return "" // traefik watches its file provider
+}
+
+// Path is the config file the router writes.
+func (r *Router) Path() string {
+	return r.path
+}
+
+// Set adds or replaces the route for route.Host and drops any other host
+// the same environment was routed from.
+func (r *Router) Set(route Route) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for host, existing := range r.routes {
+		if sameEnvironment(existing, route) {
+			delete(r.routes, host)
+		}
+	}
+	r.routes[route.Host] = route
+	return r.sync()
+}
+
+// RemoveEnvironment drops the routes of owner/repo/environment.
+func (r *Router) RemoveEnvironment(owner, repo, environment string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for host, route := range r.routes {
+		if route.Owner == owner && route.Repo == repo && route.Environment == environment {
+			delete(r.routes, host)
+		}
+	}
+	return r.sync()
+}
+
+// Load replaces every route, as on startup.
+func (r *Router) Load(routes []Route) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.routes = make(map[string]Route, len(routes))
+	for _, route := range routes {
+		r.routes[route.Host] = route
+	}
+	return r.sync()
+}
+
+// Routes returns the current routes, sorted by host.
+func (r *Router) Routes() []Route {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sorted()
+}
+
+func (r *Router) sorted() []Route {
+	routes := make([]Route, 0, len(r.routes))
+	for _, route := range r.routes {
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Host < routes[j].Host })
+	return routes
+}
+
+func sameEnvironment(a, b Route) bool {
+	return a.Owner == b.Owner && a.Repo == b.Repo && a.Environment == b.Environment
+}
+
+// Refresh rewrites the config and reloads the proxy even if nothing
+// changed, for it to load certificates that were renewed in place.
+func (r *Router) Refresh() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.write(true)
+}
+
+// sync renders the routes, writes them if they changed and reloads the
+// proxy. r.mu must be held.
+func (r *Router) sync() error {
+	return r.write(false)
+}
+
+func (r *Router) write(force bool) error {
+	config, err := r.render(templateData{Routes: r.sorted(), Upstream: r.Upstream, Challenge: r.Challenge})
+	if err != nil {
+		return fmt.Errorf("failed to render %s config: %w", r.proxy, err)
+	}
+
+	current, err := os.ReadFile(r.path)
+	if err == nil && bytes.Equal(current, config) && !force {
+		return nil
+	}
+	// A forced write touches an unchanged file too, for proxies watching it
+	if err := writeAtomic(r.path, config); err != nil {
+		return err
+	}
+	return r.reload(config)
+}
+
+// writeAtomic replaces path with data so that readers see either the old
+// file or the new one, never a partial write.
+func writeAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (r *Router) reload(config []byte) error {
+	if r.Reload == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reloadTimeout)
+	defer cancel()
+
+	if strings.HasPrefix(r.Reload, "http://") || strings.HasPrefix(r.Reload, "https://") {
+		return loadCaddyConfig(ctx, r.Reload, config)
+	}
+
+	out, err := exec.CommandContext(ctx, "sh", "-c", r.Reload).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to reload %s: %w: %s", r.proxy, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// loadCaddyConfig replaces Caddy's running config through its admin API.
+func loadCaddyConfig(ctx context.Context, adminURL string, config []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(adminURL, "/")+"/load", bytes.NewReader(config))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Load even an unchanged config, so that renewed certificates are read
+	req.Header.Set("Cache-Control", "must-revalidate")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach caddy admin API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body bytes.Buffer
+		body.ReadFrom(resp.Body)
+		return errors.New("caddy rejected config: " + strings.TrimSpace(body.String()))
+	}
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
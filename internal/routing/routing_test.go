@@ -0,0 +1,186 @@
+package routing
+
+import (
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+var testRoutes = []Route{
+	{Host: "site.example.com", Port: 3000, Owner: "ejfox", Repo: "site", Environment: "production"},
+	{Host: "preview-pr-7.example.com", Port: 3001, Owner: "ejfox", Repo: "site", Environment: "preview-pr-7"},
+	{Host: "app.ejfox.com", Port: 3002, Owner: "ejfox", Repo: "app", Environment: "production"},
+}
+
+// testTLSRoutes have dockrune's certificates, except for the last one.
+var testTLSRoutes = []Route{
+	{Host: "site.example.com", Port: 3000, Owner: "ejfox", Repo: "site", Environment: "production",
+		CertFile: "/var/lib/dockrune/certs/site.example.com/cert.pem", KeyFile: "/var/lib/dockrune/certs/site.example.com/key.pem"},
+	{Host: "preview-pr-7.example.com", Port: 3001, Owner: "ejfox", Repo: "site", Environment: "preview-pr-7"},
+}
+
+func TestRenderGolden(t *testing.T) {
+	golden := map[string]string{
+		ProxyCaddy:     "Caddyfile.golden",
+		ProxyCaddyJSON: "caddy.json.golden",
+		ProxyNginx:     "nginx.conf.golden",
+		ProxyTraefik:   "traefik.yml.golden",
+	}
+	testGolden(t, golden, testRoutes, "")
+}
+
+func TestRenderTLSGolden(t *testing.T) {
+	golden := map[string]string{
+		ProxyCaddy:     "Caddyfile.tls.golden",
+		ProxyCaddyJSON: "caddy.tls.json.golden",
+		ProxyNginx:     "nginx.tls.conf.golden",
+		ProxyTraefik:   "traefik.tls.yml.golden",
+	}
+	testGolden(t, golden, testTLSRoutes, "127.0.0.1:8402")
+}
+
+func testGolden(t *testing.T, golden map[string]string, routes []Route, challenge string) {
+	for _, proxy := range Proxies {
+		t.Run(proxy, func(t *testing.T) {
+			r, err := New(proxy, filepath.Join(t.TempDir(), "routes"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Reload = ""
+			r.Challenge = challenge
+			if err := r.Load(routes); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			got, err := os.ReadFile(r.Path())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join("testdata", golden[proxy])
+			if *update {
+				if err := os.WriteFile(path, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("%s config differs from %s (go test -update to accept):\n%s", proxy, path, got)
+			}
+		})
+	}
+}
+
+func TestSetReplacesEnvironmentHost(t *testing.T) {
+	r, err := New(ProxyCaddy, filepath.Join(t.TempDir(), "Caddyfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Reload = ""
+
+	if err := r.Set(Route{Host: "preview-pr-7.example.com", Port: 3001, Owner: "ejfox", Repo: "site", Environment: "preview-pr-7"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Set(Route{Host: "pr-7.example.com", Port: 3001, Owner: "ejfox", Repo: "site", Environment: "preview-pr-7"}); err != nil {
+		t.Fatal(err)
+	}
+	routes := r.Routes()
+	if len(routes) != 1 || routes[0].Host != "pr-7.example.com" {
+		t.Errorf("Routes() = %+v, want only the new host", routes)
+	}
+
+	if err := r.RemoveEnvironment("ejfox", "site", "preview-pr-7"); err != nil {
+		t.Fatal(err)
+	}
+	if routes := r.Routes(); len(routes) != 0 {
+		t.Errorf("Routes() after RemoveEnvironment = %+v, want none", routes)
+	}
+}
+
+func TestReloadOnlyOnChange(t *testing.T) {
+	dir := t.TempDir()
+	r, err := New(ProxyNginx, filepath.Join(dir, "dockrune.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := filepath.Join(dir, "reloads")
+	r.Reload = "echo reload >> " + shellQuote(counter)
+
+	route := testRoutes[0]
+	for i := 0; i < 2; i++ {
+		if err := r.Set(route); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	route.Port = 3005
+	if err := r.Set(route); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	reloads, _ := os.ReadFile(counter)
+	if n := strings.Count(string(reloads), "reload"); n != 2 {
+		t.Errorf("reloaded %d times, want 2", n)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(dir, ".dockrune.conf.*"))
+	if len(leftovers) != 0 {
+		t.Errorf("temp files left behind: %v", leftovers)
+	}
+
+	r.Reload = "echo bad config >&2; exit 1"
+	route.Port = 3006
+	if err := r.Set(route); err == nil || !strings.Contains(err.Error(), "bad config") {
+		t.Errorf("Set() with failing reload error = %v, want the reload's output", err)
+	}
+}
+
+func TestCaddyAdminReload(t *testing.T) {
+	var loaded string
+	var loads int
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/load" || req.Method != http.MethodPost {
+			http.NotFound(w, req)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		loaded = string(body)
+		loads++
+	}))
+	defer admin.Close()
+
+	r, err := New(ProxyCaddyJSON, filepath.Join(t.TempDir(), "caddy.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Reload = admin.URL
+	if err := r.Set(testRoutes[0]); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	written, _ := os.ReadFile(r.Path())
+	if loaded == "" || loaded != string(written) {
+		t.Errorf("admin API got %q, want the written config", loaded)
+	}
+
+	// Refresh loads the unchanged config again, for renewed certificates
+	if err := r.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if loads != 2 {
+		t.Errorf("admin API loaded %d configs, want 2", loads)
+	}
+}
+
+func TestUnknownProxy(t *testing.T) {
+	if _, err := New("haproxy", "routes.cfg"); err == nil {
+		t.Error("New() with an unknown proxy should fail")
+	}
+}
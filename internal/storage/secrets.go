@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/ejfox/dockrune/internal/models"
+)
+
+// SecretsKeySize is the length of the master key secrets are encrypted with.
+const SecretsKeySize = 32
+
+// ErrSecretsDisabled is returned when secrets are used without a master key.
+var ErrSecretsDisabled = errors.New("secrets are disabled: no secrets key configured")
+
+// SetSecretsKey enables the secrets store. Values are encrypted with
+// AES-256-GCM under key, bound to the repo, environment and name they
+// were stored for.
+func (s *SQLiteStorage) SetSecretsKey(key []byte) error {
+	if len(key) != SecretsKeySize {
+		return fmt.Errorf("secrets key must be %d bytes, got %d", SecretsKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	s.secrets = aead
+	return nil
+}
+
+// secretAD is the additional data a secret's value is sealed with, so a
+// ciphertext copied to another row won't decrypt.
+func secretAD(owner, repo, environment, name string) []byte {
+	return []byte(fmt.Sprintf("%s/%s/%s/%s", owner, repo, environment, name))
+}
+
+// SetSecret encrypts and stores a secret, replacing one with the same name.
+func (s *SQLiteStorage) SetSecret(sec *models.Secret) error {
+	if s.secrets == nil {
+		return ErrSecretsDisabled
+	}
+
+	nonce := make([]byte, s.secrets.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := s.secrets.Seal(nil, nonce, []byte(sec.Value), secretAD(sec.Owner, sec.Repo, sec.Environment, sec.Name))
+
+	query := `
+	INSERT INTO secrets (owner, repo, environment, name, path, nonce, value)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (owner, repo, environment, name)
+	DO UPDATE SET path = excluded.path, nonce = excluded.nonce, value = excluded.value, updated_at = CURRENT_TIMESTAMP
+	`
+
+	_, err := s.db.Exec(query, sec.Owner, sec.Repo, sec.Environment, sec.Name, sec.Path, nonce, sealed)
+	return err
+}
+
+// ListSecrets returns the secrets stored for exactly owner/repo/environment,
+// sorted by name, without their values.
+func (s *SQLiteStorage) ListSecrets(owner, repo, environment string) ([]*models.Secret, error) {
+	secrets, _, err := s.querySecrets(owner, repo, environment)
+	return secrets, err
+}
+
+// GetSecrets is ListSecrets with the values decrypted.
+func (s *SQLiteStorage) GetSecrets(owner, repo, environment string) ([]*models.Secret, error) {
+	secrets, sealed, err := s.querySecrets(owner, repo, environment)
+	if err != nil || len(secrets) == 0 {
+		return secrets, err
+	}
+	if s.secrets == nil {
+		return nil, ErrSecretsDisabled
+	}
+
+	for i, sec := range secrets {
+		nonce, value := sealed[i][0], sealed[i][1]
+		plain, err := s.secrets.Open(nil, nonce, value, secretAD(sec.Owner, sec.Repo, sec.Environment, sec.Name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt secret %s (wrong secrets key?): %w", sec.Name, err)
+		}
+		sec.Value = string(plain)
+	}
+	return secrets, nil
+}
+
+// querySecrets returns the secrets of a scope along with each one's nonce
+// and sealed value.
+func (s *SQLiteStorage) querySecrets(owner, repo, environment string) ([]*models.Secret, [][2][]byte, error) {
+	query := `
+	SELECT owner, repo, environment, name, path, updated_at, nonce, value
+	FROM secrets
+	WHERE owner = ? AND repo = ? AND environment = ?
+	ORDER BY name
+	`
+
+	rows, err := s.db.Query(query, owner, repo, environment)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var secrets []*models.Secret
+	var sealed [][2][]byte
+	for rows.Next() {
+		var sec models.Secret
+		var nonce, value []byte
+		if err := rows.Scan(&sec.Owner, &sec.Repo, &sec.Environment, &sec.Name, &sec.Path, &sec.UpdatedAt, &nonce, &value); err != nil {
+			return nil, nil, err
+		}
+		secrets = append(secrets, &sec)
+		sealed = append(sealed, [2][]byte{nonce, value})
+	}
+
+	return secrets, sealed, rows.Err()
+}
+
+// DeleteSecret removes a secret, returning sql.ErrNoRows if it isn't set.
+func (s *SQLiteStorage) DeleteSecret(owner, repo, environment, name string) error {
+	query := `
+	DELETE FROM secrets
+	WHERE owner = ? AND repo = ? AND environment = ? AND name = ?
+	`
+
+	result, err := s.db.Exec(query, owner, repo, environment, name)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
@@ -1,24 +1,64 @@
 package storage
 
 import (
+	"crypto/cipher"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/ejfox/dockrune/internal/models"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
+// ErrPortTaken is returned by CreatePortLease for a port another
+// environment already holds.
+var ErrPortTaken = errors.New("port is already leased")
+
 type Storage interface {
 	CreateDeployment(d *models.Deployment) error
 	UpdateDeployment(d *models.Deployment) error
 	GetDeployment(id string) (*models.Deployment, error)
 	ListDeployments(limit int) ([]*models.Deployment, error)
 	GetActiveDeployments() ([]*models.Deployment, error)
+	ListDeploymentsByStatus(status models.DeploymentStatus) ([]*models.Deployment, error)
+	GetLastSuccessfulDeployment(owner, repo, environment string) (*models.Deployment, error)
+	DeactivateDeployments(owner, repo, environment string) error
+
+	CreateDeploymentStep(step *models.DeploymentStep) error
+	UpdateDeploymentStep(step *models.DeploymentStep) error
+	ListDeploymentSteps(deploymentID string) ([]*models.DeploymentStep, error)
+
+	GetPortLease(owner, repo, environment string) (*models.PortLease, error)
+	ListPortLeases() ([]*models.PortLease, error)
+	CreatePortLease(l *models.PortLease) error
+	DeletePortLease(owner, repo, environment string) error
+
+	ListEnvVars(owner, repo, environment string) ([]*models.EnvVar, error)
+	SetEnvVar(v *models.EnvVar) error
+	DeleteEnvVar(owner, repo, environment, key string) error
+
+	ListSecrets(owner, repo, environment string) ([]*models.Secret, error)
+	GetSecrets(owner, repo, environment string) ([]*models.Secret, error)
+	SetSecret(sec *models.Secret) error
+	DeleteSecret(owner, repo, environment, name string) error
+
+	GetPRComment(owner, repo string, prNumber int) (int64, error)
+	SetPRComment(owner, repo string, prNumber int, commentID int64) error
+	DeletePRComment(owner, repo string, prNumber int) error
+
+	GetPreview(owner, repo, environment string) (*models.Preview, error)
+	ListPreviews() ([]*models.Preview, error)
+	SavePreview(p *models.Preview) error
+	DeletePreview(owner, repo, environment string) error
+
 	Close() error
 }
 
 type SQLiteStorage struct {
-	db *sql.DB
+	db      *sql.DB
+	secrets cipher.AEAD // nil until SetSecretsKey
 }
 
 func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
@@ -63,10 +103,99 @@ func (s *SQLiteStorage) createTables() error {
 	CREATE INDEX IF NOT EXISTS idx_deployments_status ON deployments(status);
 	CREATE INDEX IF NOT EXISTS idx_deployments_repo ON deployments(owner, repo);
 	CREATE INDEX IF NOT EXISTS idx_deployments_environment ON deployments(environment);
+
+	CREATE TABLE IF NOT EXISTS port_leases (
+		owner TEXT NOT NULL,
+		repo TEXT NOT NULL,
+		environment TEXT NOT NULL,
+		port INTEGER NOT NULL UNIQUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (owner, repo, environment)
+	);
+
+	CREATE TABLE IF NOT EXISTS deployment_steps (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		deployment_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		status TEXT NOT NULL,
+		started_at DATETIME,
+		completed_at DATETIME,
+		exit_code INTEGER,
+		log_offset INTEGER NOT NULL DEFAULT 0,
+		log_length INTEGER NOT NULL DEFAULT 0,
+		error TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_deployment_steps_deployment ON deployment_steps(deployment_id);
+
+	CREATE TABLE IF NOT EXISTS env_vars (
+		owner TEXT NOT NULL,
+		repo TEXT NOT NULL,
+		environment TEXT NOT NULL DEFAULT '',
+		key TEXT NOT NULL,
+		value TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (owner, repo, environment, key)
+	);
+
+	CREATE TABLE IF NOT EXISTS secrets (
+		owner TEXT NOT NULL,
+		repo TEXT NOT NULL,
+		environment TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL,
+		path TEXT NOT NULL DEFAULT '',
+		nonce BLOB NOT NULL,
+		value BLOB NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (owner, repo, environment, name)
+	);
+
+	CREATE TABLE IF NOT EXISTS pr_comments (
+		owner TEXT NOT NULL,
+		repo TEXT NOT NULL,
+		pr_number INTEGER NOT NULL,
+		comment_id INTEGER NOT NULL,
+		PRIMARY KEY (owner, repo, pr_number)
+	);
+
+	CREATE TABLE IF NOT EXISTS previews (
+		owner TEXT NOT NULL,
+		repo TEXT NOT NULL,
+		environment TEXT NOT NULL,
+		pr_number INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		last_push_at DATETIME NOT NULL,
+		ttl INTEGER NOT NULL DEFAULT 0,
+		idle_timeout INTEGER NOT NULL DEFAULT 0,
+		warned_at DATETIME,
+		expired_at DATETIME,
+		PRIMARY KEY (owner, repo, environment)
+	);
 	`
 
-	_, err := s.db.Exec(query)
-	return err
+	if _, err := s.db.Exec(query); err != nil {
+		return err
+	}
+
+	return s.addColumns("deployments", deploymentColumns)
+}
+
+// deploymentColumns were added to the deployments table after its first
+// release and are added to older databases on startup.
+var deploymentColumns = []string{
+	"rollback_of TEXT",
+	"slot TEXT",
+	"runtime TEXT",
+}
+
+func (s *SQLiteStorage) addColumns(table string, columns []string) error {
+	for _, column := range columns {
+		_, err := s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column))
+		if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *SQLiteStorage) CreateDeployment(d *models.Deployment) error {
@@ -74,14 +203,14 @@ func (s *SQLiteStorage) CreateDeployment(d *models.Deployment) error {
 	INSERT INTO deployments (
 		id, owner, repo, ref, sha, clone_url, environment,
 		pr_number, github_deployment_id, status, started_at,
-		log_path, port, project_type
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		log_path, port, project_type, rollback_of, slot, runtime
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
 	_, err := s.db.Exec(query,
 		d.ID, d.Owner, d.Repo, d.Ref, d.SHA, d.CloneURL, d.Environment,
 		d.PRNumber, d.GitHubDeploymentID, d.Status, d.StartedAt,
-		d.LogPath, d.Port, d.ProjectType,
+		d.LogPath, d.Port, d.ProjectType, d.RollbackOf, d.Slot, d.Runtime,
 	)
 
 	return err
@@ -90,41 +219,41 @@ func (s *SQLiteStorage) CreateDeployment(d *models.Deployment) error {
 func (s *SQLiteStorage) UpdateDeployment(d *models.Deployment) error {
 	query := `
 	UPDATE deployments SET
+		github_deployment_id = ?,
 		status = ?,
 		completed_at = ?,
 		url = ?,
 		port = ?,
 		project_type = ?,
 		error = ?,
+		slot = ?,
+		runtime = ?,
 		updated_at = CURRENT_TIMESTAMP
 	WHERE id = ?
 	`
 
 	_, err := s.db.Exec(query,
-		d.Status, d.CompletedAt, d.URL, d.Port, d.ProjectType, d.Error, d.ID,
+		d.GitHubDeploymentID, d.Status, d.CompletedAt, d.URL, d.Port, d.ProjectType, d.Error, d.Slot, d.Runtime, d.ID,
 	)
 
 	return err
 }
 
-func (s *SQLiteStorage) GetDeployment(id string) (*models.Deployment, error) {
-	query := `
-	SELECT id, owner, repo, ref, sha, clone_url, environment,
-		pr_number, github_deployment_id, status, started_at, completed_at,
-		log_path, url, port, project_type, error
-	FROM deployments
-	WHERE id = ?
-	`
+// deploymentFields is the column list scanDeployment expects.
+const deploymentFields = `id, owner, repo, ref, sha, clone_url, environment,
+	pr_number, github_deployment_id, status, started_at, completed_at,
+	log_path, url, port, project_type, error, rollback_of, slot, runtime`
 
+func scanDeployment(row interface{ Scan(...interface{}) error }) (*models.Deployment, error) {
 	var d models.Deployment
 	var completedAt sql.NullTime
-	var url, projectType, errorMsg sql.NullString
+	var url, projectType, errorMsg, rollbackOf, slot, runtime sql.NullString
 	var prNumber, port sql.NullInt64
 
-	err := s.db.QueryRow(query, id).Scan(
+	err := row.Scan(
 		&d.ID, &d.Owner, &d.Repo, &d.Ref, &d.SHA, &d.CloneURL, &d.Environment,
 		&prNumber, &d.GitHubDeploymentID, &d.Status, &d.StartedAt, &completedAt,
-		&d.LogPath, &url, &port, &projectType, &errorMsg,
+		&d.LogPath, &url, &port, &projectType, &errorMsg, &rollbackOf, &slot, &runtime,
 	)
 
 	if err != nil {
@@ -143,13 +272,59 @@ func (s *SQLiteStorage) GetDeployment(id string) (*models.Deployment, error) {
 	if port.Valid {
 		d.Port = int(port.Int64)
 	}
+	if projectType.Valid {
+		d.ProjectType = projectType.String
+	}
 	if errorMsg.Valid {
 		d.Error = errorMsg.String
 	}
+	if rollbackOf.Valid {
+		d.RollbackOf = rollbackOf.String
+	}
+	if slot.Valid {
+		d.Slot = slot.String
+	}
+	if runtime.Valid {
+		d.Runtime = runtime.String
+	}
 
 	return &d, nil
 }
 
+func (s *SQLiteStorage) GetDeployment(id string) (*models.Deployment, error) {
+	query := `SELECT ` + deploymentFields + `
+	FROM deployments
+	WHERE id = ?
+	`
+
+	return scanDeployment(s.db.QueryRow(query, id))
+}
+
+func (s *SQLiteStorage) GetLastSuccessfulDeployment(owner, repo, environment string) (*models.Deployment, error) {
+	query := `SELECT ` + deploymentFields + `
+	FROM deployments
+	WHERE owner = ? AND repo = ? AND environment = ? AND status = 'success'
+	ORDER BY completed_at DESC, started_at DESC
+	LIMIT 1
+	`
+
+	return scanDeployment(s.db.QueryRow(query, owner, repo, environment))
+}
+
+// DeactivateDeployments marks the successful deployments of an environment
+// inactive, once it has been torn down.
+func (s *SQLiteStorage) DeactivateDeployments(owner, repo, environment string) error {
+	query := `
+	UPDATE deployments SET
+		status = 'inactive',
+		updated_at = CURRENT_TIMESTAMP
+	WHERE owner = ? AND repo = ? AND environment = ? AND status = 'success'
+	`
+
+	_, err := s.db.Exec(query, owner, repo, environment)
+	return err
+}
+
 func (s *SQLiteStorage) ListDeployments(limit int) ([]*models.Deployment, error) {
 	query := `
 	SELECT id, owner, repo, ref, sha, environment, status, 
@@ -193,12 +368,10 @@ func (s *SQLiteStorage) ListDeployments(limit int) ([]*models.Deployment, error)
 }
 
 func (s *SQLiteStorage) GetActiveDeployments() ([]*models.Deployment, error) {
-	query := `
-	SELECT id, owner, repo, ref, sha, environment, status,
-		started_at, url, port, project_type
+	query := `SELECT ` + deploymentFields + `
 	FROM deployments
 	WHERE status IN ('in_progress', 'success')
-	ORDER BY started_at DESC
+	ORDER BY completed_at DESC, started_at DESC
 	`
 
 	rows, err := s.db.Query(query)
@@ -209,29 +382,382 @@ func (s *SQLiteStorage) GetActiveDeployments() ([]*models.Deployment, error) {
 
 	var deployments []*models.Deployment
 	for rows.Next() {
-		var d models.Deployment
-		var url, projectType sql.NullString
-		var port sql.NullInt64
+		d, err := scanDeployment(rows)
+		if err != nil {
+			continue
+		}
+		deployments = append(deployments, d)
+	}
+
+	return deployments, nil
+}
+
+// ListDeploymentsByStatus returns every deployment with the given status,
+// oldest first.
+func (s *SQLiteStorage) ListDeploymentsByStatus(status models.DeploymentStatus) ([]*models.Deployment, error) {
+	query := `SELECT ` + deploymentFields + `
+	FROM deployments
+	WHERE status = ?
+	ORDER BY created_at ASC, rowid ASC
+	`
+
+	rows, err := s.db.Query(query, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deployments []*models.Deployment
+	for rows.Next() {
+		d, err := scanDeployment(rows)
+		if err != nil {
+			return nil, err
+		}
+		deployments = append(deployments, d)
+	}
+
+	return deployments, rows.Err()
+}
+
+func (s *SQLiteStorage) CreateDeploymentStep(step *models.DeploymentStep) error {
+	query := `
+	INSERT INTO deployment_steps (
+		deployment_id, name, status, started_at, completed_at,
+		exit_code, log_offset, log_length, error
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := s.db.Exec(query,
+		step.DeploymentID, step.Name, step.Status, step.StartedAt, nullTime(step.CompletedAt),
+		step.ExitCode, step.LogOffset, step.LogLength, step.Error,
+	)
+	if err != nil {
+		return err
+	}
+
+	step.ID, err = result.LastInsertId()
+	return err
+}
+
+func (s *SQLiteStorage) UpdateDeploymentStep(step *models.DeploymentStep) error {
+	query := `
+	UPDATE deployment_steps SET
+		status = ?,
+		completed_at = ?,
+		exit_code = ?,
+		log_length = ?,
+		error = ?
+	WHERE id = ?
+	`
+
+	_, err := s.db.Exec(query,
+		step.Status, nullTime(step.CompletedAt), step.ExitCode, step.LogLength, step.Error, step.ID,
+	)
+
+	return err
+}
+
+// ListDeploymentSteps returns a deployment's steps in the order they ran.
+func (s *SQLiteStorage) ListDeploymentSteps(deploymentID string) ([]*models.DeploymentStep, error) {
+	query := `
+	SELECT id, deployment_id, name, status, started_at, completed_at,
+		exit_code, log_offset, log_length, error
+	FROM deployment_steps
+	WHERE deployment_id = ?
+	ORDER BY id
+	`
+
+	rows, err := s.db.Query(query, deploymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var steps []*models.DeploymentStep
+	for rows.Next() {
+		var step models.DeploymentStep
+		var completedAt sql.NullTime
+		var exitCode sql.NullInt64
+		var errorMsg sql.NullString
 
 		err := rows.Scan(
-			&d.ID, &d.Owner, &d.Repo, &d.Ref, &d.SHA, &d.Environment,
-			&d.Status, &d.StartedAt, &url, &port, &projectType,
+			&step.ID, &step.DeploymentID, &step.Name, &step.Status, &step.StartedAt, &completedAt,
+			&exitCode, &step.LogOffset, &step.LogLength, &errorMsg,
 		)
 		if err != nil {
-			continue
+			return nil, err
 		}
 
-		if url.Valid {
-			d.URL = url.String
+		if completedAt.Valid {
+			step.CompletedAt = completedAt.Time
 		}
-		if port.Valid {
-			d.Port = int(port.Int64)
+		if exitCode.Valid {
+			code := int(exitCode.Int64)
+			step.ExitCode = &code
+		}
+		if errorMsg.Valid {
+			step.Error = errorMsg.String
 		}
 
-		deployments = append(deployments, &d)
+		steps = append(steps, &step)
 	}
 
-	return deployments, nil
+	return steps, rows.Err()
+}
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func (s *SQLiteStorage) GetPortLease(owner, repo, environment string) (*models.PortLease, error) {
+	query := `
+	SELECT owner, repo, environment, port, created_at
+	FROM port_leases
+	WHERE owner = ? AND repo = ? AND environment = ?
+	`
+
+	var l models.PortLease
+	err := s.db.QueryRow(query, owner, repo, environment).Scan(
+		&l.Owner, &l.Repo, &l.Environment, &l.Port, &l.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+func (s *SQLiteStorage) ListPortLeases() ([]*models.PortLease, error) {
+	query := `
+	SELECT owner, repo, environment, port, created_at
+	FROM port_leases
+	ORDER BY port
+	`
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var leases []*models.PortLease
+	for rows.Next() {
+		var l models.PortLease
+		if err := rows.Scan(&l.Owner, &l.Repo, &l.Environment, &l.Port, &l.CreatedAt); err != nil {
+			continue
+		}
+		leases = append(leases, &l)
+	}
+
+	return leases, nil
+}
+
+func (s *SQLiteStorage) CreatePortLease(l *models.PortLease) error {
+	query := `
+	INSERT INTO port_leases (owner, repo, environment, port)
+	VALUES (?, ?, ?, ?)
+	`
+
+	_, err := s.db.Exec(query, l.Owner, l.Repo, l.Environment, l.Port)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return fmt.Errorf("%w: %d", ErrPortTaken, l.Port)
+	}
+	return err
+}
+
+func (s *SQLiteStorage) DeletePortLease(owner, repo, environment string) error {
+	query := `
+	DELETE FROM port_leases
+	WHERE owner = ? AND repo = ? AND environment = ?
+	`
+
+	_, err := s.db.Exec(query, owner, repo, environment)
+	return err
+}
+
+// ListEnvVars returns the variables stored for exactly owner/repo/environment,
+// sorted by key. Pass an empty environment for the repo-wide ones.
+func (s *SQLiteStorage) ListEnvVars(owner, repo, environment string) ([]*models.EnvVar, error) {
+	query := `
+	SELECT owner, repo, environment, key, value, updated_at
+	FROM env_vars
+	WHERE owner = ? AND repo = ? AND environment = ?
+	ORDER BY key
+	`
+
+	rows, err := s.db.Query(query, owner, repo, environment)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vars []*models.EnvVar
+	for rows.Next() {
+		var v models.EnvVar
+		if err := rows.Scan(&v.Owner, &v.Repo, &v.Environment, &v.Key, &v.Value, &v.UpdatedAt); err != nil {
+			return nil, err
+		}
+		vars = append(vars, &v)
+	}
+
+	return vars, rows.Err()
+}
+
+// SetEnvVar creates or replaces a variable.
+func (s *SQLiteStorage) SetEnvVar(v *models.EnvVar) error {
+	query := `
+	INSERT INTO env_vars (owner, repo, environment, key, value)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT (owner, repo, environment, key)
+	DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP
+	`
+
+	_, err := s.db.Exec(query, v.Owner, v.Repo, v.Environment, v.Key, v.Value)
+	return err
+}
+
+// DeleteEnvVar removes a variable, returning sql.ErrNoRows if it isn't set.
+func (s *SQLiteStorage) DeleteEnvVar(owner, repo, environment, key string) error {
+	query := `
+	DELETE FROM env_vars
+	WHERE owner = ? AND repo = ? AND environment = ? AND key = ?
+	`
+
+	result, err := s.db.Exec(query, owner, repo, environment, key)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+// GetPRComment returns the ID of the preview comment on a pull request, or
+// 0 if there is none.
+func (s *SQLiteStorage) GetPRComment(owner, repo string, prNumber int) (int64, error) {
+	query := `
+	SELECT comment_id FROM pr_comments
+	WHERE owner = ? AND repo = ? AND pr_number = ?
+	`
+
+	var id int64
+	err := s.db.QueryRow(query, owner, repo, prNumber).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+func (s *SQLiteStorage) SetPRComment(owner, repo string, prNumber int, commentID int64) error {
+	query := `
+	INSERT INTO pr_comments (owner, repo, pr_number, comment_id)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT (owner, repo, pr_number) DO UPDATE SET comment_id = excluded.comment_id
+	`
+
+	_, err := s.db.Exec(query, owner, repo, prNumber, commentID)
+	return err
+}
+
+func (s *SQLiteStorage) DeletePRComment(owner, repo string, prNumber int) error {
+	query := `
+	DELETE FROM pr_comments
+	WHERE owner = ? AND repo = ? AND pr_number = ?
+	`
+
+	_, err := s.db.Exec(query, owner, repo, prNumber)
+	return err
+}
+
+const previewFields = `owner, repo, environment, pr_number, created_at, last_push_at,
+	ttl, idle_timeout, warned_at, expired_at`
+
+func scanPreview(row interface{ Scan(...interface{}) error }) (*models.Preview, error) {
+	var p models.Preview
+	var warnedAt, expiredAt sql.NullTime
+	err := row.Scan(
+		&p.Owner, &p.Repo, &p.Environment, &p.PRNumber, &p.CreatedAt, &p.LastPushAt,
+		&p.TTL, &p.IdleTimeout, &warnedAt, &expiredAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if warnedAt.Valid {
+		p.WarnedAt = warnedAt.Time
+	}
+	if expiredAt.Valid {
+		p.ExpiredAt = expiredAt.Time
+	}
+	return &p, nil
+}
+
+func (s *SQLiteStorage) GetPreview(owner, repo, environment string) (*models.Preview, error) {
+	query := `SELECT ` + previewFields + `
+	FROM previews
+	WHERE owner = ? AND repo = ? AND environment = ?
+	`
+
+	return scanPreview(s.db.QueryRow(query, owner, repo, environment))
+}
+
+// ListPreviews returns every tracked preview, expired ones included.
+func (s *SQLiteStorage) ListPreviews() ([]*models.Preview, error) {
+	query := `SELECT ` + previewFields + `
+	FROM previews
+	ORDER BY owner, repo, environment
+	`
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var previews []*models.Preview
+	for rows.Next() {
+		p, err := scanPreview(rows)
+		if err != nil {
+			return nil, err
+		}
+		previews = append(previews, p)
+	}
+
+	return previews, rows.Err()
+}
+
+// SavePreview creates or replaces a preview.
+func (s *SQLiteStorage) SavePreview(p *models.Preview) error {
+	query := `
+	INSERT INTO previews (` + previewFields + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (owner, repo, environment) DO UPDATE SET
+		pr_number = excluded.pr_number,
+		created_at = excluded.created_at,
+		last_push_at = excluded.last_push_at,
+		ttl = excluded.ttl,
+		idle_timeout = excluded.idle_timeout,
+		warned_at = excluded.warned_at,
+		expired_at = excluded.expired_at
+	`
+
+	_, err := s.db.Exec(query,
+		p.Owner, p.Repo, p.Environment, p.PRNumber, p.CreatedAt, p.LastPushAt,
+		int64(p.TTL), int64(p.IdleTimeout), nullTime(p.WarnedAt), nullTime(p.ExpiredAt),
+	)
+	return err
+}
+
+func (s *SQLiteStorage) DeletePreview(owner, repo, environment string) error {
+	query := `
+	DELETE FROM previews
+	WHERE owner = ? AND repo = ? AND environment = ?
+	`
+
+	_, err := s.db.Exec(query, owner, repo, environment)
+	return err
 }
 
 func (s *SQLiteStorage) Close() error {
@@ -1,8 +1,10 @@
 package storage
 
 import (
+	"database/sql"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -171,3 +173,362 @@ func TestSQLiteStorageErrors(t *testing.T) {
 		}
 	})
 }
+
+func TestGetLastSuccessfulDeployment(t *testing.T) {
+	dbFile, _ := os.CreateTemp("", "test-*.db")
+	dbPath := dbFile.Name()
+	dbFile.Close()
+	defer os.Remove(dbPath)
+
+	store, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	base := time.Now().Add(-time.Hour)
+	for i, status := range []models.DeploymentStatus{models.StatusSuccess, models.StatusSuccess, models.StatusFailed} {
+		d := &models.Deployment{
+			ID:          fmt.Sprintf("deploy-%d", i),
+			Owner:       "testuser",
+			Repo:        "testrepo",
+			Ref:         "main",
+			SHA:         fmt.Sprintf("sha%d", i),
+			CloneURL:    "https://github.com/testuser/testrepo.git",
+			Environment: "production",
+			Status:      status,
+			StartedAt:   base,
+		}
+		if err := store.CreateDeployment(d); err != nil {
+			t.Fatalf("CreateDeployment() error = %v", err)
+		}
+		d.CompletedAt = base.Add(time.Duration(i) * time.Minute)
+		store.UpdateDeployment(d)
+	}
+
+	last, err := store.GetLastSuccessfulDeployment("testuser", "testrepo", "production")
+	if err != nil {
+		t.Fatalf("GetLastSuccessfulDeployment() error = %v", err)
+	}
+	if last.ID != "deploy-1" {
+		t.Errorf("ID = %v, want deploy-1", last.ID)
+	}
+
+	if _, err := store.GetLastSuccessfulDeployment("testuser", "testrepo", "staging"); err == nil {
+		t.Error("Expected error for environment without deployments")
+	}
+}
+
+func TestServingOrderAgrees(t *testing.T) {
+	dbFile, _ := os.CreateTemp("", "test-*.db")
+	dbPath := dbFile.Name()
+	dbFile.Close()
+	defer os.Remove(dbPath)
+
+	store, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	// slow started first but finished last, so it's the one serving
+	base := time.Now().Add(-time.Hour)
+	for _, d := range []*models.Deployment{
+		{ID: "slow", SHA: "sha0", StartedAt: base, CompletedAt: base.Add(10 * time.Minute)},
+		{ID: "fast", SHA: "sha1", StartedAt: base.Add(time.Minute), CompletedAt: base.Add(5 * time.Minute)},
+	} {
+		d.Owner, d.Repo, d.Ref, d.Environment, d.Status = "testuser", "testrepo", "main", "production", models.StatusSuccess
+		completedAt := d.CompletedAt
+		if err := store.CreateDeployment(d); err != nil {
+			t.Fatalf("CreateDeployment() error = %v", err)
+		}
+		d.CompletedAt = completedAt
+		store.UpdateDeployment(d)
+	}
+
+	last, err := store.GetLastSuccessfulDeployment("testuser", "testrepo", "production")
+	if err != nil {
+		t.Fatalf("GetLastSuccessfulDeployment() error = %v", err)
+	}
+	active, err := store.GetActiveDeployments()
+	if err != nil || len(active) != 2 {
+		t.Fatalf("GetActiveDeployments() = %v, %v", active, err)
+	}
+	if last.ID != "slow" || active[0].ID != "slow" {
+		t.Errorf("last successful = %s, first active = %s, want both slow", last.ID, active[0].ID)
+	}
+}
+
+func TestListDeploymentsByStatus(t *testing.T) {
+	dbFile, _ := os.CreateTemp("", "test-*.db")
+	dbPath := dbFile.Name()
+	dbFile.Close()
+	defer os.Remove(dbPath)
+
+	store, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	statuses := []models.DeploymentStatus{models.StatusQueued, models.StatusSuccess, models.StatusQueued, models.StatusInProgress}
+	for i, status := range statuses {
+		d := &models.Deployment{
+			ID:          fmt.Sprintf("deploy-%d", i),
+			Owner:       "testuser",
+			Repo:        "testrepo",
+			Ref:         "main",
+			SHA:         fmt.Sprintf("sha%d", i),
+			CloneURL:    "https://github.com/testuser/testrepo.git",
+			Environment: "production",
+			Status:      status,
+		}
+		if err := store.CreateDeployment(d); err != nil {
+			t.Fatalf("CreateDeployment() error = %v", err)
+		}
+	}
+
+	queued, err := store.ListDeploymentsByStatus(models.StatusQueued)
+	if err != nil {
+		t.Fatalf("ListDeploymentsByStatus() error = %v", err)
+	}
+	if len(queued) != 2 || queued[0].ID != "deploy-0" || queued[1].ID != "deploy-2" {
+		t.Errorf("queued = %v, want deploy-0 then deploy-2", queued)
+	}
+}
+
+func TestDeploymentSteps(t *testing.T) {
+	dbFile, _ := os.CreateTemp("", "test-*.db")
+	dbPath := dbFile.Name()
+	dbFile.Close()
+	defer os.Remove(dbPath)
+
+	store, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	clone := &models.DeploymentStep{DeploymentID: "deploy-1", Name: "clone", Status: models.StatusInProgress, StartedAt: time.Now()}
+	build := &models.DeploymentStep{DeploymentID: "deploy-1", Name: "build", Status: models.StatusInProgress, StartedAt: time.Now(), LogOffset: 120}
+	for _, step := range []*models.DeploymentStep{clone, build} {
+		if err := store.CreateDeploymentStep(step); err != nil {
+			t.Fatalf("CreateDeploymentStep() error = %v", err)
+		}
+	}
+
+	exitCode := 2
+	build.Status = models.StatusFailed
+	build.CompletedAt = time.Now()
+	build.ExitCode = &exitCode
+	build.LogLength = 300
+	build.Error = "build failed: exit status 2"
+	if err := store.UpdateDeploymentStep(build); err != nil {
+		t.Fatalf("UpdateDeploymentStep() error = %v", err)
+	}
+
+	steps, err := store.ListDeploymentSteps("deploy-1")
+	if err != nil {
+		t.Fatalf("ListDeploymentSteps() error = %v", err)
+	}
+	if len(steps) != 2 || steps[0].Name != "clone" || steps[1].Name != "build" {
+		t.Fatalf("steps = %v, want clone then build", steps)
+	}
+	if steps[0].ExitCode != nil || !steps[0].CompletedAt.IsZero() {
+		t.Errorf("unfinished step = %+v, want no exit code or completion time", steps[0])
+	}
+	got := steps[1]
+	if got.Status != models.StatusFailed || got.ExitCode == nil || *got.ExitCode != 2 ||
+		got.LogOffset != 120 || got.LogLength != 300 || got.Error != build.Error {
+		t.Errorf("failed step = %+v", got)
+	}
+}
+
+func TestEnvVars(t *testing.T) {
+	dbFile, _ := os.CreateTemp("", "test-*.db")
+	dbPath := dbFile.Name()
+	dbFile.Close()
+	defer os.Remove(dbPath)
+
+	store, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	vars := []*models.EnvVar{
+		{Owner: "testuser", Repo: "testrepo", Key: "LOG_LEVEL", Value: "info"},
+		{Owner: "testuser", Repo: "testrepo", Environment: "production", Key: "LOG_LEVEL", Value: "warn"},
+		{Owner: "testuser", Repo: "testrepo", Key: "API_URL", Value: "https://old.example.com"},
+		{Owner: "testuser", Repo: "testrepo", Key: "API_URL", Value: "https://api.example.com"},
+	}
+	for _, v := range vars {
+		if err := store.SetEnvVar(v); err != nil {
+			t.Fatalf("SetEnvVar() error = %v", err)
+		}
+	}
+
+	repoWide, err := store.ListEnvVars("testuser", "testrepo", "")
+	if err != nil {
+		t.Fatalf("ListEnvVars() error = %v", err)
+	}
+	if len(repoWide) != 2 || repoWide[0].Key != "API_URL" || repoWide[0].Value != "https://api.example.com" || repoWide[1].Value != "info" {
+		t.Errorf("repo-wide vars = %+v", repoWide)
+	}
+
+	production, _ := store.ListEnvVars("testuser", "testrepo", "production")
+	if len(production) != 1 || production[0].Value != "warn" {
+		t.Errorf("production vars = %+v", production)
+	}
+
+	if err := store.DeleteEnvVar("testuser", "testrepo", "production", "LOG_LEVEL"); err != nil {
+		t.Fatalf("DeleteEnvVar() error = %v", err)
+	}
+	if err := store.DeleteEnvVar("testuser", "testrepo", "production", "LOG_LEVEL"); err != sql.ErrNoRows {
+		t.Errorf("DeleteEnvVar() of missing var error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestSecrets(t *testing.T) {
+	dbFile, _ := os.CreateTemp("", "test-*.db")
+	dbPath := dbFile.Name()
+	dbFile.Close()
+	defer os.Remove(dbPath)
+
+	store, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	secret := &models.Secret{Owner: "testuser", Repo: "testrepo", Environment: "production", Name: "DB_PASSWORD", Value: "hunter2"}
+	if err := store.SetSecret(secret); err != ErrSecretsDisabled {
+		t.Fatalf("SetSecret() without a key error = %v, want ErrSecretsDisabled", err)
+	}
+
+	key := make([]byte, SecretsKeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	if err := store.SetSecretsKey(key); err != nil {
+		t.Fatalf("SetSecretsKey() error = %v", err)
+	}
+	if err := store.SetSecret(secret); err != nil {
+		t.Fatalf("SetSecret() error = %v", err)
+	}
+
+	var raw []byte
+	store.db.QueryRow("SELECT value FROM secrets WHERE name = 'DB_PASSWORD'").Scan(&raw)
+	if len(raw) == 0 || strings.Contains(string(raw), "hunter2") {
+		t.Errorf("stored value %q is not encrypted", raw)
+	}
+
+	listed, err := store.ListSecrets("testuser", "testrepo", "production")
+	if err != nil {
+		t.Fatalf("ListSecrets() error = %v", err)
+	}
+	if len(listed) != 1 || listed[0].Name != "DB_PASSWORD" || listed[0].Value != "" {
+		t.Errorf("ListSecrets() = %+v, want the name without its value", listed)
+	}
+
+	got, err := store.GetSecrets("testuser", "testrepo", "production")
+	if err != nil {
+		t.Fatalf("GetSecrets() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Value != "hunter2" {
+		t.Errorf("GetSecrets() = %+v, want the decrypted value", got)
+	}
+
+	key[0] = 0xff
+	store.SetSecretsKey(key)
+	if _, err := store.GetSecrets("testuser", "testrepo", "production"); err == nil {
+		t.Error("GetSecrets() with the wrong key should fail")
+	}
+
+	if err := store.DeleteSecret("testuser", "testrepo", "production", "DB_PASSWORD"); err != nil {
+		t.Fatalf("DeleteSecret() error = %v", err)
+	}
+}
+
+func TestPRComments(t *testing.T) {
+	dbFile, _ := os.CreateTemp("", "test-*.db")
+	dbPath := dbFile.Name()
+	dbFile.Close()
+	defer os.Remove(dbPath)
+
+	store, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	if id, err := store.GetPRComment("testuser", "testrepo", 7); err != nil || id != 0 {
+		t.Fatalf("GetPRComment() before any comment = %d, %v; want 0, nil", id, err)
+	}
+
+	for _, id := range []int64{100, 200} {
+		if err := store.SetPRComment("testuser", "testrepo", 7, id); err != nil {
+			t.Fatalf("SetPRComment() error = %v", err)
+		}
+	}
+	if id, _ := store.GetPRComment("testuser", "testrepo", 7); id != 200 {
+		t.Errorf("GetPRComment() = %d, want 200", id)
+	}
+
+	if err := store.DeletePRComment("testuser", "testrepo", 7); err != nil {
+		t.Fatalf("DeletePRComment() error = %v", err)
+	}
+	if id, _ := store.GetPRComment("testuser", "testrepo", 7); id != 0 {
+		t.Errorf("GetPRComment() after delete = %d, want 0", id)
+	}
+}
+
+func TestPreviews(t *testing.T) {
+	dbFile, _ := os.CreateTemp("", "test-*.db")
+	dbPath := dbFile.Name()
+	dbFile.Close()
+	defer os.Remove(dbPath)
+
+	store, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	created := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	preview := &models.Preview{
+		Owner: "testuser", Repo: "testrepo", Environment: "preview-pr-7", PRNumber: 7,
+		CreatedAt: created, LastPushAt: created, TTL: 72 * time.Hour, IdleTimeout: 24 * time.Hour,
+	}
+	if err := store.SavePreview(preview); err != nil {
+		t.Fatalf("SavePreview() error = %v", err)
+	}
+
+	got, err := store.GetPreview("testuser", "testrepo", "preview-pr-7")
+	if err != nil {
+		t.Fatalf("GetPreview() error = %v", err)
+	}
+	if got.PRNumber != 7 || got.TTL != 72*time.Hour || got.IdleTimeout != 24*time.Hour ||
+		!got.CreatedAt.Equal(created) || !got.WarnedAt.IsZero() || !got.ExpiredAt.IsZero() {
+		t.Errorf("GetPreview() = %+v", got)
+	}
+
+	preview.ExpiredAt = created.Add(time.Minute)
+	if err := store.SavePreview(preview); err != nil {
+		t.Fatalf("SavePreview() error = %v", err)
+	}
+	previews, err := store.ListPreviews()
+	if err != nil {
+		t.Fatalf("ListPreviews() error = %v", err)
+	}
+	if len(previews) != 1 || !previews[0].ExpiredAt.Equal(preview.ExpiredAt) {
+		t.Errorf("ListPreviews() = %+v, want the expired preview", previews)
+	}
+
+	if err := store.DeletePreview("testuser", "testrepo", "preview-pr-7"); err != nil {
+		t.Fatalf("DeletePreview() error = %v", err)
+	}
+	if _, err := store.GetPreview("testuser", "testrepo", "preview-pr-7"); err != sql.ErrNoRows {
+		t.Errorf("GetPreview() after delete error = %v, want sql.ErrNoRows", err)
+	}
+}
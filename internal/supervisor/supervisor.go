@@ -0,0 +1,468 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Spec describes an app to keep running.
+type Spec struct {
+	Name    string
+	Dir     string
+	Command string // run with sh -c
+	Env     []string
+}
+
+type State string
+
+const (
+	StateRunning   State = "running"
+	StateBackoff   State = "backoff"   // crashed, waiting to be restarted
+	StateCrashLoop State = "crashloop" // crashed too often, given up on
+)
+
+// Status is what the supervisor knows about one app.
+type Status struct {
+	Name      string
+	State     State
+	Pid       int
+	Restarts  int // crashes in a row, reset once the app stays up
+	StartedAt time.Time
+	LastExit  string
+}
+
+// Supervisor runs apps as detached process groups, restarts them when
+// they crash and writes their output to rotated per-app log files. Apps
+// outlive the supervisor: a pid file per app lets the next one adopt them.
+type Supervisor struct {
+	runDir string // pid files
+	logDir string // app output
+
+	MinBackoff        time.Duration // first restart delay, doubled per crash
+	MaxBackoff        time.Duration
+	StableAfter       time.Duration // uptime after which a crash is not counted against the app
+	CrashLoopRestarts int           // crashes in a row before giving up
+	MaxLogSize        int64         // bytes before a log is rotated
+	MaxLogFiles       int           // rotated logs kept per app
+	StopTimeout       time.Duration // grace period between SIGTERM and SIGKILL
+
+	mu     sync.Mutex
+	procs  map[string]*process
+	closed bool
+	done   chan struct{} // closed by Close
+}
+
+type process struct {
+	spec      Spec
+	pid       int
+	state     State
+	restarts  int
+	startedAt time.Time
+	lastExit  string
+	stop      chan struct{} // closed by Stop
+	exited    chan struct{} // closed when monitoring ends
+}
+
+// pidFile is what is stored in runDir for each app. It holds the app's
+// environment, so it is only readable by dockrune's user.
+type pidFile struct {
+	Spec      Spec
+	Pid       int
+	StartedAt time.Time
+}
+
+// New returns a supervisor keeping pid files in runDir and app output in
+// logDir.
+func New(runDir, logDir string) *Supervisor {
+	s := &Supervisor{
+		runDir:            runDir,
+		logDir:            logDir,
+		MinBackoff:        time.Second,
+		MaxBackoff:        time.Minute,
+		StableAfter:       30 * time.Second,
+		CrashLoopRestarts: 5,
+		MaxLogSize:        10 << 20,
+		MaxLogFiles:       3,
+		StopTimeout:       10 * time.Second,
+		procs:             make(map[string]*process),
+		done:              make(chan struct{}),
+	}
+	go s.rotateLogs()
+	return s
+}
+
+// LogPath is where the output of the named app goes.
+func (s *Supervisor) LogPath(name string) string {
+	return filepath.Join(s.logDir, name+".log")
+}
+
+func (s *Supervisor) pidPath(name string) string {
+	return filepath.Join(s.runDir, name+".json")
+}
+
+// Start launches an app and keeps it running. An app already running under
+// the same name is stopped first.
+func (s *Supervisor) Start(spec Spec) error {
+	s.Stop(spec.Name)
+
+	if err := os.MkdirAll(s.runDir, 0700); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.logDir, 0755); err != nil {
+		return err
+	}
+
+	p := &process{spec: spec, stop: make(chan struct{}), exited: make(chan struct{})}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("supervisor is closed")
+	}
+	cmd, err := s.spawn(p)
+	if err != nil {
+		return err
+	}
+	s.procs[spec.Name] = p
+
+	go s.monitor(p, cmd.Wait)
+	return nil
+}
+
+// spawn starts p's command in its own process group with its output
+// appended to its log, and records it in a pid file. s.mu must be held.
+func (s *Supervisor) spawn(p *process) (*exec.Cmd, error) {
+	logPath := s.LogPath(p.spec.Name)
+	s.rotateIfFull(logPath)
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// The child keeps its own descriptor
+	defer logFile.Close()
+
+	// exec, so the app itself leads the process group and owns the pid
+	cmd := exec.Command("sh", "-c", "exec "+p.spec.Command)
+	cmd.Dir = p.spec.Dir
+	cmd.Env = p.spec.Env
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	p.pid = cmd.Process.Pid
+	p.state = StateRunning
+	p.startedAt = time.Now()
+	if err := s.writePidFile(p); err != nil {
+		log.Printf("Failed to write pid file of %s: %v", p.spec.Name, err)
+	}
+	return cmd, nil
+}
+
+func (s *Supervisor) writePidFile(p *process) error {
+	data, err := json.Marshal(pidFile{Spec: p.spec, Pid: p.pid, StartedAt: p.startedAt})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.pidPath(p.spec.Name), data, 0600)
+}
+
+// monitor waits for p to exit and restarts it with exponential backoff
+// until it is stopped, the supervisor is closed or it crash loops.
+func (s *Supervisor) monitor(p *process, wait func() error) {
+	defer close(p.exited)
+
+	backoff := s.MinBackoff
+	for {
+		err := wait()
+
+		s.mu.Lock()
+		select {
+		case <-p.stop:
+			s.mu.Unlock()
+			return
+		default:
+		}
+		if s.closed {
+			// Left for the next supervisor to restart
+			s.mu.Unlock()
+			return
+		}
+
+		p.lastExit = exitReason(err)
+		if time.Since(p.startedAt) >= s.StableAfter {
+			p.restarts = 0
+			backoff = s.MinBackoff
+		}
+		p.restarts++
+		if p.restarts >= s.CrashLoopRestarts {
+			p.state = StateCrashLoop
+			p.pid = 0
+			os.Remove(s.pidPath(p.spec.Name))
+			log.Printf("%s crashed %d times in a row (%s), not restarting it", p.spec.Name, p.restarts, p.lastExit)
+			s.mu.Unlock()
+			return
+		}
+		p.state = StateBackoff
+		p.pid = 0
+		log.Printf("%s %s, restarting in %s", p.spec.Name, p.lastExit, backoff)
+		s.mu.Unlock()
+
+		select {
+		case <-time.After(backoff):
+		case <-p.stop:
+			return
+		case <-s.done:
+			return
+		}
+		backoff = min(backoff*2, s.MaxBackoff)
+
+		s.mu.Lock()
+		select {
+		case <-p.stop:
+			s.mu.Unlock()
+			return
+		default:
+		}
+		cmd, err := s.spawn(p)
+		if err != nil {
+			// Counts as a crash on the next round
+			p.lastExit = err.Error()
+			s.mu.Unlock()
+			wait = func() error { return err }
+			continue
+		}
+		s.mu.Unlock()
+		wait = cmd.Wait
+	}
+}
+
+func exitReason(err error) string {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return "exited with status 0"
+	case errors.As(err, &exitErr):
+		return exitErr.ProcessState.String()
+	default:
+		return err.Error()
+	}
+}
+
+// Stop stops the named app: SIGTERM to its process group, then SIGKILL if
+// it hasn't exited after StopTimeout. Unknown names are ignored.
+func (s *Supervisor) Stop(name string) {
+	s.mu.Lock()
+	p, ok := s.procs[name]
+	if ok {
+		delete(s.procs, name)
+		close(p.stop)
+	}
+	pid := 0
+	if ok {
+		pid = p.pid
+	}
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if pid > 0 {
+		syscall.Kill(-pid, syscall.SIGTERM)
+		select {
+		case <-p.exited:
+		case <-time.After(s.StopTimeout):
+		}
+		// Whatever else is left in the group goes too
+		syscall.Kill(-pid, syscall.SIGKILL)
+	}
+	<-p.exited
+	os.Remove(s.pidPath(name))
+}
+
+// Status reports on the named app.
+func (s *Supervisor) Status(name string) (Status, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.procs[name]
+	if !ok {
+		return Status{}, false
+	}
+	return Status{
+		Name:      name,
+		State:     p.state,
+		Pid:       p.pid,
+		Restarts:  p.restarts,
+		StartedAt: p.startedAt,
+		LastExit:  p.lastExit,
+	}, true
+}
+
+// Adopt takes over the apps recorded in pid files by an earlier
+// supervisor. Those still running are watched again, those that died while
+// nobody was watching are restarted.
+func (s *Supervisor) Adopt() error {
+	entries, err := os.ReadDir(s.runDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.runDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		var pf pidFile
+		if err := json.Unmarshal(data, &pf); err != nil {
+			log.Printf("Ignoring unreadable pid file %s: %v", entry.Name(), err)
+			continue
+		}
+
+		p := &process{spec: pf.Spec, stop: make(chan struct{}), exited: make(chan struct{})}
+
+		s.mu.Lock()
+		if _, ok := s.procs[pf.Spec.Name]; ok {
+			s.mu.Unlock()
+			continue
+		}
+		if alive(pf.Pid) {
+			p.pid = pf.Pid
+			p.state = StateRunning
+			p.startedAt = pf.StartedAt
+			s.procs[pf.Spec.Name] = p
+			go s.monitor(p, func() error { return waitPid(pf.Pid, p.stop) })
+			log.Printf("Adopted %s (pid %d)", pf.Spec.Name, pf.Pid)
+		} else {
+			cmd, err := s.spawn(p)
+			if err != nil {
+				s.mu.Unlock()
+				log.Printf("Failed to restart %s: %v", pf.Spec.Name, err)
+				continue
+			}
+			s.procs[pf.Spec.Name] = p
+			go s.monitor(p, cmd.Wait)
+			log.Printf("Restarted %s, which exited while dockrune was down", pf.Spec.Name)
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// alive reports whether pid is still the leader of its own process group,
+// which every app the supervisor starts is. A pid reused by an unrelated
+// process almost never is.
+func alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	pgid, err := syscall.Getpgid(pid)
+	return err == nil && pgid == pid && syscall.Kill(pid, 0) == nil
+}
+
+// waitPid polls until pid, which isn't our child, has exited or stop is
+// closed.
+func waitPid(pid int, stop <-chan struct{}) error {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for alive(pid) {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			// Stop signalled it; it's ours to wait for until gone
+			for alive(pid) {
+				time.Sleep(100 * time.Millisecond)
+			}
+			return nil
+		}
+	}
+	return errors.New("exited")
+}
+
+// Close stops watching apps without stopping them, so they keep running
+// for the next supervisor to adopt.
+func (s *Supervisor) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+}
+
+// Tail returns the last n lines the named app wrote.
+func (s *Supervisor) Tail(name string, n int) (string, error) {
+	data, err := os.ReadFile(s.LogPath(name))
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// rotateLogs rotates the logs of running apps as they fill up, until the
+// supervisor is closed.
+func (s *Supervisor) rotateLogs() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+
+		s.mu.Lock()
+		for name := range s.procs {
+			s.rotateIfFull(s.LogPath(name))
+		}
+		s.mu.Unlock()
+	}
+}
+
+// rotateIfFull moves a log over MaxLogSize to path.1, shifting older ones
+// up and dropping the oldest. The app keeps its descriptor, so the log is
+// copied and truncated rather than renamed; its writes are appends and
+// carry on at the start of the emptied file.
+func (s *Supervisor) rotateIfFull(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() < s.MaxLogSize {
+		return
+	}
+
+	if s.MaxLogFiles > 0 {
+		for i := s.MaxLogFiles - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+		}
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = os.WriteFile(path+".1", data, 0644)
+		}
+		if err != nil {
+			log.Printf("Failed to rotate %s: %v", path, err)
+			return
+		}
+	}
+	os.Truncate(path, 0)
+}
@@ -0,0 +1,148 @@
+package supervisor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newTestSupervisor(t *testing.T) *Supervisor {
+	dir := t.TempDir()
+	s := New(filepath.Join(dir, "run"), filepath.Join(dir, "logs"))
+	s.MinBackoff = 10 * time.Millisecond
+	s.MaxBackoff = 50 * time.Millisecond
+	s.StopTimeout = time.Second
+	t.Cleanup(s.Close)
+	return s
+}
+
+// waitFor polls cond for up to five seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStartAndStop(t *testing.T) {
+	s := newTestSupervisor(t)
+
+	err := s.Start(Spec{Name: "app", Dir: t.TempDir(), Command: "sh -c 'echo hello from $GREETING; sleep 60'", Env: []string{"GREETING=env"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, ok := s.Status("app")
+	if !ok || status.State != StateRunning || status.Pid == 0 {
+		t.Fatalf("status = %+v, want running", status)
+	}
+
+	waitFor(t, "output", func() bool {
+		out, _ := s.Tail("app", 10)
+		return out == "hello from env"
+	})
+
+	s.Stop("app")
+	if syscall.Kill(status.Pid, 0) == nil {
+		t.Error("app still running after Stop")
+	}
+	if _, ok := s.Status("app"); ok {
+		t.Error("stopped app still has a status")
+	}
+	if _, err := os.Stat(s.pidPath("app")); !os.IsNotExist(err) {
+		t.Error("pid file left behind after Stop")
+	}
+}
+
+func TestRestartOnCrash(t *testing.T) {
+	s := newTestSupervisor(t)
+	s.StableAfter = 0 // every run counts as stable, so it never gives up
+
+	marker := filepath.Join(t.TempDir(), "started")
+	// Crashes on its first run, stays up on the second
+	err := s.Start(Spec{Name: "app", Command: "sh -c 'if [ -e " + marker + " ]; then sleep 60; else touch " + marker + "; exit 1; fi'"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop("app")
+
+	waitFor(t, "restart", func() bool {
+		status, _ := s.Status("app")
+		return status.State == StateRunning && status.Restarts == 1
+	})
+	status, _ := s.Status("app")
+	if !strings.Contains(status.LastExit, "exit status 1") {
+		t.Errorf("LastExit = %q, want the crash's exit status", status.LastExit)
+	}
+}
+
+func TestCrashLoop(t *testing.T) {
+	s := newTestSupervisor(t)
+	s.CrashLoopRestarts = 3
+
+	if err := s.Start(Spec{Name: "app", Command: "false"}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop("app")
+
+	waitFor(t, "crash loop", func() bool {
+		status, _ := s.Status("app")
+		return status.State == StateCrashLoop
+	})
+	status, _ := s.Status("app")
+	if status.Restarts != 3 || status.Pid != 0 {
+		t.Errorf("status = %+v, want given up after 3 crashes", status)
+	}
+}
+
+func TestAdopt(t *testing.T) {
+	dir := t.TempDir()
+	first := New(filepath.Join(dir, "run"), filepath.Join(dir, "logs"))
+	if err := first.Start(Spec{Name: "app", Command: "sleep 60"}); err != nil {
+		t.Fatal(err)
+	}
+	running, _ := first.Status("app")
+	first.Close()
+
+	second := New(filepath.Join(dir, "run"), filepath.Join(dir, "logs"))
+	second.StopTimeout = time.Second
+	defer second.Close()
+	if err := second.Adopt(); err != nil {
+		t.Fatal(err)
+	}
+
+	adopted, ok := second.Status("app")
+	if !ok || adopted.State != StateRunning || adopted.Pid != running.Pid {
+		t.Fatalf("adopted = %+v, want pid %d running", adopted, running.Pid)
+	}
+
+	second.Stop("app")
+	waitFor(t, "adopted app to exit", func() bool { return !alive(running.Pid) })
+}
+
+func TestRotateIfFull(t *testing.T) {
+	s := newTestSupervisor(t)
+	s.MaxLogSize = 10
+	s.MaxLogFiles = 2
+
+	path := filepath.Join(t.TempDir(), "app.log")
+	for _, content := range []string{"first run\n", "second run\n", "third run\n"} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		s.rotateIfFull(path)
+	}
+
+	for name, want := range map[string]string{"app.log": "", "app.log.1": "third run\n", "app.log.2": "second run\n"} {
+		got, _ := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
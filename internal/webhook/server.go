@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"strings"
 
@@ -79,6 +80,10 @@ func (s *Server) handleGitHubWebhook(c *gin.Context) {
 		s.handlePushEvent(c, body)
 	case "pull_request":
 		s.handlePullRequestEvent(c, body)
+	case "delete":
+		s.handleDeleteEvent(c, body)
+	case "issue_comment":
+		s.handleIssueCommentEvent(c, body)
 	case "ping":
 		c.JSON(http.StatusOK, gin.H{"message": "pong"})
 	default:
@@ -110,6 +115,13 @@ func (s *Server) handlePushEvent(c *gin.Context, body []byte) {
 		return
 	}
 
+	// Deleting a branch pushes the zero SHA; the delete event tears down
+	// its preview
+	if event.Deleted || strings.Trim(event.After, "0") == "" {
+		c.JSON(http.StatusOK, gin.H{"message": "No action taken"})
+		return
+	}
+
 	// Extract deployment info
 	deployment := &models.Deployment{
 		Owner:       event.Repository.Owner.Login,
@@ -120,21 +132,8 @@ func (s *Server) handlePushEvent(c *gin.Context, body []byte) {
 		Environment: s.getEnvironmentFromRef(event.Ref),
 	}
 
-	// Create GitHub deployment
-	if s.github != nil {
-		deploymentID, err := s.github.CreateDeployment(
-			deployment.Owner,
-			deployment.Repo,
-			deployment.SHA,
-			deployment.Environment,
-		)
-		if err == nil {
-			deployment.GitHubDeploymentID = deploymentID
-		}
-	}
-
-	// Queue deployment
-	if err := s.deployer.QueueDeployment(deployment); err != nil {
+	// Create a GitHub deployment and queue ours
+	if err := s.queueDeployment(deployment); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue deployment"})
 		return
 	}
@@ -153,6 +152,18 @@ func (s *Server) handlePullRequestEvent(c *gin.Context, body []byte) {
 		return
 	}
 
+	// A closed PR (merged or not) takes its preview with it
+	if event.Action == "closed" {
+		environment := previewEnvironment(event.PullRequest.Number)
+		s.teardown(event.Repository.Owner.Login, event.Repository.Name, environment)
+		c.JSON(http.StatusOK, gin.H{
+			"message":     "Preview teardown started",
+			"pr":          event.PullRequest.Number,
+			"environment": environment,
+		})
+		return
+	}
+
 	// Only deploy on opened/synchronize events
 	if event.Action != "opened" && event.Action != "synchronize" {
 		c.JSON(http.StatusOK, gin.H{"message": "No action taken"})
@@ -166,11 +177,123 @@ func (s *Server) handlePullRequestEvent(c *gin.Context, body []byte) {
 		Ref:         event.PullRequest.Head.Ref,
 		SHA:         event.PullRequest.Head.SHA,
 		CloneURL:    event.Repository.CloneURL,
-		Environment: fmt.Sprintf("preview-pr-%d", event.PullRequest.Number),
+		Environment: previewEnvironment(event.PullRequest.Number),
 		PRNumber:    event.PullRequest.Number,
 	}
 
-	// Create GitHub deployment
+	// Create a GitHub deployment and queue ours
+	if err := s.queueDeployment(deployment); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue deployment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Preview deployment queued",
+		"pr":          event.PullRequest.Number,
+		"environment": deployment.Environment,
+	})
+}
+
+// handleDeleteEvent tears down the preview of a deleted branch. Branches
+// deploying to production, staging or development are left alone.
+func (s *Server) handleDeleteEvent(c *gin.Context, body []byte) {
+	var event DeleteEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse delete event"})
+		return
+	}
+
+	environment, ok := s.deletedPreview(event)
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"message": "No action taken"})
+		return
+	}
+
+	s.teardown(event.Repository.Owner.Login, event.Repository.Name, environment)
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Preview teardown started",
+		"environment": environment,
+	})
+}
+
+// deletedPreview returns the preview environment of the branch a delete
+// event removed, if it had one.
+func (s *Server) deletedPreview(event DeleteEvent) (string, bool) {
+	if event.RefType != "branch" {
+		return "", false
+	}
+	environment := s.getEnvironmentFromRef("refs/heads/" + event.Ref)
+	if !strings.HasPrefix(environment, "preview-") {
+		return "", false
+	}
+	return environment, true
+}
+
+// handleIssueCommentEvent redeploys a pull request's preview, waking it up
+// if it expired, when the wake command is commented on the pull request.
+func (s *Server) handleIssueCommentEvent(c *gin.Context, body []byte) {
+	var event IssueCommentEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse issue comment event"})
+		return
+	}
+
+	// The comment doesn't say what the pull request's head is, so we need
+	// GitHub to look it up
+	if !wakeRequested(event) || s.github == nil {
+		c.JSON(http.StatusOK, gin.H{"message": "No action taken"})
+		return
+	}
+
+	owner, repo := event.Repository.Owner.Login, event.Repository.Name
+	pr, err := s.github.GetPullRequest(owner, repo, event.Issue.Number)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to look up pull request"})
+		return
+	}
+	if pr.State != "open" {
+		c.JSON(http.StatusOK, gin.H{"message": "No action taken, pull request is closed"})
+		return
+	}
+
+	deployment := &models.Deployment{
+		Owner:       owner,
+		Repo:        repo,
+		Ref:         pr.HeadRef,
+		SHA:         pr.HeadSHA,
+		CloneURL:    event.Repository.CloneURL,
+		Environment: previewEnvironment(pr.Number),
+		PRNumber:    pr.Number,
+	}
+	if err := s.queueDeployment(deployment); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue deployment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Preview deployment queued",
+		"pr":          pr.Number,
+		"environment": deployment.Environment,
+	})
+}
+
+// wakeRequested reports whether a comment event is the wake command, newly
+// posted on a pull request by someone with a say over the repo.
+func wakeRequested(event IssueCommentEvent) bool {
+	if event.Action != "created" || event.Issue.PullRequest == nil {
+		return false
+	}
+	switch event.Comment.AuthorAssociation {
+	case "OWNER", "MEMBER", "COLLABORATOR":
+	default:
+		return false
+	}
+	return strings.TrimSpace(event.Comment.Body) == deployer.WakeCommand
+}
+
+// queueDeployment creates a GitHub deployment for deployment, if we can,
+// and queues it.
+func (s *Server) queueDeployment(deployment *models.Deployment) error {
 	if s.github != nil {
 		deploymentID, err := s.github.CreateDeployment(
 			deployment.Owner,
@@ -183,17 +306,23 @@ func (s *Server) handlePullRequestEvent(c *gin.Context, body []byte) {
 		}
 	}
 
-	// Queue deployment
-	if err := s.deployer.QueueDeployment(deployment); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue deployment"})
-		return
-	}
+	return s.deployer.QueueDeployment(deployment)
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":     "Preview deployment queued",
-		"pr":          event.PullRequest.Number,
-		"environment": deployment.Environment,
-	})
+// teardown removes an environment in the background; waiting for a running
+// deployment of it to be cancelled can take longer than GitHub waits for a
+// webhook response.
+func (s *Server) teardown(owner, repo, environment string) {
+	go func() {
+		if err := s.deployer.TeardownEnvironment(owner, repo, environment); err != nil {
+			log.Printf("Failed to tear down %s/%s %s: %v", owner, repo, environment, err)
+		}
+	}()
+}
+
+// previewEnvironment is the environment of a pull request's preview.
+func previewEnvironment(prNumber int) string {
+	return fmt.Sprintf("preview-pr-%d", prNumber)
 }
 
 func (s *Server) getEnvironmentFromRef(ref string) string {
@@ -234,6 +363,40 @@ type PushEvent struct {
 	Ref        string `json:"ref"`
 	Before     string `json:"before"`
 	After      string `json:"after"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		Name     string `json:"name"`
+		CloneURL string `json:"clone_url"`
+		Owner    struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+}
+
+type DeleteEvent struct {
+	Ref        string `json:"ref"`
+	RefType    string `json:"ref_type"`
+	Repository struct {
+		Name     string `json:"name"`
+		CloneURL string `json:"clone_url"`
+		Owner    struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+}
+
+type IssueCommentEvent struct {
+	Action string `json:"action"`
+	Issue  struct {
+		Number      int `json:"number"`
+		PullRequest *struct {
+			URL string `json:"url"`
+		} `json:"pull_request"` // only set on pull requests
+	} `json:"issue"`
+	Comment struct {
+		Body              string `json:"body"`
+		AuthorAssociation string `json:"author_association"`
+	} `json:"comment"`
 	Repository struct {
 		Name     string `json:"name"`
 		CloneURL string `json:"clone_url"`
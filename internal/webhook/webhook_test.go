@@ -7,6 +7,7 @@ import (
 	"encoding/hex"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/ejfox/dockrune/internal/config"
@@ -149,8 +150,98 @@ func TestGitHubWebhookHandler(t *testing.T) {
 	}
 }
 
+func TestDeletedBranchPush(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	secret := "test-secret"
+	server := NewServer(&config.Config{WebhookSecret: secret}, nil, nil)
+
+	payloads := []string{
+		`{"ref": "refs/heads/feature-login", "after": "0000000000000000000000000000000000000000", "deleted": true, "repository": {"name": "site", "owner": {"login": "ejfox"}}}`,
+		`{"ref": "refs/heads/feature-login", "after": "0000000000000000000000000000000000000000", "repository": {"name": "site", "owner": {"login": "ejfox"}}}`,
+	}
+	for _, payload := range payloads {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		req, _ := http.NewRequest("POST", "/webhook/github", bytes.NewBufferString(payload))
+		req.Header.Set("X-Hub-Signature-256", computeSignature([]byte(payload), secret))
+		req.Header.Set("X-GitHub-Event", "push")
+		c.Request = req
+
+		server.handleGitHubWebhook(c)
+
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "No action taken") {
+			t.Errorf("push of a deleted branch = %d %s, want no action taken", w.Code, w.Body.String())
+		}
+	}
+}
+
 func computeSignature(payload []byte, secret string) string {
 	mac := hmac.New(sha256.New, []byte(secret))
 	mac.Write(payload)
 	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
 }
+
+func TestDeletedPreview(t *testing.T) {
+	server := &Server{config: &config.Config{}}
+
+	tests := []struct {
+		ref, refType string
+		want         string
+		wantOK       bool
+	}{
+		{"feature-login", "branch", "preview-feature-login", true},
+		{"user/feature-login", "branch", "preview-feature-login", true},
+		{"main", "branch", "", false},
+		{"staging", "branch", "", false},
+		{"v1.0.0", "tag", "", false},
+	}
+
+	for _, tt := range tests {
+		var event DeleteEvent
+		event.Ref = tt.ref
+		event.RefType = tt.refType
+
+		got, ok := server.deletedPreview(event)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("deletedPreview(%s %s) = %q, %v; want %q, %v", tt.refType, tt.ref, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestWakeRequested(t *testing.T) {
+	tests := []struct {
+		name        string
+		action      string
+		onPR        bool
+		association string
+		body        string
+		want        bool
+	}{
+		{"collaborator on PR", "created", true, "COLLABORATOR", "/dockrune wake", true},
+		{"surrounding whitespace", "created", true, "OWNER", "  /dockrune wake\n", true},
+		{"edited comment", "edited", true, "OWNER", "/dockrune wake", false},
+		{"plain issue", "created", false, "OWNER", "/dockrune wake", false},
+		{"outside contributor", "created", true, "CONTRIBUTOR", "/dockrune wake", false},
+		{"other comment", "created", true, "MEMBER", "looks good, /dockrune wake later", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var event IssueCommentEvent
+			event.Action = tt.action
+			if tt.onPR {
+				event.Issue.PullRequest = &struct {
+					URL string `json:"url"`
+				}{}
+			}
+			event.Comment.AuthorAssociation = tt.association
+			event.Comment.Body = tt.body
+
+			if got := wakeRequested(event); got != tt.want {
+				t.Errorf("wakeRequested() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}